@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -16,6 +20,8 @@ import (
 	conf "github.com/xoxloviwan/go-monitor/internal/config_agent"
 	metrs "github.com/xoxloviwan/go-monitor/internal/metrics"
 	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+	"github.com/xoxloviwan/go-monitor/internal/outbox"
+	"github.com/xoxloviwan/go-monitor/internal/tlsconfig"
 )
 
 var (
@@ -43,6 +49,66 @@ func SplitBatch(source <-chan api.Metrics, poolSize int) []<-chan api.Metrics {
 	return dests
 }
 
+// report sends the metrics from msgCh split between rateLimit workers and waits for all of them.
+//
+// The batches of the outbox are replayed first. Batches failed with a temporary error are pushed to the outbox,
+// a nil outbox drops them.
+func report(sender clients.Sender, ob *outbox.Outbox, msgCh <-chan api.Metrics, rateLimit int) {
+	// Сначала отправляются сохраненные пакеты, чтобы сервер получил значения в порядке измерения.
+	if ob != nil {
+		err := ob.Replay(context.Background(), func(ctx context.Context, batchID string, msgs api.MetricsList) error {
+			return sender.Send(api.WithOutgoingBatchID(ctx, batchID), 0, msgs)
+		}, base.Temporary)
+		if err != nil {
+			// Сервер по-прежнему недоступен, новый пакет ставится в очередь за старыми.
+			slog.Warn("Outbox replay error", "error", err)
+			var batch api.MetricsList
+			for val := range msgCh {
+				batch = append(batch, val)
+			}
+			// Пакет не отправлялся, поэтому его можно объединить с другими.
+			if err = ob.Push("", batch); err != nil {
+				slog.Error("Outbox push error", "error", err)
+			}
+			return
+		}
+	}
+	dests := SplitBatch(msgCh, rateLimit) // Fan Out
+
+	var wg sync.WaitGroup
+	wg.Add(len(dests))
+	for i, ch := range dests {
+		go func(worker int, d <-chan api.Metrics) {
+			defer wg.Done()
+			subbatch := make([]api.Metrics, 0)
+			for val := range d {
+				subbatch = append(subbatch, val)
+			}
+			if len(subbatch) > 0 {
+				slog.Info("Worker got task", "worker", worker, "subbatch", subbatch)
+				// Пакет сохраняется с ключом отправки: если сервер его применил, а ответ потерялся, повтор будет проигнорирован.
+				batchID := api.NewBatchID()
+				err := sender.Send(api.WithOutgoingBatchID(context.Background(), batchID), worker, subbatch)
+				if err != nil {
+					slog.Error("Send error", "worker", worker, "error", err)
+				}
+				if err != nil && ob != nil && base.Temporary(err) {
+					if err = ob.Push(batchID, subbatch); err != nil {
+						slog.Error("Outbox push error", "worker", worker, "error", err)
+					}
+				}
+			}
+		}(i, ch)
+	}
+	wg.Wait()
+}
+
+// fatal logs the error and stops the agent.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
 func main() {
 	fmt.Printf("Build version: %s\nBuild date: %s\nBuild commit: %s\n", buildVersion, buildDate, buildCommit)
 	cfg := conf.InitConfig()
@@ -55,51 +121,131 @@ func main() {
 			publicKey = nil
 		}
 	}
+	var tlsCfg *tls.Config
+	if cfg.TLSCA != "" || cfg.TLSCert != "" {
+		var err error
+		tlsCfg, err = tlsconfig.Client(cfg.TLSCA, cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			fatal("Error loading TLS config", "error", err)
+		}
+	}
+	var signKey ed25519.PrivateKey
+	if cfg.SignKey != "" {
+		var err error
+		if signKey, err = asc.GetSigningKey(cfg.SignKey); err != nil {
+			fatal("Error loading signing key", "error", err)
+		}
+		if cfg.AgentID == "" {
+			if cfg.AgentID, err = os.Hostname(); err != nil {
+				fatal("Error getting agent ID", "error", err)
+			}
+		}
+	}
 	localIP, _ := base.GetIP()
 	if cfg.GRPC != "" {
 		cfg.Address = cfg.GRPC
 	}
-	sender := clients.NewSender(cfg.GRPC != "", cfg.Address, cfg.Key, localIP.String(), publicKey)
-	pollTicker := time.NewTicker(time.Duration(cfg.PollInterval) * time.Second)
+	// Пакет должен быть отправлен до следующего, если общий таймаут не задан.
+	sendTimeout := cfg.SendTimeout
+	if sendTimeout == 0 {
+		sendTimeout = cfg.ReportInterval
+	}
+	sender, err := clients.NewSender(cfg.GRPC != "", base.Client{
+		Addr:           cfg.Address,
+		Key:            cfg.Key,
+		LocalIP:        localIP.String(),
+		PublicKey:      publicKey,
+		Token:          cfg.Token,
+		TLS:            tlsCfg,
+		AgentID:        cfg.AgentID,
+		SignKey:        signKey,
+		DialTimeout:    time.Duration(cfg.DialTimeout) * time.Second,
+		RequestTimeout: time.Duration(cfg.RequestTimeout) * time.Second,
+		Timeout:        time.Duration(sendTimeout) * time.Second,
+		MaxIdleConns:   cfg.RateLimit,
+		Retry: base.RetryPolicy{
+			MaxRetries: cfg.Retries,
+			BaseDelay:  time.Duration(cfg.RetryDelay) * time.Second,
+			MaxDelay:   time.Duration(cfg.RetryMaxDelay) * time.Second,
+			Jitter:     cfg.RetryJitter,
+		},
+		Breaker: base.NewBreaker(cfg.BreakerThreshold, time.Duration(cfg.BreakerCooldown)*time.Second),
+	})
+	if err != nil {
+		fatal("Error creating sender", "error", err)
+	}
+	defer sender.Close()
+	var ob *outbox.Outbox
+	if cfg.OutboxDir != "" {
+		ob, err = outbox.Open(cfg.OutboxDir, cfg.OutboxMaxSize<<20, time.Duration(cfg.OutboxMaxAge)*time.Second)
+		if err != nil {
+			fatal("Error opening outbox", "error", err)
+		}
+	}
+	specs, err := metrs.ParseSpecs(cfg.Collectors)
+	if err != nil {
+		fatal("Error parsing collectors", "error", err)
+	}
+	opts := metrs.Options{RuntimeMemStats: cfg.RuntimeMemStats}
+	if opts.DiskMountpoints, err = metrs.NewFilter(cfg.DiskMountpoints, cfg.DiskExcludeMountpoints); err != nil {
+		fatal("Error parsing disk mountpoints", "error", err)
+	}
+	if opts.DiskFSTypes, err = metrs.NewFilter(cfg.DiskFSTypes, cfg.DiskExcludeFSTypes); err != nil {
+		fatal("Error parsing disk filesystem types", "error", err)
+	}
+	if opts.NetInterfaces, err = metrs.NewFilter(cfg.NetInterfaces, cfg.NetExcludeInterfaces); err != nil {
+		fatal("Error parsing net interfaces", "error", err)
+	}
+	if opts.Processes, err = metrs.ParseProcessMatchers(cfg.Processes); err != nil {
+		fatal("Error parsing processes", "error", err)
+	}
+	for _, path := range strings.Split(cfg.Cgroups, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			opts.Cgroups = append(opts.Cgroups, path)
+		}
+	}
+	pollInterval := time.Duration(cfg.PollInterval) * time.Second
+	collectors, err := metrs.NewRegistry(pollInterval, specs, metrs.Builtin(opts)...)
+	if err != nil {
+		fatal("Error creating collectors", "error", err)
+	}
+	aggregation, err := metrs.ParseAggregation(cfg.Aggregate)
+	if err != nil {
+		fatal("Error parsing aggregation", "error", err)
+	}
+	collectors.SetAggregation(aggregation)
+	pollTicker := time.NewTicker(pollInterval)
 	defer pollTicker.Stop()
 	sendTicker := time.NewTicker(time.Duration(cfg.ReportInterval) * time.Second)
 	defer sendTicker.Stop()
-	// Нам не нужен глобальный счетчик, т.к. он используется только внутри функции main, поэтому его можно объявить внутри main.
-	var pollCount int64
 	// Получаем метрики сразу после инициализации. Таким образом метрики будут сразу доступны для отправки.
-	metrics := metrs.GetMetrics(pollCount)
-	var wg sync.WaitGroup // Используем WaitGroup для ожидания, пока не закроются выходные каналы
+	collectors.Poll(context.Background())
+	var wg sync.WaitGroup             // Используем WaitGroup для ожидания отправки при остановке
+	sending := make(chan struct{}, 1) // Занят, пока идет отправка
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	for {
 		// Здесь произойдет lock и select разлочится событием, которое произойдет первым.
 		select {
 		case <-pollTicker.C:
-			pollCount += 1
-			metrics = metrs.GetMetrics(pollCount)
+			collectors.Poll(context.Background())
 		case <-sendTicker.C:
-			msgCh := metrics.MakeMessages()
-			dests := SplitBatch(msgCh, cfg.RateLimit) // Fan Out
-
-			wg.Add(len(dests))
-			for i, ch := range dests {
-				go func(worker int, d <-chan api.Metrics) {
-					defer wg.Done()
-					subbatch := make([]api.Metrics, 0)
-					for val := range d {
-						subbatch = append(subbatch, val)
-					}
-					if len(subbatch) > 0 {
-						slog.Info("Worker got task", "worker", worker, "subbatch", subbatch)
-						err := sender.Send(worker, subbatch)
-						if err != nil {
-							slog.Error("Send error", "worker", worker, "error", err)
-						}
-					}
-				}(i, ch)
+			// Отправка идет в своей горутине, чтобы повторы и недоступный сервер не останавливали опрос.
+			// Пока предыдущий пакет не отправлен, метрики продолжают накапливаться и уйдут следующим.
+			select {
+			case sending <- struct{}{}:
+			default:
+				slog.Warn("Previous report is still being sent, the report is postponed")
+				continue
 			}
-			wg.Wait()
-			slog.Info("Jobs done")
+			msgCh := metrs.MakeMessages(collectors.Metrics())
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sending }()
+				report(sender, ob, msgCh, cfg.RateLimit)
+				slog.Info("Jobs done")
+			}()
 		case <-quit:
 			slog.Info("Shutdown signal received...")
 			wg.Wait()
@@ -0,0 +1,225 @@
+// Command keys generates, inspects and rotates keys for encrypting metrics.
+//
+// Usage:
+//
+//	keys generate [-type rsa|ec|ed25519] [-size N] [-format pkcs1|pkcs8|sec1] [-pub-format pkcs1|pkix] -out NAME
+//	keys inspect FILE...
+//	keys check -private FILE -public FILE
+//	keys rotate -current FILE -out NAME
+//
+// generate writes the private key to NAME.pem and the public key to NAME.pub.pem.
+// Ed25519 keys are written in PKCS#8 and PKIX formats, inspect prints the public key for the agents registry.
+// rotate generates a new key like the current one and prints the value of the server -crypto-key flag
+// with both keys, so agents may switch to the new public key while the server accepts both.
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	asc "github.com/xoxloviwan/go-monitor/internal/asymcrypto"
+)
+
+const usage = `usage:
+	keys generate [-type rsa|ec|ed25519] [-size N] [-format pkcs1|pkcs8|sec1] [-pub-format pkcs1|pkix] -out NAME
+	keys inspect FILE...
+	keys check -private FILE -public FILE
+	keys rotate -current FILE -out NAME`
+
+var errUsage = errors.New(usage)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "generate":
+		return generate(args, out)
+	case "inspect":
+		return inspect(args, out)
+	case "check":
+		return check(args, out)
+	case "rotate":
+		return rotate(args, out)
+	default:
+		return fmt.Errorf("unknown command %q\n%w", cmd, errUsage)
+	}
+}
+
+func generate(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	keyType := fs.String("type", string(asc.RSA), "key type: rsa, ec or ed25519")
+	size := fs.Int("size", 0, "RSA modulus length or EC curve size, default 4096 for RSA and 256 for EC")
+	format := fs.String("format", string(asc.PKCS8), "private key format: pkcs1, pkcs8 or sec1")
+	pubFormat := fs.String("pub-format", string(asc.PKIX), "public key format: pkcs1 or pkix")
+	name := fs.String("out", "", "name of output files without extension")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return errors.New("-out is required")
+	}
+	if *size == 0 {
+		*size = 4096
+		if asc.KeyType(*keyType) == asc.EC {
+			*size = 256
+		}
+	}
+	if asc.KeyType(*keyType) == asc.Ed25519 {
+		*format, *pubFormat = string(asc.PKCS8), string(asc.PKIX)
+	}
+	key, err := asc.GenerateKey(asc.KeyType(*keyType), *size)
+	if err != nil {
+		return err
+	}
+	return writePair(out, key, *name, asc.Format(*format), asc.Format(*pubFormat))
+}
+
+func writePair(out io.Writer, key crypto.Signer, name string, format, pubFormat asc.Format) error {
+	private, err := asc.MarshalPrivateKey(key, format)
+	if err != nil {
+		return err
+	}
+	public, err := asc.MarshalPublicKey(key.Public(), pubFormat)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(name+".pem", private, 0o600); err != nil {
+		return err
+	}
+	if err = os.WriteFile(name+".pub.pem", public, 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "private key: %s.pem\npublic key: %s.pub.pem\n", name, name)
+	return describe(out, key.Public())
+}
+
+// describe prints the algorithm, the size, the fingerprint and the key ID of the public key.
+func describe(out io.Writer, key crypto.PublicKey) error {
+	fp, err := asc.Fingerprint(key)
+	if err != nil {
+		return err
+	}
+	id, err := asc.KeyID(key)
+	if err != nil {
+		return err
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		fmt.Fprintf(out, "algorithm: RSA %d\n", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		fmt.Fprintf(out, "algorithm: EC %s\n", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		// Так ключ записывается в реестр агентов сервера.
+		fmt.Fprintf(out, "algorithm: Ed25519\npublic key: %s\n", base64.StdEncoding.EncodeToString(k))
+	default:
+		fmt.Fprintf(out, "algorithm: %T\n", key)
+	}
+	fmt.Fprintf(out, "fingerprint: SHA256:%s\nkey id: %s\n", fp, id)
+	return nil
+}
+
+// readKey reads a private or a public key from the file.
+func readKey(path string) (crypto.Signer, crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if private, err := asc.ParsePrivateKey(data); err == nil {
+		return private, private.Public(), nil
+	}
+	public, err := asc.ParsePublicKey(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return nil, public, nil
+}
+
+func inspect(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	for _, path := range args {
+		private, public, err := readKey(path)
+		if err != nil {
+			return err
+		}
+		kind := "public"
+		if private != nil {
+			kind = "private"
+		}
+		fmt.Fprintf(out, "%s: %s key\n", path, kind)
+		if err = describe(out, public); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func check(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	privatePath := fs.String("private", "", "path to private key")
+	publicPath := fs.String("public", "", "path to public key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	private, _, err := readKey(*privatePath)
+	if err != nil {
+		return err
+	}
+	if private == nil {
+		return fmt.Errorf("%s is not a private key", *privatePath)
+	}
+	_, public, err := readKey(*publicPath)
+	if err != nil {
+		return err
+	}
+	if err = asc.CheckPair(private, public); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "OK: keys match")
+	return nil
+}
+
+func rotate(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	current := fs.String("current", "", "path to current private key")
+	name := fs.String("out", "", "name of output files of the new key without extension")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *current == "" || *name == "" {
+		return errors.New("-current and -out are required")
+	}
+	old, err := asc.GetPrivateKey(*current)
+	if err != nil {
+		return err
+	}
+	key, err := asc.GenerateKey(asc.RSA, old.N.BitLen())
+	if err != nil {
+		return err
+	}
+	if err = writePair(out, key, *name, asc.PKCS8, asc.PKIX); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\n1. Start the server with -crypto-key=%s.pem,%s\n", *name, *current)
+	fmt.Fprintf(out, "2. Give agents %s.pub.pem\n", *name)
+	fmt.Fprintf(out, "3. Start the server with -crypto-key=%s.pem\n", *name)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	asc "github.com/xoxloviwan/go-monitor/internal/asymcrypto"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	server := filepath.Join(dir, "server")
+	ec := filepath.Join(dir, "ec")
+	next := filepath.Join(dir, "next")
+
+	var out bytes.Buffer
+	if err := run([]string{"generate", "-size", "2048", "-format", "pkcs1", "-out", server}, &out); err != nil {
+		t.Fatalf("generate error = %v", err)
+	}
+	if !strings.Contains(out.String(), "algorithm: RSA 2048") {
+		t.Errorf("generate output = %s", out.String())
+	}
+	if err := run([]string{"generate", "-type", "ec", "-format", "sec1", "-out", ec}, &out); err != nil {
+		t.Fatalf("generate ec error = %v", err)
+	}
+
+	out.Reset()
+	if err := run([]string{"inspect", server + ".pem", ec + ".pub.pem"}, &out); err != nil {
+		t.Fatalf("inspect error = %v", err)
+	}
+	if got := out.String(); !strings.Contains(got, "private key") || !strings.Contains(got, "EC P-256") {
+		t.Errorf("inspect output = %s", got)
+	}
+	key, err := asc.GetPublicKey(server + ".pub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := asc.KeyID(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "key id: "+id) {
+		t.Errorf("inspect output = %s, want key id %s", out.String(), id)
+	}
+
+	if err = run([]string{"check", "-private", server + ".pem", "-public", server + ".pub.pem"}, &out); err != nil {
+		t.Errorf("check error = %v", err)
+	}
+	if err = run([]string{"check", "-private", server + ".pem", "-public", ec + ".pub.pem"}, &out); !errors.Is(err, asc.ErrKeyMismatch) {
+		t.Errorf("check error = %v, want %v", err, asc.ErrKeyMismatch)
+	}
+
+	out.Reset()
+	if err = run([]string{"rotate", "-current", server + ".pem", "-out", next}, &out); err != nil {
+		t.Fatalf("rotate error = %v", err)
+	}
+	if !strings.Contains(out.String(), "-crypto-key="+next+".pem,"+server+".pem") {
+		t.Errorf("rotate output = %s", out.String())
+	}
+	if _, err = asc.LoadKeyring(next+".pem", server+".pem"); err != nil {
+		t.Errorf("LoadKeyring() error = %v", err)
+	}
+
+	if err = run([]string{"unknown"}, &out); !errors.Is(err, errUsage) {
+		t.Errorf("unknown command error = %v, want %v", err, errUsage)
+	}
+}
@@ -14,7 +14,6 @@ require (
 	github.com/jackc/pgx/v5 v5.7.0
 	github.com/mailru/easyjson v0.7.7
 	github.com/pashagolub/pgxmock/v4 v4.3.0
-	github.com/shirou/gopsutil v3.21.11+incompatible
 	github.com/shirou/gopsutil/v4 v4.24.7
 	golang.org/x/sync v0.8.0
 	golang.org/x/tools v0.26.0
@@ -49,6 +48,7 @@ require (
 	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/pelletier/go-toml/v2 v2.2.2 // indirect
 	github.com/power-devops/perfstat v0.0.0-20210106213030-5aafc221ea8c // indirect
+	github.com/shoenig/go-m1cpu v0.1.6 // indirect
 	github.com/tklauser/go-sysconf v0.3.12 // indirect
 	github.com/tklauser/numcpus v0.6.1 // indirect
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
@@ -0,0 +1,224 @@
+// Package agents keeps the registry of agents allowed to send metrics signed with their own Ed25519 keys.
+//
+// Agents are listed in a JSON file with base64 raw Ed25519 public keys:
+//
+//	{"agents": [
+//		{"id": "host-1", "public_key": "<base64>"},
+//		{"id": "host-2", "public_key": "<base64>", "revoked_at": "2024-05-01T10:00:00Z"}
+//	]}
+//
+// The public key of an agent is printed by `keys inspect`.
+package agents
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xoxloviwan/go-monitor/internal/signing"
+)
+
+// Ошибки проверки подписи агента.
+var (
+	ErrUnknownAgent = errors.New("unknown agent")
+	ErrRevoked      = errors.New("agent revoked")
+)
+
+// Agent is an entry of the registry.
+type Agent struct {
+	// ID identifies the agent, it is sent in the X-Agent-ID header.
+	ID string `json:"id"`
+	// PublicKey verifies signatures of the agent.
+	PublicKey ed25519.PublicKey `json:"public_key"`
+	// RevokedAt is the time of revocation, signatures of revoked agents are rejected.
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+type agentsFile struct {
+	Agents []Agent `json:"agents"`
+}
+
+// Registry holds agents loaded from a file.
+//
+// It is safe for concurrent use. Revocations are written back to the file.
+type Registry struct {
+	path    string
+	mu      sync.RWMutex
+	agents  map[string]Agent
+	modTime time.Time
+	size    int64
+}
+
+// Load reads the agents file.
+func Load(path string) (*Registry, error) {
+	r := &Registry{path: path}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the agents file again.
+//
+// If the file is invalid, the previously loaded agents are kept.
+func (r *Registry) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reload()
+}
+
+func (r *Registry) reload() error {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	agents, err := parse(data)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", r.path, err)
+	}
+	r.agents = agents
+	r.modTime, r.size = fi.ModTime(), fi.Size()
+	return nil
+}
+
+func parse(data []byte) (map[string]Agent, error) {
+	var f agentsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	agents := make(map[string]Agent, len(f.Agents))
+	for i, a := range f.Agents {
+		if a.ID == "" {
+			return nil, fmt.Errorf("agent %d: empty id", i)
+		}
+		if len(a.PublicKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("agent %q: public key must be %d bytes", a.ID, ed25519.PublicKeySize)
+		}
+		if _, ok := agents[a.ID]; ok {
+			return nil, fmt.Errorf("agent %q: duplicate id", a.ID)
+		}
+		agents[a.ID] = a
+	}
+	return agents, nil
+}
+
+// Verify checks the signature of the request made by the agent.
+func (r *Registry) Verify(id, timestamp, nonce string, body []byte, sign string) error {
+	r.mu.RLock()
+	a, ok := r.agents[id]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownAgent, id)
+	}
+	if a.RevokedAt != nil {
+		return fmt.Errorf("%w: %q", ErrRevoked, id)
+	}
+	return signing.VerifyEd25519(a.PublicKey, timestamp, nonce, body, sign)
+}
+
+// List returns agents sorted by ID.
+func (r *Registry) List() []Agent {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]Agent, 0, len(r.agents))
+	for _, a := range r.agents {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Revoke marks the agent as revoked and saves the file.
+// Revoking an already revoked agent keeps the original time.
+func (r *Registry) Revoke(id string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.agents[id]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownAgent, id)
+	}
+	if a.RevokedAt != nil {
+		return nil
+	}
+	now = now.UTC()
+	a.RevokedAt = &now
+	r.agents[id] = a
+	return r.save()
+}
+
+// save writes agents to a temporary file and renames it over the agents file.
+func (r *Registry) save() error {
+	f := agentsFile{Agents: make([]Agent, 0, len(r.agents))}
+	for _, a := range r.agents {
+		f.Agents = append(f.Agents, a)
+	}
+	sort.Slice(f.Agents, func(i, j int) bool { return f.Agents[i].ID < f.Agents[j].ID })
+	data, err := json.MarshalIndent(f, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), r.path); err != nil {
+		return err
+	}
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	r.modTime, r.size = fi.ModTime(), fi.Size()
+	return nil
+}
+
+// Watch reloads the agents file every interval if it was modified, until done is closed.
+func (r *Registry) Watch(done <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fi, err := os.Stat(r.path)
+			if err != nil {
+				slog.Error("stat agents file error", "path", r.path, "error", err)
+				continue
+			}
+			r.mu.Lock()
+			if fi.ModTime().Equal(r.modTime) && fi.Size() == r.size {
+				r.mu.Unlock()
+				continue
+			}
+			if err := r.reload(); err != nil {
+				// Не повторяем попытку, пока файл не изменится снова.
+				r.modTime, r.size = fi.ModTime(), fi.Size()
+				r.mu.Unlock()
+				slog.Error("reload agents file error", "path", r.path, "error", err)
+				continue
+			}
+			r.mu.Unlock()
+			slog.Info("Agents reloaded", "path", r.path)
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package agents
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xoxloviwan/go-monitor/internal/signing"
+)
+
+func writeAgents(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	pub1, key1, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub2, key2, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "agents.json")
+	writeAgents(t, path, `{"agents": [
+		{"id": "host-1", "public_key": "`+base64.StdEncoding.EncodeToString(pub1)+`"},
+		{"id": "host-2", "public_key": "`+base64.StdEncoding.EncodeToString(pub2)+`"}
+	]}`)
+	r, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+
+	tests := []struct {
+		name string
+		id   string
+		sign string
+		want error
+	}{
+		{"valid", "host-1", signing.SignEd25519(key1, "1", "n", body), nil},
+		{"key of other agent", "host-1", signing.SignEd25519(key2, "1", "n", body), signing.ErrInvalidSignature},
+		{"not base64", "host-1", "???", signing.ErrInvalidSignature},
+		{"unknown", "host-3", signing.SignEd25519(key1, "1", "n", body), ErrUnknownAgent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := r.Verify(tt.id, "1", "n", body, tt.sign); !errors.Is(err, tt.want) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	if err = r.Revoke("host-2", now); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if err = r.Revoke("host-3", now); !errors.Is(err, ErrUnknownAgent) {
+		t.Errorf("Revoke() error = %v, want %v", err, ErrUnknownAgent)
+	}
+	if err = r.Verify("host-2", "1", "n", body, signing.SignEd25519(key2, "1", "n", body)); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Verify() of revoked agent error = %v, want %v", err, ErrRevoked)
+	}
+
+	// Отзыв сохраняется в файл.
+	saved, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := saved.List()
+	if len(list) != 2 || list[0].ID != "host-1" || list[0].RevokedAt != nil || list[1].RevokedAt == nil || !list[1].RevokedAt.Equal(now) {
+		t.Errorf("List() after reload = %+v", list)
+	}
+}
+
+func TestLoad_invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agents.json")
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not json", `agents`},
+		{"empty id", `{"agents": [{"id": "", "public_key": "AAAA"}]}`},
+		{"short key", `{"agents": [{"id": "host-1", "public_key": "AAAA"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeAgents(t, path, tt.data)
+			if _, err := Load(path); err == nil {
+				t.Error("Load() must fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xoxloviwan/go-monitor/internal/agents"
+	"github.com/xoxloviwan/go-monitor/internal/audit"
+	"github.com/xoxloviwan/go-monitor/internal/auth"
+	mtrTypes "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+	"github.com/xoxloviwan/go-monitor/internal/store"
+	"github.com/xoxloviwan/go-monitor/internal/tlsconfig"
+)
+
+// adminHandler serves the /admin/ routes.
+type adminHandler struct {
+	admin  store.Admin
+	agents *agents.Registry
+	audit  *audit.Logger
+}
+
+// adminResult is the JSON body of admin responses.
+type adminResult struct {
+	Affected int    `json:"affected"`
+	Error    string `json:"error,omitempty"`
+}
+
+// adminTokenIdentity is the identity of requests authorized by the admin token.
+var adminTokenIdentity = auth.Identity{Name: "admin-token", Scopes: []auth.Scope{auth.ScopeAdmin}}
+
+// checkAdmin rejects requests without the "Authorization: Bearer <token>" header
+// holding either the admin token or a token with the admin scope.
+// The identity that authorized the request is stored in the context.
+func checkAdmin(token string, tokens *auth.Store) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		got := auth.BearerToken(ctx.Request.Header.Get("Authorization"))
+		if token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			ctx.Set(identityKey, adminTokenIdentity)
+			ctx.Next()
+			return
+		}
+		if tokens == nil {
+			ctx.Header("WWW-Authenticate", "Bearer")
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		authenticate(ctx, tokens, got, auth.ScopeAdmin)
+	}
+}
+
+// adminStatus maps storage errors to HTTP status codes.
+func adminStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, store.ErrNotFound), errors.Is(err, agents.ErrUnknownAgent):
+		return http.StatusNotFound
+	case errors.Is(err, store.ErrExists):
+		return http.StatusConflict
+	case errors.Is(err, path.ErrBadPattern):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (hdl *adminHandler) reply(c *gin.Context, op string, affected int, err error, args ...any) {
+	// Записываем того, кто разрешил операцию: имя токена, затем имя из проверенного
+	// сертификата и только потом адрес клиента.
+	var actor string
+	if id, ok := c.Get(identityKey); ok {
+		actor = id.(auth.Identity).Name
+	}
+	if actor == "" {
+		actor = tlsconfig.PeerName(c.Request.TLS)
+	}
+	if actor == "" {
+		actor = c.ClientIP()
+	}
+	hdl.audit.Record(c.Request.Context(), audit.Entry{
+		Actor:     actor,
+		Transport: audit.TransportHTTP,
+		Op:        op,
+		Args:      args,
+		Affected:  affected,
+		Err:       err,
+	})
+	res := adminResult{Affected: affected}
+	if err != nil {
+		c.Error(err)
+		res.Error = err.Error()
+	}
+	c.JSON(adminStatus(err), res)
+}
+
+// delete deletes the metric with the name from the path.
+func (hdl *adminHandler) delete(c *gin.Context) {
+	name := c.Param("metricName")
+	n, err := hdl.admin.Delete(c.Request.Context(), name)
+	if err == nil && n == 0 {
+		err = store.ErrNotFound
+	}
+	hdl.reply(c, "delete", n, err, "name", name)
+}
+
+// deleteMatching deletes metrics whose names match the pattern query parameter.
+func (hdl *adminHandler) deleteMatching(c *gin.Context) {
+	pattern := c.Query("pattern")
+	if pattern == "" {
+		c.JSON(http.StatusBadRequest, adminResult{Error: "pattern is required"})
+		return
+	}
+	n, err := hdl.admin.DeleteMatching(c.Request.Context(), pattern)
+	hdl.reply(c, "delete", n, err, "pattern", pattern)
+}
+
+// reset sets the counter with the name from the path to zero.
+func (hdl *adminHandler) reset(c *gin.Context) {
+	name := c.Param("metricName")
+	err := hdl.admin.ResetCounter(c.Request.Context(), name)
+	n := 0
+	if err == nil {
+		n = 1
+	}
+	hdl.reply(c, "reset", n, err, "name", name)
+}
+
+// rename renames the metric with the name from the path to the name from the "to" query parameter.
+func (hdl *adminHandler) rename(c *gin.Context) {
+	name := c.Param("metricName")
+	to := c.Query("to")
+	if err := mtrTypes.ValidateName(to); err != nil {
+		c.JSON(http.StatusBadRequest, adminResult{Error: "new name: " + err.Error()})
+		return
+	}
+	err := hdl.admin.Rename(c.Request.Context(), name, to)
+	n := 0
+	if err == nil {
+		n = 1
+	}
+	hdl.reply(c, "rename", n, err, "old", name, "new", to)
+}
+
+// listAgents lists the agents of the registry.
+func (hdl *adminHandler) listAgents(c *gin.Context) {
+	c.JSON(http.StatusOK, hdl.agents.List())
+}
+
+// revokeAgent revokes the agent with the ID from the path.
+func (hdl *adminHandler) revokeAgent(c *gin.Context) {
+	id := c.Param("agentID")
+	err := hdl.agents.Revoke(id, time.Now())
+	n := 0
+	if err == nil {
+		n = 1
+	}
+	hdl.reply(c, "revoke_agent", n, err, "agent", id)
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/xoxloviwan/go-monitor/internal/agents"
+	mock "github.com/xoxloviwan/go-monitor/internal/api/mock"
+	"github.com/xoxloviwan/go-monitor/internal/audit"
+	mt "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+	"github.com/xoxloviwan/go-monitor/internal/signing"
+	"github.com/xoxloviwan/go-monitor/internal/store"
+)
+
+func Test_admin(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	s := store.NewMemStorage()
+	s.Gauge["cpu_1"] = 1
+	s.Gauge["cpu_2"] = 2
+	s.Gauge["Alloc"] = 3
+	s.Counter["PollCount"] = 10
+
+	var auditBuf bytes.Buffer
+	gin.SetMode(gin.ReleaseMode)
+	r := NewRouter()
+	r.SetupRouter(RouterParams{
+		Ping:       func(c *gin.Context) { c.Status(http.StatusOK) },
+		Store:      mock.NewMockReaderWriter(ctrl),
+		LogLevel:   slog.LevelError,
+		Admin:      s,
+		AdminToken: "secret",
+		Audit:      audit.New(&auditBuf),
+	})
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		token    string
+		wantCode int
+		wantBody string
+	}{
+		{"no token", http.MethodDelete, "/admin/metrics/Alloc", "", http.StatusUnauthorized, ""},
+		{"wrong token", http.MethodDelete, "/admin/metrics/Alloc", "wrong", http.StatusUnauthorized, ""},
+		{"delete", http.MethodDelete, "/admin/metrics/Alloc", "secret", http.StatusOK, `{"affected":1}`},
+		{"delete missing", http.MethodDelete, "/admin/metrics/Alloc", "secret", http.StatusNotFound, `{"affected":0,"error":"metric not found"}`},
+		{"delete pattern", http.MethodDelete, "/admin/metrics?pattern=cpu_*", "secret", http.StatusOK, `{"affected":2}`},
+		{"delete bad pattern", http.MethodDelete, "/admin/metrics?pattern=%5B-", "secret", http.StatusBadRequest, ""},
+		{"delete no pattern", http.MethodDelete, "/admin/metrics", "secret", http.StatusBadRequest, ""},
+		{"reset", http.MethodPost, "/admin/metrics/PollCount/reset", "secret", http.StatusOK, `{"affected":1}`},
+		{"reset missing", http.MethodPost, "/admin/metrics/undef/reset", "secret", http.StatusNotFound, ""},
+		{"rename", http.MethodPost, "/admin/metrics/PollCount/rename?to=Polls", "secret", http.StatusOK, `{"affected":1}`},
+		{"rename without new name", http.MethodPost, "/admin/metrics/Polls/rename", "secret", http.StatusBadRequest, ""},
+		{"rename to invalid name", http.MethodPost, "/admin/metrics/Polls/rename?to=bad%20name", "secret", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("want code %d, got %d", tt.wantCode, w.Code)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("want body %s, got %s", tt.wantBody, w.Body.String())
+			}
+		})
+	}
+
+	if _, ok := s.Counter["Polls"]; !ok || s.Counter["Polls"] != 0 {
+		t.Errorf("counters after admin operations = %v", s.Counter)
+	}
+	if len(s.Gauge) != 0 {
+		t.Errorf("gauges after admin operations = %v", s.Gauge)
+	}
+	// Запросы без токена и без обязательных параметров не попадают в журнал аудита.
+	if got := strings.Count(auditBuf.String(), "\n"); got != 7 {
+		t.Errorf("want 7 audit records, got %d:\n%s", got, auditBuf.String())
+	}
+
+	// Операция записывается в журнал от имени токена, а не сертификата или адреса.
+	auditBuf.Reset()
+	req := httptest.NewRequest(http.MethodDelete, "/admin/metrics/undef", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Real-IP", "10.0.0.1")
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "admin-1"}}}},
+	}
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if !strings.Contains(auditBuf.String(), `"actor":"admin-token"`) {
+		t.Errorf("audit record without the token name: %s", auditBuf.String())
+	}
+}
+
+func Test_agents_unsigned(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "agents.json")
+	data := `{"agents": [{"id": "host-1", "public_key": "` + base64.StdEncoding.EncodeToString(pub) + `"}]}`
+	if err = os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	registry, err := agents.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.ReleaseMode)
+	r := NewRouter()
+	// Только реестр агентов, без общего ключа.
+	r.SetupRouter(RouterParams{
+		Ping:     func(c *gin.Context) { c.Status(http.StatusOK) },
+		Store:    store.NewMemStorage(),
+		LogLevel: slog.LevelError,
+		Agents:   registry,
+	})
+
+	const body = `[{"id":"PollCount","type":"counter","delta":1}]`
+	for _, url := range []string{"/updates/", "/update/counter/PollCount/1"} {
+		req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("unsigned %s: want code %d, got %d", url, http.StatusUnauthorized, w.Code)
+		}
+	}
+
+	timestamp, nonce := signing.Timestamp(time.Now()), signing.NewNonce()
+	req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(signing.TimestampHeader, timestamp)
+	req.Header.Set(signing.NonceHeader, nonce)
+	req.Header.Set(signing.AgentHeader, "host-1")
+	req.Header.Set(signing.SignatureHeader, signing.SignEd25519(key, timestamp, nonce, mt.CanonicalBody([]byte(body), "")))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("signed by agent: want code %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func Test_agents(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "agents.json")
+	data := `{"agents": [{"id": "host-1", "public_key": "` + base64.StdEncoding.EncodeToString(pub) + `"}]}`
+	if err = os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	registry, err := agents.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.ReleaseMode)
+	r := NewRouter()
+	r.SetupRouter(RouterParams{
+		Ping:       func(c *gin.Context) { c.Status(http.StatusOK) },
+		Store:      store.NewMemStorage(),
+		LogLevel:   slog.LevelError,
+		Key:        []byte("shared"),
+		AdminToken: "secret",
+		Agents:     registry,
+	})
+
+	const body = `[{"id":"PollCount","type":"counter","delta":1}]`
+	send := func(id string, signKey ed25519.PrivateKey) int {
+		timestamp, nonce := signing.Timestamp(time.Now()), signing.NewNonce()
+		req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(signing.TimestampHeader, timestamp)
+		req.Header.Set(signing.NonceHeader, nonce)
+		req.Header.Set(signing.AgentHeader, id)
+		req.Header.Set(signing.SignatureHeader, signing.SignEd25519(signKey, timestamp, nonce, mt.CanonicalBody([]byte(body), "")))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	admin := func(method, url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if code := send("host-1", key); code != http.StatusOK {
+		t.Errorf("signed by agent: want code %d, got %d", http.StatusOK, code)
+	}
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	if code := send("host-1", otherKey); code != http.StatusBadRequest {
+		t.Errorf("signed by other key: want code %d, got %d", http.StatusBadRequest, code)
+	}
+	if code := send("host-2", key); code != http.StatusForbidden {
+		t.Errorf("unknown agent: want code %d, got %d", http.StatusForbidden, code)
+	}
+
+	if w := admin(http.MethodPost, "/admin/agents/host-2/revoke"); w.Code != http.StatusNotFound {
+		t.Errorf("revoke unknown agent: want code %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := admin(http.MethodPost, "/admin/agents/host-1/revoke"); w.Code != http.StatusOK {
+		t.Errorf("revoke: want code %d, got %d", http.StatusOK, w.Code)
+	}
+	if code := send("host-1", key); code != http.StatusForbidden {
+		t.Errorf("revoked agent: want code %d, got %d", http.StatusForbidden, code)
+	}
+	w := admin(http.MethodGet, "/admin/agents")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"id":"host-1"`) || !strings.Contains(w.Body.String(), `"revoked_at"`) {
+		t.Errorf("list agents: code %d, body %s", w.Code, w.Body.String())
+	}
+}
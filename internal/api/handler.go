@@ -3,16 +3,20 @@ package api
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mailru/easyjson"
 	mtrTypes "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+	"github.com/xoxloviwan/go-monitor/internal/ratelimit"
+	"github.com/xoxloviwan/go-monitor/internal/store"
 )
 
 // Handler is an API handler.
@@ -20,6 +24,8 @@ import (
 // It contains a store that implements the ReaderWriter interface.
 type Handler struct {
 	store ReaderWriter
+	// quota limits series and samples accepted from each client, nil disables the limits.
+	quota *ratelimit.Quota
 }
 
 // Reader is an interface for reading metrics.
@@ -58,6 +64,36 @@ func newHandler(store ReaderWriter) *Handler {
 	}
 }
 
+// checkQuota accounts the metrics in the quota of the client.
+//
+// It aborts the request with 429 and returns false if the quota is exceeded.
+func (hdl *Handler) checkQuota(c *gin.Context, metrics mtrTypes.MetricsList) bool {
+	if hdl.quota == nil {
+		return true
+	}
+	series := make([]string, len(metrics))
+	for i, m := range metrics {
+		series[i] = ratelimit.SeriesKey(m.MType, m.ID)
+	}
+	var le *ratelimit.LimitError
+	if err := hdl.quota.Allow(c.GetString(clientKey), series, time.Now()); errors.As(err, &le) {
+		tooManyRequests(c, le)
+		return false
+	}
+	return true
+}
+
+// applied reports whether the batch of the request was already applied, so the store ignores it.
+func (hdl *Handler) applied(ctx context.Context) bool {
+	id, ok := store.BatchIDFromContext(ctx)
+	checker, isChecker := hdl.store.(store.BatchChecker)
+	if !ok || !isChecker {
+		return false
+	}
+	applied, err := checker.BatchApplied(ctx, id)
+	return err == nil && applied
+}
+
 func (hdl *Handler) update(c *gin.Context) {
 	metricType := c.Param("metricType")
 	metricName := c.Param("metricName")
@@ -67,8 +103,19 @@ func (hdl *Handler) update(c *gin.Context) {
 		c.Status(http.StatusNotFound)
 		return
 	}
+	// Метрика проверяется так же, как в JSON, чтобы не сохранить NaN или недопустимое имя.
+	m, err := mtrTypes.ParseMetric(metricType, metricName, metricValue)
+	if err != nil {
+		c.Error(err)
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	// Квота расходуется только на принятую метрику.
+	if !hdl.checkQuota(c, mtrTypes.MetricsList{m}) {
+		return
+	}
 
-	err := hdl.store.Add(metricType, metricName, metricValue)
+	err = hdl.store.Add(metricType, metricName, metricValue)
 	if err != nil {
 		c.Error(err)
 		c.Status(http.StatusBadRequest)
@@ -77,6 +124,9 @@ func (hdl *Handler) update(c *gin.Context) {
 	}
 }
 
+// updateJSON stores a single metric or a batch of metrics.
+//
+// Invalid metrics of a batch are rejected one by one, see mtrTypes.BatchResultType for the response.
 func (hdl *Handler) updateJSON(c *gin.Context) {
 
 	if c.Request.Header.Get("Content-Type") != "application/json" {
@@ -84,7 +134,8 @@ func (hdl *Handler) updateJSON(c *gin.Context) {
 		c.Status(http.StatusBadRequest)
 		return
 	}
-	ctx := c.Request.Context()
+	// Повторно присланный агентом пакет с тем же ключом не применяется.
+	ctx := store.WithBatchID(c.Request.Context(), c.Request.Header.Get(mtrTypes.BatchIDHeader))
 	defer ctx.Done()
 
 	var mtr mtrTypes.Metrics
@@ -94,6 +145,7 @@ func (hdl *Handler) updateJSON(c *gin.Context) {
 	var buf bytes.Buffer
 	tee := io.TeeReader(c.Request.Body, &buf)
 
+	single := false
 	err = easyjson.UnmarshalFromReader(tee, &mtrList)
 	if err != nil {
 		err = easyjson.UnmarshalFromReader(&buf, &mtr)
@@ -103,51 +155,99 @@ func (hdl *Handler) updateJSON(c *gin.Context) {
 			return
 		}
 		mtrList = mtrTypes.MetricsList{mtr}
+		single = true
 	}
 
-	err = hdl.store.AddMetrics(ctx, &mtrList)
-	if err != nil {
-		c.Error(err)
-		c.Status(http.StatusInternalServerError)
+	// Некорректные метрики отклоняются по отдельности, остальные сохраняются.
+	accepted, rejected := mtrList.Split()
+	if single && len(rejected) > 0 {
+		c.Error(fmt.Errorf("invalid metric %q: %s", mtr.ID, rejected[0].Reason))
+		c.Status(http.StatusBadRequest)
 		return
 	}
-	err = ctx.Err()
-	if err != nil {
-		c.Error(err)
-		c.Status(http.StatusBadRequest)
+	// Квота расходуется только на принятые метрики, повтор примененного пакета ее не расходует.
+	if !hdl.applied(ctx) && !hdl.checkQuota(c, accepted) {
 		return
 	}
+
 	var mtrListWithValues mtrTypes.MetricsList
-	mtrListWithValues, err = hdl.store.GetMetrics(ctx, mtrList)
-	if err != nil {
-		c.Error(err)
-		c.Status(http.StatusInternalServerError)
-		return
+	if len(accepted) > 0 {
+		err = hdl.store.AddMetrics(ctx, &accepted)
+		if err != nil {
+			c.Error(err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		err = ctx.Err()
+		if err != nil {
+			c.Error(err)
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		mtrListWithValues, err = hdl.store.GetMetrics(ctx, accepted)
+		if err != nil {
+			c.Error(err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
 	}
 	c.Writer.Header().Set("Content-Type", "application/json")
-	if mtr.ID != "" {
+	if single {
 		mtrUpd := mtrTypes.Metrics{
 			ID:    mtrListWithValues[0].ID,
 			MType: mtrListWithValues[0].MType,
 			Value: mtrListWithValues[0].Value,
 			Delta: mtrListWithValues[0].Delta,
 		}
+		c.Status(http.StatusOK)
 		_, err = easyjson.MarshalToWriter(&mtrUpd, c.Writer)
 		if err != nil {
 			c.Error(err)
-			c.Status(http.StatusInternalServerError)
-			return
 		}
-		c.Status(http.StatusOK)
 		return
 	}
-	_, err = easyjson.MarshalToWriter(&mtrListWithValues, c.Writer)
+	if mtrListWithValues == nil {
+		mtrListWithValues = mtrTypes.MetricsList{}
+	}
+	// Пакет, в котором не принята ни одна метрика, считается ошибочным.
+	code := http.StatusOK
+	if len(accepted) == 0 && len(rejected) > 0 {
+		code = http.StatusBadRequest
+	}
+	// Прежние клиенты получают список сохраненных значений, отказы передаются в заголовке.
+	if !strings.Contains(c.Request.Header.Get("Accept"), mtrTypes.BatchResultType) {
+		c.Writer.Header().Set(mtrTypes.RejectedHeader, strconv.Itoa(len(rejected)))
+		c.Status(code)
+		if _, err = easyjson.MarshalToWriter(&mtrListWithValues, c.Writer); err != nil {
+			c.Error(err)
+		}
+		return
+	}
+	res := mtrTypes.BatchResult{
+		Accepted:      mtrListWithValues,
+		AcceptedIndex: acceptedIndex(len(mtrList), rejected),
+		Rejected:      rejected,
+	}
+	c.Writer.Header().Set("Content-Type", mtrTypes.BatchResultType)
+	c.Status(code)
+	_, err = easyjson.MarshalToWriter(&res, c.Writer)
 	if err != nil {
 		c.Error(err)
-		c.Status(http.StatusInternalServerError)
-		return
 	}
-	c.Status(http.StatusOK)
+}
+
+// acceptedIndex returns the positions of the metrics of a batch of size n which were not rejected.
+func acceptedIndex(n int, rejected []mtrTypes.Rejected) []int {
+	index := make([]int, 0, n-len(rejected))
+	next := 0
+	for i := 0; i < n; i++ {
+		if next < len(rejected) && rejected[next].Index == i {
+			next++
+			continue
+		}
+		index = append(index, i)
+	}
+	return index
 }
 
 func (hdl *Handler) value(c *gin.Context) {
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Component statuses reported by the health endpoints.
+const (
+	StatusOK       = "ok"
+	StatusFail     = "fail"
+	StatusPending  = "pending"
+	StatusDisabled = "disabled"
+)
+
+// ComponentStatus is the state of a single server component.
+type ComponentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// LastSuccess is the time of the last successful backup.
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	// Age is the time elapsed since the last successful backup.
+	Age string `json:"age,omitempty"`
+}
+
+// HealthReport is the JSON body of /healthz and /readyz.
+type HealthReport struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+// Health tracks the state of the server components.
+//
+// It is safe for concurrent use.
+type Health struct {
+	mu             sync.RWMutex
+	storage        func(ctx context.Context) error
+	grpcServing    bool
+	restored       bool
+	restoreErr     error
+	backupEnabled  bool
+	lastBackup     time.Time
+	lastBackupErr  error
+	backupAttempts int
+}
+
+// NewHealth returns a new Health.
+//
+// The storage function is called on every check and must return an error if the storage is unreachable.
+func NewHealth(storage func(ctx context.Context) error) *Health {
+	return &Health{storage: storage}
+}
+
+// SetGRPCServing marks the gRPC server as serving or stopped.
+func (h *Health) SetGRPCServing(serving bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.grpcServing = serving
+}
+
+// SetRestored marks restoring data from the backup file as finished.
+//
+// A restore error is reported but does not keep the server unready: the server starts with empty data in that case.
+func (h *Health) SetRestored(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.restored = true
+	h.restoreErr = err
+}
+
+// Ready reports whether restoring data has finished.
+func (h *Health) Ready() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.restored
+}
+
+// EnableBackup marks periodic backups as configured.
+func (h *Health) EnableBackup() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.backupEnabled = true
+}
+
+// BackupDone records the result of a backup.
+func (h *Health) BackupDone(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.backupAttempts++
+	h.lastBackupErr = err
+	if err == nil {
+		h.lastBackup = time.Now()
+	}
+}
+
+// Report checks all components and returns their statuses.
+func (h *Health) Report(ctx context.Context) HealthReport {
+	rep := HealthReport{Status: StatusOK, Components: make(map[string]ComponentStatus)}
+
+	storage := ComponentStatus{Status: StatusOK}
+	if h.storage != nil {
+		if err := h.storage(ctx); err != nil {
+			storage = ComponentStatus{Status: StatusFail, Error: err.Error()}
+		}
+	}
+	rep.Components["storage"] = storage
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	grpcStatus := ComponentStatus{Status: StatusOK}
+	if !h.grpcServing {
+		grpcStatus.Status = StatusFail
+	}
+	rep.Components["grpc"] = grpcStatus
+
+	restore := ComponentStatus{Status: StatusOK}
+	if !h.restored {
+		restore.Status = StatusPending
+	} else if h.restoreErr != nil {
+		restore.Error = h.restoreErr.Error()
+	}
+	rep.Components["restore"] = restore
+
+	backup := ComponentStatus{Status: StatusDisabled}
+	if h.backupEnabled {
+		backup.Status = StatusOK
+		if h.lastBackupErr != nil {
+			backup.Status = StatusFail
+			backup.Error = h.lastBackupErr.Error()
+		} else if h.backupAttempts == 0 {
+			backup.Status = StatusPending
+		}
+		if !h.lastBackup.IsZero() {
+			last := h.lastBackup
+			backup.LastSuccess = &last
+			backup.Age = time.Since(last).Round(time.Second).String()
+		}
+	}
+	rep.Components["backup"] = backup
+
+	for name, c := range rep.Components {
+		switch {
+		case c.Status == StatusFail:
+			rep.Status = StatusFail
+		case c.Status == StatusPending && name != "backup" && rep.Status == StatusOK:
+			// The server is ready before the first backup, but not before the restore has finished.
+			rep.Status = StatusPending
+		}
+	}
+	return rep
+}
+
+// livenessHandler always answers 200 while the process is able to serve requests
+// and reports component statuses for information.
+func livenessHandler(h *Health) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		c.JSON(http.StatusOK, h.Report(ctx))
+	}
+}
+
+// readinessHandler answers 200 only when all components are healthy.
+func readinessHandler(h *Health) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		rep := h.Report(ctx)
+		code := http.StatusOK
+		if rep.Status != StatusOK {
+			code = http.StatusServiceUnavailable
+		}
+		c.JSON(code, rep)
+	}
+}
+
+// waitReady rejects requests with 503 until restoring data has finished.
+func waitReady(h *Health) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !h.Ready() {
+			ctx.Header("Retry-After", "1")
+			ctx.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	mock "github.com/xoxloviwan/go-monitor/internal/api/mock"
+)
+
+func TestHealth_Report(t *testing.T) {
+	storageErr := errors.New("connection refused")
+	tests := []struct {
+		name       string
+		storage    func(ctx context.Context) error
+		prepare    func(h *Health)
+		wantStatus string
+		wantComp   map[string]string
+	}{
+		{
+			name:       "restore pending",
+			prepare:    func(h *Health) { h.SetGRPCServing(true) },
+			wantStatus: StatusPending,
+			wantComp:   map[string]string{"storage": StatusOK, "grpc": StatusOK, "restore": StatusPending, "backup": StatusDisabled},
+		},
+		{
+			name: "all ok",
+			prepare: func(h *Health) {
+				h.SetGRPCServing(true)
+				h.SetRestored(nil)
+				h.EnableBackup()
+				h.BackupDone(nil)
+			},
+			wantStatus: StatusOK,
+			wantComp:   map[string]string{"storage": StatusOK, "grpc": StatusOK, "restore": StatusOK, "backup": StatusOK},
+		},
+		{
+			name:    "storage unreachable and backup failed",
+			storage: func(ctx context.Context) error { return storageErr },
+			prepare: func(h *Health) {
+				h.SetGRPCServing(true)
+				h.SetRestored(nil)
+				h.EnableBackup()
+				h.BackupDone(errors.New("disk full"))
+			},
+			wantStatus: StatusFail,
+			wantComp:   map[string]string{"storage": StatusFail, "grpc": StatusOK, "restore": StatusOK, "backup": StatusFail},
+		},
+		{
+			name:       "grpc stopped",
+			prepare:    func(h *Health) { h.SetRestored(errors.New("no such file")) },
+			wantStatus: StatusFail,
+			wantComp:   map[string]string{"grpc": StatusFail, "restore": StatusOK},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHealth(tt.storage)
+			tt.prepare(h)
+			rep := h.Report(context.Background())
+			if rep.Status != tt.wantStatus {
+				t.Errorf("Report().Status = %v, want %v", rep.Status, tt.wantStatus)
+			}
+			for name, want := range tt.wantComp {
+				if got := rep.Components[name].Status; got != want {
+					t.Errorf("Report().Components[%s] = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_readyz(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mock.NewMockReaderWriter(ctrl)
+	gin.SetMode(gin.ReleaseMode)
+	r := NewRouter()
+	h := NewHealth(nil)
+	h.SetGRPCServing(true)
+	r.SetupRouter(RouterParams{
+		Ping:     func(c *gin.Context) { c.Status(http.StatusOK) },
+		Store:    m,
+		LogLevel: slog.LevelError,
+		Health:   h,
+	})
+
+	serve := func(url string) *http.Response {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w.Result()
+	}
+
+	res := serve("/readyz")
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("/readyz before restore: want %d, got %d", http.StatusServiceUnavailable, res.StatusCode)
+	}
+	var rep HealthReport
+	if err := json.NewDecoder(res.Body).Decode(&rep); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Components["restore"].Status != StatusPending {
+		t.Errorf("restore status = %v, want %v", rep.Components["restore"].Status, StatusPending)
+	}
+
+	res = serve("/healthz")
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("/healthz before restore: want %d, got %d", http.StatusOK, res.StatusCode)
+	}
+
+	res = serve("/")
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("/ before restore: want %d, got %d", http.StatusServiceUnavailable, res.StatusCode)
+	}
+
+	h.SetRestored(nil)
+	m.EXPECT().String().Return("")
+	res = serve("/")
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("/ after restore: want %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	res = serve("/readyz")
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("/readyz after restore: want %d, got %d", http.StatusOK, res.StatusCode)
+	}
+}
@@ -11,15 +11,22 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"crypto/hmac"
-	"crypto/rsa"
 	"crypto/sha256"
 
 	"github.com/gin-gonic/gin"
+	"github.com/xoxloviwan/go-monitor/internal/agents"
 	asc "github.com/xoxloviwan/go-monitor/internal/asymcrypto"
+	"github.com/xoxloviwan/go-monitor/internal/auth"
+	mtrTypes "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+	"github.com/xoxloviwan/go-monitor/internal/ratelimit"
+	"github.com/xoxloviwan/go-monitor/internal/signing"
+	"github.com/xoxloviwan/go-monitor/internal/telemetry"
+	"github.com/xoxloviwan/go-monitor/internal/tlsconfig"
 )
 
 // logger struct used in package
@@ -92,6 +99,23 @@ func logger(lev slog.Level) gin.HandlerFunc {
 	}
 }
 
+// collectStats counts requests and measures their latency per route and status.
+//
+// The route is taken from the matched route template, so path parameters do not produce new series.
+func collectStats(stats *telemetry.Server) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(ctx.Writer.Status())
+		stats.HTTPRequests.Inc(ctx.Request.Method, route, status)
+		stats.HTTPDuration.Since(start, ctx.Request.Method, route, status)
+	}
+}
+
 type compressWriter struct {
 	gin.ResponseWriter
 	zw *gzip.Writer
@@ -203,71 +227,170 @@ func (sw *signingWriter) Write(msg []byte) (int, error) {
 	return sw.ResponseWriter.Write(msg)
 }
 
-func verifyHash(key []byte) gin.HandlerFunc {
+// agentKey is the gin context key of the ID of the agent that signed the request.
+const agentKey = "agent"
+
+// signedKey is the gin context key set when the signature of the request was verified.
+const signedKey = "signed"
+
+// signedBody returns the canonical serialization of the decrypted request body signed by agents.
+func signedBody(ctx *gin.Context, body []byte) []byte {
+	return mtrTypes.CanonicalBody(body, ctx.Request.Header.Get(mtrTypes.BatchIDHeader))
+}
+
+// verifyHash checks the signature of the request over its timestamp, nonce and body,
+// and rejects stale and replayed requests.
+//
+// The signature covers the canonical serialization of the metrics and the batch ID rather than the bytes of the body,
+// so it must run after decryptBody.
+//
+// A request with the X-Agent-ID header must be signed with the Ed25519 key of the agent from the registry,
+// other requests are signed with the shared key in the HashSHA256 header. Requests without a signature are passed as is,
+// the update routes reject them with requireSignature.
+func verifyHash(key []byte, registry *agents.Registry, guard *signing.Guard) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		gotSign, err := hex.DecodeString(ctx.Request.Header.Get("HashSHA256"))
+		agentID := ctx.Request.Header.Get(signing.AgentHeader)
+		if agentID != "" && registry != nil {
+			verifyAgent(ctx, registry, guard, agentID)
+			return
+		}
+		if len(key) == 0 {
+			ctx.Next()
+			return
+		}
+		gotSignHex := ctx.Request.Header.Get(signing.HashHeader)
+		if gotSignHex == "" {
+			// Неподписанные запросы на запись отклоняет requireSignature.
+			ctx.Next()
+			return
+		}
+		gotSign, err := hex.DecodeString(gotSignHex)
 		if err != nil {
 			ctx.AbortWithError(http.StatusBadRequest, err)
 			return
 		}
 		if len(gotSign) != sha256.Size {
-			ctx.Next()
-			//ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid hash size"))
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid hash size"))
 			return
 		}
-		h := hmac.New(sha256.New, key)
-
-		var buf bytes.Buffer
-		tee := io.TeeReader(ctx.Request.Body, &buf)
-		_, err = io.Copy(h, tee)
+		body, err := io.ReadAll(ctx.Request.Body)
 		if err != nil {
 			ctx.AbortWithError(http.StatusInternalServerError, err)
 			return
 		}
-		ctx.Request.Body = io.NopCloser(&buf)
-		sign := h.Sum(nil)
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+		timestamp := ctx.Request.Header.Get(signing.TimestampHeader)
+		nonce := ctx.Request.Header.Get(signing.NonceHeader)
+		sign := signing.Sign(key, timestamp, nonce, signedBody(ctx, body))
 		if !hmac.Equal(sign, gotSign) {
 			ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid hash"))
 			return
 		}
+		if err = guard.Check(timestamp, nonce, time.Now()); err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		ctx.Set(signedKey, true)
 		ctx.Writer = newSigningWriter(ctx.Writer, key)
 		ctx.Next()
 	}
 }
 
-func decryptBody(privateKey *rsa.PrivateKey) gin.HandlerFunc {
+// requireSignature rejects requests which were not signed and checked by verifyHash.
+// Without a signature neither the body nor the replay of the request can be checked.
+func requireSignature() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		key := ctx.Request.Header.Get("X-Key")
-		if key == "" {
-			ctx.Next()
-			return
-		}
-		encryptedSessionKey, err := hex.DecodeString(key)
-		if err != nil {
-			ctx.AbortWithError(http.StatusBadRequest, err)
+		if !ctx.GetBool(signedKey) {
+			ctx.AbortWithError(http.StatusUnauthorized, errors.New("request is not signed"))
 			return
 		}
+		ctx.Next()
+	}
+}
+
+// verifyAgent checks the Ed25519 signature of the agent and stores the agent ID in the context.
+func verifyAgent(ctx *gin.Context, registry *agents.Registry, guard *signing.Guard, agentID string) {
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+	timestamp := ctx.Request.Header.Get(signing.TimestampHeader)
+	nonce := ctx.Request.Header.Get(signing.NonceHeader)
+	err = registry.Verify(agentID, timestamp, nonce, signedBody(ctx, body), ctx.Request.Header.Get(signing.SignatureHeader))
+	switch {
+	case errors.Is(err, agents.ErrUnknownAgent), errors.Is(err, agents.ErrRevoked):
+		ctx.AbortWithError(http.StatusForbidden, err)
+		return
+	case err != nil:
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if err = guard.Check(timestamp, nonce, time.Now()); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	ctx.Set(agentKey, agentID)
+	ctx.Set(signedKey, true)
+	ctx.Next()
+}
+
+// decryptBody decrypts the request body.
+//
+// The format is detected by the request: the V1 body comes with the session key in the X-Key header,
+// the V2 envelope carries the key itself and starts with the envelope header. Other bodies are passed as is.
+// The private key is selected by the X-Key-ID header, without the header each key is tried.
+func decryptBody(keys *asc.Keyring) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.Request.Header.Get("X-Key")
+		keyID := ctx.Request.Header.Get(asc.KeyIDHeader)
 		bodyBytes, err := io.ReadAll(ctx.Request.Body)
 		if err != nil {
 			ctx.AbortWithError(http.StatusInternalServerError, err)
 			return
 		}
-		if len(bodyBytes) == 0 {
-			ctx.Next()
-			return
-		}
-		decryptBody, err := asc.Decrypt(privateKey, encryptedSessionKey, bodyBytes)
-		if err != nil {
-			ctx.AbortWithError(http.StatusInternalServerError, err)
-			return
+		var decryptBody []byte
+		switch {
+		case len(bodyBytes) == 0:
+			decryptBody = bodyBytes
+		case key != "":
+			encryptedSessionKey, err := hex.DecodeString(key)
+			if err != nil {
+				ctx.AbortWithError(http.StatusBadRequest, err)
+				return
+			}
+			decryptBody, err = keys.Decrypt(keyID, encryptedSessionKey, bodyBytes)
+			if errors.Is(err, asc.ErrUnknownKey) {
+				ctx.AbortWithError(http.StatusBadRequest, err)
+				return
+			}
+			if err != nil {
+				ctx.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+		case asc.IsEnvelope(bodyBytes):
+			decryptBody, err = keys.Open(keyID, bodyBytes)
+			if err != nil {
+				ctx.AbortWithError(http.StatusBadRequest, err)
+				return
+			}
+		default:
+			decryptBody = bodyBytes
 		}
 		ctx.Request.Body = io.NopCloser(bytes.NewBuffer(decryptBody))
 		ctx.Next()
 	}
 }
 
+// checkIP rejects requests from clients outside the trusted subnet.
+// A client with a verified certificate is identified by it, so the client IP headers are not trusted then.
 func checkIP(subnet *net.IPNet) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		if tlsconfig.PeerName(ctx.Request.TLS) != "" {
+			ctx.Next()
+			return
+		}
 		ip := net.ParseIP(ctx.ClientIP())
 		if !subnet.Contains(ip) {
 			ctx.AbortWithError(http.StatusForbidden, fmt.Errorf("ip %s not allowed", ip))
@@ -277,3 +400,78 @@ func checkIP(subnet *net.IPNet) gin.HandlerFunc {
 		ctx.Next()
 	}
 }
+
+// clientKey is the gin context key of the client identifier used for rate limiting and quotas.
+const clientKey = "client"
+
+// identifyClient stores the client identifier in the context.
+//
+// The client is identified by the common name of its verified certificate if mutual TLS is used,
+// or by the ID of the agent that signed the request with its own key.
+// Otherwise it is identified by the remote address, or by the X-Real-IP header if it belongs to the trusted subnet.
+func identifyClient(subnet *net.IPNet) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if name := tlsconfig.PeerName(ctx.Request.TLS); name != "" {
+			ctx.Set(clientKey, name)
+			ctx.Next()
+			return
+		}
+		if agentID := ctx.GetString(agentKey); agentID != "" {
+			ctx.Set(clientKey, agentID)
+			ctx.Next()
+			return
+		}
+		key := ctx.RemoteIP()
+		if subnet != nil {
+			if ip := net.ParseIP(ctx.Request.Header.Get("X-Real-IP")); ip != nil && subnet.Contains(ip) {
+				key = ip.String()
+			}
+		}
+		ctx.Set(clientKey, key)
+		ctx.Next()
+	}
+}
+
+// tooManyRequests aborts the request with 429 and the Retry-After header.
+func tooManyRequests(ctx *gin.Context, err *ratelimit.LimitError) {
+	ctx.Header("Retry-After", strconv.Itoa(err.RetryAfterSeconds()))
+	ctx.AbortWithError(http.StatusTooManyRequests, err)
+}
+
+// rateLimit rejects requests of clients exceeding the rate limit.
+func rateLimit(l *ratelimit.Limiter) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var le *ratelimit.LimitError
+		if err := l.Allow(ctx.GetString(clientKey), time.Now()); errors.As(err, &le) {
+			tooManyRequests(ctx, le)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// identityKey is the gin context key of the authenticated auth.Identity.
+const identityKey = "identity"
+
+// authenticate checks the token and its scope, stores the identity in the context
+// and continues the chain or aborts the request with 401 or 403.
+func authenticate(ctx *gin.Context, tokens *auth.Store, token string, scope auth.Scope) {
+	id, err := tokens.Authenticate(token, scope)
+	switch {
+	case errors.Is(err, auth.ErrForbidden):
+		ctx.AbortWithError(http.StatusForbidden, err)
+	case err != nil:
+		ctx.Header("WWW-Authenticate", "Bearer")
+		ctx.AbortWithError(http.StatusUnauthorized, err)
+	default:
+		ctx.Set(identityKey, id)
+		ctx.Next()
+	}
+}
+
+// requireScope rejects requests without a bearer token having the scope.
+func requireScope(tokens *auth.Store, scope auth.Scope) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		authenticate(ctx, tokens, auth.BearerToken(ctx.Request.Header.Get("Authorization")), scope)
+	}
+}
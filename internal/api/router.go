@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -17,9 +19,16 @@ import (
 	"github.com/xoxloviwan/go-monitor/internal/store"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/xoxloviwan/go-monitor/internal/agents"
 	asc "github.com/xoxloviwan/go-monitor/internal/asymcrypto"
+	"github.com/xoxloviwan/go-monitor/internal/audit"
+	"github.com/xoxloviwan/go-monitor/internal/auth"
 	config "github.com/xoxloviwan/go-monitor/internal/config_server"
 	grpcServ "github.com/xoxloviwan/go-monitor/internal/grpc"
+	"github.com/xoxloviwan/go-monitor/internal/ratelimit"
+	"github.com/xoxloviwan/go-monitor/internal/signing"
+	"github.com/xoxloviwan/go-monitor/internal/telemetry"
+	"github.com/xoxloviwan/go-monitor/internal/tlsconfig"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
@@ -41,18 +50,59 @@ type Storage interface {
 
 // Router interface for API server.
 type Router interface {
-	SetupRouter(ping gin.HandlerFunc, dbstore ReaderWriter, logLevel slog.Level, key []byte, privateKey *asc.PrivateKey, subnet *net.IPNet)
+	SetupRouter(p RouterParams)
 	Run(addr string) error
 	Shutdown() error
 }
 
+// RouterParams holds everything SetupRouter needs to build routes and middleware.
+type RouterParams struct {
+	// Ping handles the /ping route.
+	Ping gin.HandlerFunc
+	// Store is the metrics storage used by the handlers.
+	Store ReaderWriter
+	// LogLevel is the level of the request logger.
+	LogLevel slog.Level
+	// Key for verifying request signatures and signing responses.
+	Key []byte
+	// Keys holds private keys for decrypting request bodies, nil passes bodies as is.
+	Keys *asc.Keyring
+	// Subnet of trusted clients, nil allows any client.
+	Subnet *net.IPNet
+	// Stats collects self-metrics, nil disables instrumentation and the /metrics route.
+	Stats *telemetry.Server
+	// Health tracks component states for /healthz and /readyz, nil disables these routes.
+	Health *Health
+	// Admin handles the /admin/metrics routes, which are enabled only if AdminToken or Tokens is set too.
+	Admin store.Admin
+	// AdminToken is the bearer token required by the /admin/ routes.
+	AdminToken string
+	// Audit records administrative operations, nil discards records.
+	Audit *audit.Logger
+	// RateLimiter limits the rate of update requests of each client, nil disables limiting.
+	RateLimiter *ratelimit.Limiter
+	// Quota limits series and samples accepted from each client, nil disables quotas.
+	Quota *ratelimit.Quota
+	// Tokens authenticates bearer tokens and checks their scopes, nil disables token authentication.
+	Tokens *auth.Store
+	// TLS enables HTTPS with the configuration, nil serves plain HTTP.
+	TLS *tls.Config
+	// Nonces rejects replayed signed requests. It may be shared with the gRPC server, nil uses a new guard.
+	Nonces *signing.Guard
+	// Agents verifies requests signed with keys of agents and enables the /admin/agents routes, nil disables them.
+	Agents *agents.Registry
+}
+
+// tokensReloadInterval is how often the tokens and agents files are checked for changes.
+const tokensReloadInterval = 10 * time.Second
+
 // RunServer runs the API server with the given configuration.
 //
 // It sets up the routes, middleware, and logging, and starts the server.
 func RunServer(r Router, cfg config.Config) error {
 	var (
-		s           Storage
-		pingHandler gin.HandlerFunc
+		s            Storage
+		storageCheck func(ctx context.Context) error
 	)
 
 	// Если DSN не пустой, то используем базу данных.
@@ -62,16 +112,7 @@ func RunServer(r Router, cfg config.Config) error {
 			return err
 		}
 		defer db.Close()
-		pingHandler = func(c *gin.Context) {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			if err := db.PingContext(ctx); err != nil {
-				c.Error(err)
-				c.Status(http.StatusInternalServerError)
-				return
-			}
-			c.Status(http.StatusOK)
-		}
+		storageCheck = db.PingContext
 		dbs := store.NewDBStorage(db)
 		err = dbs.CreateTable()
 		if err != nil {
@@ -80,26 +121,31 @@ func RunServer(r Router, cfg config.Config) error {
 		s = dbs
 	} else {
 		// Если DSN пустой, то используем память.
-		pingHandler = func(c *gin.Context) {
-			c.Status(http.StatusOK)
-		}
 		s = store.NewMemStorage()
 	}
-
-	if cfg.Restore && cfg.FileStoragePath != "" {
-		if b, ok := s.(FileBackuper); ok {
-			if err := b.RestoreFromFile(cfg.FileStoragePath); err != nil {
-				Log.Error("restore data error", "path", cfg.FileStoragePath, "error", err) // fix autotests for iter9 if file not exist
-			}
+	pingHandler := func(c *gin.Context) {
+		if storageCheck == nil {
+			c.Status(http.StatusOK)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := storageCheck(ctx); err != nil {
+			c.Error(err)
+			c.Status(http.StatusInternalServerError)
+			return
 		}
+		c.Status(http.StatusOK)
 	}
+	health := NewHealth(storageCheck)
 
 	var err error
-	var pKey *asc.PrivateKey
+	var keys *asc.Keyring
 	if cfg.CryptoKey != "" {
-		if pKey, err = asc.GetPrivateKey(cfg.CryptoKey); err != nil {
+		if keys, err = asc.LoadKeyring(strings.Split(cfg.CryptoKey, ",")...); err != nil {
 			return fmt.Errorf("get private key error: %w", err)
 		}
+		Log.Info("Loaded private keys", "ids", keys.IDs())
 	}
 
 	var subnet *net.IPNet
@@ -110,28 +156,126 @@ func RunServer(r Router, cfg config.Config) error {
 		return fmt.Errorf("parse subnet error: %w", err)
 	}
 
+	stats := telemetry.NewServer()
+	instrumented := newStatsStore(s, stats)
+
+	var (
+		limiter *ratelimit.Limiter
+		quota   *ratelimit.Quota
+	)
+	if cfg.RateLimit > 0 {
+		limiter = ratelimit.NewLimiter(cfg.RateLimit, cfg.RateBurst)
+	}
+	if cfg.MaxSeries > 0 || cfg.MaxSamples > 0 {
+		quota = ratelimit.NewQuota(cfg.MaxSeries, cfg.MaxSamples)
+	}
+
+	var tokens *auth.Store
+	if cfg.TokensFile != "" {
+		if tokens, err = auth.Load(cfg.TokensFile); err != nil {
+			return fmt.Errorf("load tokens error: %w", err)
+		}
+	}
+
+	var registry *agents.Registry
+	if cfg.AgentsFile != "" {
+		if registry, err = agents.Load(cfg.AgentsFile); err != nil {
+			return fmt.Errorf("load agents error: %w", err)
+		}
+	}
+
+	var (
+		admin    store.Admin
+		auditLog *audit.Logger
+	)
+	if cfg.AdminToken != "" || tokens != nil {
+		if a, ok := s.(store.Admin); ok {
+			admin = a
+		}
+		if cfg.AuditFile != "" {
+			if auditLog, err = audit.Open(cfg.AuditFile); err != nil {
+				return fmt.Errorf("open audit log error: %w", err)
+			}
+			defer auditLog.Close()
+		} else {
+			auditLog = audit.New(os.Stdout)
+		}
+	}
+
+	var tlsCfg *tls.Config
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		if tlsCfg, err = tlsconfig.Server(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA); err != nil {
+			return fmt.Errorf("tls config error: %w", err)
+		}
+	} else if cfg.TLSClientCA != "" {
+		return errors.New("tls client CA requires server certificate and key")
+	}
+
+	// Один nonce нельзя использовать повторно ни по HTTP, ни по gRPC.
+	nonces := signing.NewGuard(signing.Window)
+
 	// Настраиваем маршруты.
-	r.SetupRouter(pingHandler, s, slog.LevelInfo, []byte(cfg.Key), pKey, subnet)
+	r.SetupRouter(RouterParams{
+		Ping:        pingHandler,
+		Store:       instrumented,
+		LogLevel:    slog.LevelInfo,
+		Key:         []byte(cfg.Key),
+		Keys:        keys,
+		Subnet:      subnet,
+		Stats:       stats,
+		Health:      health,
+		Admin:       admin,
+		AdminToken:  cfg.AdminToken,
+		Audit:       auditLog,
+		RateLimiter: limiter,
+		Quota:       quota,
+		Tokens:      tokens,
+		TLS:         tlsCfg,
+		Nonces:      nonces,
+		Agents:      registry,
+	})
 
 	grpcL, err := net.Listen("tcp", ":2323")
 	if err != nil {
 		return fmt.Errorf("grpc listener error: %w", err)
 	}
 	Log.Info("Start listening gRPC on", "addr", grpcL.Addr())
-	grpcS := grpcServ.NewGrpcServer(Log, []byte(cfg.Key), subnet)
+	batches, _ := s.(store.BatchChecker)
+	grpcS := grpcServ.NewGrpcServer(grpcServ.ServerParams{
+		Log:         Log,
+		Key:         []byte(cfg.Key),
+		Subnet:      subnet,
+		Stats:       stats,
+		Ready:       health.Ready,
+		AdminToken:  cfg.AdminToken,
+		RateLimiter: limiter,
+		Quota:       quota,
+		Batches:     batches,
+		Tokens:      tokens,
+		TLS:         tlsCfg,
+		Nonces:      nonces,
+		Agents:      registry,
+		Keys:        keys,
+	})
+	grpcHealth := grpcServ.RegisterHealth(grpcS)
 
 	// Создаем канал для сигналов завершения.
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
+	// Канал закрывается при завершении работы и останавливает фоновые задачи.
+	// Сигнал читает только одна горутина, поэтому фоновые задачи ждут done, а не quit.
+	done := make(chan struct{})
+
 	var eg errgroup.Group
 	eg.Go(func() error {
 		// Ждем сигнала завершения.
 		<-quit
 		Log.Info("Shutdown Server...")
 		signal.Stop(quit)
-		close(quit) // Остановим периодическое сохранение данных в файл.
+		close(done) // Остановим периодическое сохранение данных в файл.
 		// Завершаем работу сервера.
+		grpcHealth.Shutdown()
 		grpcS.GracefulStop()
 		return r.Shutdown()
 	})
@@ -143,16 +287,23 @@ func RunServer(r Router, cfg config.Config) error {
 	)
 	// Если объект реализует интерфейс FileBackuper, то сохраняем данные в файл.
 	if b, ok = s.(FileBackuper); ok && cfg.StoreInterval > 0 && cfg.FileStoragePath != "" {
+		health.EnableBackup()
 		eg.Go(func() error {
 			backupTicker := time.NewTicker(time.Duration(cfg.StoreInterval) * time.Second)
 			defer backupTicker.Stop()
 			for {
 				select {
 				case <-backupTicker.C:
-					if err := b.SaveToFile(cfg.FileStoragePath); err != nil {
+					if !health.Ready() {
+						// Не перезаписываем файл, пока из него не восстановлены данные.
+						continue
+					}
+					err := saveBackup(b, cfg.FileStoragePath, stats)
+					health.BackupDone(err)
+					if err != nil {
 						return fmt.Errorf("backup ticker data error: %w", err)
 					}
-				case <-quit:
+				case <-done:
 					Log.Info("Shutdown backup ticker...")
 					return nil
 				}
@@ -160,12 +311,63 @@ func RunServer(r Router, cfg config.Config) error {
 		})
 	}
 
-	grpcServ.SetupServer(grpcS, s)
+	// Перечитываем файлы токенов и агентов при их изменении.
+	if tokens != nil {
+		eg.Go(func() error {
+			tokens.Watch(done, tokensReloadInterval)
+			return nil
+		})
+	}
+	if registry != nil {
+		eg.Go(func() error {
+			registry.Watch(done, tokensReloadInterval)
+			return nil
+		})
+	}
+
+	// Периодически сохраняем собственные метрики сервера в хранилище.
+	if cfg.SelfMetricsInterval > 0 {
+		eg.Go(func() error {
+			selfTicker := time.NewTicker(time.Duration(cfg.SelfMetricsInterval) * time.Second)
+			defer selfTicker.Stop()
+			for {
+				select {
+				case <-selfTicker.C:
+					if err := saveSelfMetrics(context.Background(), instrumented, stats); err != nil {
+						Log.Error("save self metrics error", "error", err)
+					}
+				case <-done:
+					return nil
+				}
+			}
+		})
+	}
+
+	grpcServ.SetupServer(grpcS, instrumented)
+	if admin != nil {
+		grpcServ.SetupAdmin(grpcS, admin, auditLog)
+	}
 
 	eg.Go(func() error {
+		health.SetGRPCServing(true)
+		defer health.SetGRPCServing(false)
 		return grpcS.Serve(grpcL)
 	})
 
+	// Восстанавливаем данные из файла в фоне, пока сервер не готов принимать метрики.
+	go func() {
+		var err error
+		if cfg.Restore && cfg.FileStoragePath != "" {
+			if b, ok := s.(FileBackuper); ok {
+				if err = b.RestoreFromFile(cfg.FileStoragePath); err != nil {
+					Log.Error("restore data error", "path", cfg.FileStoragePath, "error", err) // fix autotests for iter9 if file not exist
+				}
+			}
+		}
+		health.SetRestored(err)
+		grpcServ.SetServing(grpcHealth)
+	}()
+
 	// Запускаем сервер http
 	if err := r.Run(cfg.Address); err != nil {
 		if !errors.Is(err, http.ErrServerClosed) {
@@ -175,7 +377,10 @@ func RunServer(r Router, cfg config.Config) error {
 
 	// Делем одноразовое сохранение данных в файл при завершении работы.
 	if b, ok := s.(FileBackuper); ok && cfg.FileStoragePath != "" {
-		if err := b.SaveToFile(cfg.FileStoragePath); err != nil {
+		if !health.Ready() {
+			// Восстановление не завершилось, неполные данные не должны затереть файл.
+			Log.Warn("Skip backup on shutdown: data is not restored yet", "path", cfg.FileStoragePath)
+		} else if err := saveBackup(b, cfg.FileStoragePath, stats); err != nil {
 			return fmt.Errorf("backup data error: %w", err)
 		}
 	}
@@ -188,48 +393,115 @@ func RunServer(r Router, cfg config.Config) error {
 	return nil
 }
 
+// saveBackup saves data to the file and records the backup duration.
+func saveBackup(b FileBackuper, path string, stats *telemetry.Server) error {
+	start := time.Now()
+	err := b.SaveToFile(path)
+	stats.BackupDuration.Since(start)
+	if err != nil {
+		stats.BackupErrors.Inc()
+	}
+	return err
+}
+
 // RouterImpl is wrap for gin.Engine and http.Server
 type RouterImpl struct {
 	*gin.Engine
 	srv *http.Server
+	tls *tls.Config
 }
 
 // NewRouter returns a new Router instance.
 func NewRouter() *RouterImpl {
-	return &RouterImpl{gin.New(), nil}
+	return &RouterImpl{gin.New(), nil, nil}
 }
 
 // SetupRouter sets up routes and middleware.
 //
-// The engine is initialized with the given ping handler, store, log level, and key.
-func (r *RouterImpl) SetupRouter(ping gin.HandlerFunc, dbstore ReaderWriter, logLevel slog.Level, key []byte, privateKey *asc.PrivateKey, subnet *net.IPNet) {
-	handler := newHandler(dbstore)
+// The engine is initialized with the given ping handler, store, log level, keys and trusted subnet.
+func (r *RouterImpl) SetupRouter(p RouterParams) {
+	handler := newHandler(p.Store)
+	handler.quota = p.Quota
+	r.tls = p.TLS
+	if p.Stats != nil {
+		r.Use(collectStats(p.Stats))
+	}
 	r.Use(compressGzip())
-	r.Use(logger(logLevel))
-	if subnet != nil {
-		r.Use(checkIP(subnet))
+	r.Use(logger(p.LogLevel))
+	if p.Health != nil {
+		// Пробы доступны до проверки подсети и подписи, а остальные маршруты ждут окончания восстановления данных.
+		r.GET("/healthz", livenessHandler(p.Health))
+		r.GET("/readyz", readinessHandler(p.Health))
+		r.Use(waitReady(p.Health))
+	}
+	if p.Subnet != nil {
+		r.Use(checkIP(p.Subnet))
 	}
-	if len(key) > 0 {
-		r.Use(verifyHash(key))
+	if p.Keys != nil {
+		r.Use(decryptBody(p.Keys))
+	}
+	// Подпись проверяется по расшифрованным метрикам.
+	if len(p.Key) > 0 || p.Agents != nil {
+		nonces := p.Nonces
+		if nonces == nil {
+			nonces = signing.NewGuard(signing.Window)
+		}
+		r.Use(verifyHash(p.Key, p.Agents, nonces))
 	}
-	if privateKey != nil {
-		r.Use(decryptBody(privateKey))
+	if p.RateLimiter != nil || p.Quota != nil {
+		r.Use(identifyClient(p.Subnet))
+	}
+	ingest := r.Group("")
+	read := r.Group("")
+	if p.Tokens != nil {
+		ingest.Use(requireScope(p.Tokens, auth.ScopeWrite))
+		read.Use(requireScope(p.Tokens, auth.ScopeRead))
+	}
+	// Если сервер проверяет подписи общим ключом или ключами агентов, неподписанные метрики не принимаются.
+	if len(p.Key) > 0 || p.Agents != nil {
+		ingest.Use(requireSignature())
+	}
+	if p.RateLimiter != nil {
+		ingest.Use(rateLimit(p.RateLimiter))
+	}
+	ingest.POST("/update/:metricType/:metricName/:metricValue", handler.update)
+	ingest.POST("/update/", handler.updateJSON)
+	ingest.POST("/updates/", handler.updateJSON)
+	read.GET("/value/:metricType/:metricName", handler.value)
+	read.POST("/value/", handler.valueJSON)
+	read.GET("/", handler.list)
+
+	r.GET("/ping", p.Ping)
+	if p.Stats != nil {
+		read.GET("/metrics", statsHandler(p.Stats))
 	}
-	r.POST("/update/:metricType/:metricName/:metricValue", handler.update)
-	r.POST("/update/", handler.updateJSON)
-	r.POST("/updates/", handler.updateJSON)
-	r.GET("/value/:metricType/:metricName", handler.value)
-	r.POST("/value/", handler.valueJSON)
-	r.GET("/", handler.list)
 
-	r.GET("/ping", ping)
+	if (p.Admin != nil || p.Agents != nil) && (p.AdminToken != "" || p.Tokens != nil) {
+		admin := &adminHandler{admin: p.Admin, agents: p.Agents, audit: p.Audit}
+		g := r.Group("/admin", checkAdmin(p.AdminToken, p.Tokens))
+		if p.Admin != nil {
+			g.DELETE("/metrics", admin.deleteMatching)
+			g.DELETE("/metrics/:metricName", admin.delete)
+			g.POST("/metrics/:metricName/reset", admin.reset)
+			g.POST("/metrics/:metricName/rename", admin.rename)
+		}
+		if p.Agents != nil {
+			g.GET("/agents", admin.listAgents)
+			g.POST("/agents/:agentID/revoke", admin.revokeAgent)
+		}
+	}
 }
 
 // Run starts the server listening on the specified address.
+// The server uses TLS if it was configured by SetupRouter.
 func (r *RouterImpl) Run(addr string) error {
 	r.srv = &http.Server{
-		Addr:    addr,
-		Handler: r.Handler(),
+		Addr:      addr,
+		Handler:   r.Handler(),
+		TLSConfig: r.tls,
+	}
+	if r.tls != nil {
+		return r.srv.ListenAndServeTLS("", "")
 	}
 	return r.srv.ListenAndServe()
 }
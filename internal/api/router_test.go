@@ -4,7 +4,13 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -12,15 +18,27 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang/mock/gomock"
 	"github.com/google/go-cmp/cmp"
 	mock "github.com/xoxloviwan/go-monitor/internal/api/mock"
+	asc "github.com/xoxloviwan/go-monitor/internal/asymcrypto"
+	"github.com/xoxloviwan/go-monitor/internal/audit"
+	"github.com/xoxloviwan/go-monitor/internal/auth"
+	"github.com/xoxloviwan/go-monitor/internal/clients/base"
+	httpclient "github.com/xoxloviwan/go-monitor/internal/clients/http"
 	conf "github.com/xoxloviwan/go-monitor/internal/config_server"
 	mt "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+	"github.com/xoxloviwan/go-monitor/internal/ratelimit"
+	"github.com/xoxloviwan/go-monitor/internal/signing"
+	"github.com/xoxloviwan/go-monitor/internal/store"
+	"github.com/xoxloviwan/go-monitor/internal/telemetry"
 )
 
 type want struct {
@@ -44,7 +62,7 @@ type testcasesWithBody []struct {
 	wantBody           string
 	reqContentEncoding string
 	acceptEncoding     string
-	HashSHA256         string
+	signed             bool
 	lastCounterValue   int64
 	lastGaugeValue     float64
 	ipReq              string
@@ -64,10 +82,24 @@ func setup(t *testing.T, subnet bool) (*RouterImpl, *mock.MockReaderWriter) {
 	if subnet {
 		_, netIP, _ = net.ParseCIDR("192.168.1.0/26")
 	}
-	r.SetupRouter(ping, m, slog.LevelError, []byte("test"), nil, netIP)
+	r.SetupRouter(RouterParams{
+		Ping:     ping,
+		Store:    m,
+		LogLevel: slog.LevelError,
+		Key:      []byte("test"),
+		Subnet:   netIP,
+	})
 	return r, m
 }
 
+// sign подписывает запрос ключом из setup.
+func sign(req *http.Request, body []byte) {
+	timestamp, nonce := signing.Timestamp(time.Now()), signing.NewNonce()
+	req.Header.Set(signing.TimestampHeader, timestamp)
+	req.Header.Set(signing.NonceHeader, nonce)
+	req.Header.Set(signing.HashHeader, signing.SignHex([]byte("test"), timestamp, nonce, mt.CanonicalBody(body, "")))
+}
+
 func Test_update_value(t *testing.T) {
 
 	tests := testcases{
@@ -108,6 +140,24 @@ func Test_update_value(t *testing.T) {
 				err:         errors.New("unknown metric type"),
 			},
 		},
+		{
+			name:   "service_post_400_nan",
+			url:    "/update/gauge/someMetric/NaN",
+			method: http.MethodPost,
+			want: want{
+				code:        http.StatusBadRequest,
+				contentType: "plain/text",
+			},
+		},
+		{
+			name:   "service_post_400_name",
+			url:    "/update/counter/some%20metric/1",
+			method: http.MethodPost,
+			want: want{
+				code:        http.StatusBadRequest,
+				contentType: "plain/text",
+			},
+		},
 		{
 			name:   "service_post_404",
 			url:    "/update/other",
@@ -158,6 +208,7 @@ func Test_update_value(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			req := httptest.NewRequest(tt.method, tt.url, nil)
+			sign(req, nil)
 			w := httptest.NewRecorder()
 			urlSpl := strings.Split(tt.url, "/")
 			var metricType string
@@ -297,6 +348,7 @@ func Test_updateJSON(t *testing.T) {
 			req.Header = map[string][]string{
 				"Content-Type": {"application/json"},
 			}
+			sign(req, []byte(tt.reqBody))
 
 			gotInput := mt.Metrics{}
 			err = gotInput.UnmarshalJSON([]byte(tt.reqBody))
@@ -419,7 +471,7 @@ func Test_valueJSON(t *testing.T) {
 			wantBody:         `{"id": "someMetric", "type": "gauge", "value": 23.4}`,
 			lastCounterValue: 0,
 			lastGaugeValue:   23.4,
-			HashSHA256:       "e7920d999a1fb76b43dae4085f5c6e38e0566d9bc49fe4e1a21586c8a7adee61",
+			signed:           true,
 		},
 		{
 			testcase: testcase{
@@ -474,8 +526,8 @@ func Test_valueJSON(t *testing.T) {
 			if tt.acceptEncoding == "gzip" {
 				req.Header.Add("Accept-Encoding", "gzip")
 			}
-			if tt.HashSHA256 != "" {
-				req.Header.Add("HashSHA256", tt.HashSHA256)
+			if tt.signed {
+				sign(req, reqBodyJSON)
 			}
 
 			gotInput := mt.Metrics{}
@@ -555,7 +607,42 @@ func Test_updatesJSON(t *testing.T) {
 				{"id":"someMetric","type":"counter","delta":10},
 				{"id":"someMetric","type":"counter","delta":20}
 			]`,
-			wantBody: `[{"id": "someMetric", "type": "counter", "delta": 30}]`,
+			wantBody: `{"accepted":[{"id": "someMetric", "type": "counter", "delta": 30}],"accepted_index":[0,1,2],"rejected":[]}`,
+		},
+		{
+			testcase: testcase{
+				name:   "service_post_updates_partial_json_200",
+				url:    "/updates/",
+				method: http.MethodPost,
+				want: want{
+					code:        http.StatusOK,
+					contentType: "application/json",
+				},
+			},
+			reqBody: `[
+				{"id":"someMetric","type":"counter","delta":30},
+				{"id":"noValue","type":"gauge"},
+				{"id":"bad name","type":"gauge","value":1},
+				{"id":"someMetric","type":"histogram","value":1}
+			]`,
+			wantBody: `{"accepted":[{"id": "someMetric", "type": "counter", "delta": 30}],"accepted_index":[0],"rejected":[
+				{"index":1,"id":"noValue","type":"gauge","reason":"missing value"},
+				{"index":2,"id":"bad name","type":"gauge","reason":"invalid character in name: ' '"},
+				{"index":3,"id":"someMetric","type":"histogram","reason":"unknown type \"histogram\""}
+			]}`,
+		},
+		{
+			testcase: testcase{
+				name:   "service_post_updates_all_rejected_json_400",
+				url:    "/updates/",
+				method: http.MethodPost,
+				want: want{
+					code:        http.StatusBadRequest,
+					contentType: "application/json",
+				},
+			},
+			reqBody:  `[{"id":"","type":"counter","delta":1}]`,
+			wantBody: `{"accepted":[],"accepted_index":[],"rejected":[{"index":0,"id":"","type":"counter","reason":"empty name"}]}`,
 		},
 	}
 
@@ -569,7 +656,9 @@ func Test_updatesJSON(t *testing.T) {
 
 			req.Header = map[string][]string{
 				"Content-Type": {"application/json"},
+				"Accept":       {mt.BatchResultType},
 			}
+			sign(req, []byte(tt.reqBody))
 
 			router, m := setup(t, false)
 
@@ -577,21 +666,16 @@ func Test_updatesJSON(t *testing.T) {
 			if err = gotInputList.UnmarshalJSON([]byte(tt.reqBody)); err != nil {
 				t.Error(err)
 			}
+			var want mt.BatchResult
+			if err = want.UnmarshalJSON([]byte(tt.wantBody)); err != nil {
+				t.Fatal(err)
+			}
+			accepted, _ := gotInputList.Split()
 
-			m.EXPECT().AddMetrics(gomock.Any(), &gotInputList).Return(nil).Times(1)
-
-			m.EXPECT().GetMetrics(gomock.Any(), gotInputList).DoAndReturn(func(ctx context.Context, gotOutputList mt.MetricsList) (mt.MetricsList, error) {
-				wantOutputList := mt.MetricsList{}
-				res := mt.Metrics{
-					ID:    "someMetric",
-					MType: "counter",
-				}
-				var val int64 = 30
-				res.Delta = new(int64)
-				res.Delta = &val
-				wantOutputList = append(wantOutputList, res)
-				return wantOutputList, nil
-			}).Times(1)
+			if len(accepted) > 0 {
+				m.EXPECT().AddMetrics(gomock.Any(), &accepted).Return(nil).Times(1)
+				m.EXPECT().GetMetrics(gomock.Any(), accepted).Return(want.Accepted, nil).Times(1)
+			}
 
 			router.ServeHTTP(w, req)
 
@@ -606,14 +690,10 @@ func Test_updatesJSON(t *testing.T) {
 			if err != nil {
 				t.Error(err)
 			}
-			var got = mt.MetricsList{}
-			var want = mt.MetricsList{}
+			var got mt.BatchResult
 			if err = got.UnmarshalJSON(bodyBytes); err != nil {
 				t.Error(err)
 			}
-			if err = want.UnmarshalJSON([]byte(tt.wantBody)); err != nil {
-				t.Error(err)
-			}
 
 			if diff := cmp.Diff(want, got); diff != "" {
 				t.Errorf("Body mismatch (-want +got):\n%s", diff)
@@ -622,12 +702,481 @@ func Test_updatesJSON(t *testing.T) {
 	}
 }
 
+// Test_updatesJSON_list checks the response to clients which do not accept BatchResult.
+func Test_updatesJSON_list(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	r := NewRouter()
+	r.SetupRouter(RouterParams{
+		Ping:     func(c *gin.Context) { c.Status(http.StatusOK) },
+		Store:    store.NewMemStorage(),
+		LogLevel: slog.LevelError,
+	})
+	body := `[{"id":"PollCount","type":"counter","delta":5},{"id":"Alloc","type":"gauge"}]`
+	req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want code %d, got %d", http.StatusOK, w.Code)
+	}
+	if got := w.Header().Get(mt.RejectedHeader); got != "1" {
+		t.Errorf("%s = %q, want %q", mt.RejectedHeader, got, "1")
+	}
+	var got mt.MetricsList
+	if err := got.UnmarshalJSON(w.Body.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != "PollCount" || *got[0].Delta != 5 {
+		t.Errorf("body = %s, want the stored PollCount", w.Body.String())
+	}
+}
+
+func Test_updatesJSON_batchID(t *testing.T) {
+	s := store.NewMemStorage()
+	gin.SetMode(gin.ReleaseMode)
+	r := NewRouter()
+	r.SetupRouter(RouterParams{
+		Ping:     func(c *gin.Context) { c.Status(http.StatusOK) },
+		Store:    s,
+		LogLevel: slog.LevelError,
+	})
+
+	send := func(batchID string) {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[{"id":"PollCount","type":"counter","delta":5}]`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(mt.BatchIDHeader, batchID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("want code %d, got %d", http.StatusOK, w.Code)
+		}
+	}
+	send("batch1")
+	send("batch1")
+	if got, _ := s.Get("counter", "PollCount"); got != "5" {
+		t.Errorf("counter after retry = %s, want %s", got, "5")
+	}
+	send("batch2")
+	if got, _ := s.Get("counter", "PollCount"); got != "10" {
+		t.Errorf("counter after new batch = %s, want %s", got, "10")
+	}
+}
+
+func Test_rateLimit(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	_, subnet, _ := net.ParseCIDR("192.168.1.0/26")
+	r := NewRouter()
+	r.SetupRouter(RouterParams{
+		Ping:        func(c *gin.Context) { c.Status(http.StatusOK) },
+		Store:       store.NewMemStorage(),
+		LogLevel:    slog.LevelError,
+		Subnet:      subnet,
+		RateLimiter: ratelimit.NewLimiter(1, 2),
+		Quota:       ratelimit.NewQuota(2, 0),
+	})
+
+	send := func(ip, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Real-IP", ip)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	const body = `[{"id":"PollCount","type":"counter","delta":1}]`
+
+	for i := 0; i < 2; i++ {
+		if w := send("192.168.1.12", body); w.Code != http.StatusOK {
+			t.Fatalf("request %d: want code %d, got %d", i, http.StatusOK, w.Code)
+		}
+	}
+	w := send("192.168.1.12", body)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("over rate limit: want code %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Retry-After = %q, want %q", w.Header().Get("Retry-After"), "1")
+	}
+
+	// Другой агент ограничивается отдельно, но не может превысить квоту на число рядов.
+	w = send("192.168.1.13", `[{"id":"a","type":"counter","delta":1},{"id":"b","type":"counter","delta":1},{"id":"c","type":"counter","delta":1}]`)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("over series quota: want code %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Retry-After header is missing")
+	}
+
+	// Отклоненные метрики не расходуют квоту.
+	w = send("192.168.1.14", `[{"id":"bad 1","type":"counter","delta":1},{"id":"bad 2","type":"counter","delta":1},{"id":"bad 3","type":"counter","delta":1},{"id":"a","type":"counter","delta":1}]`)
+	if w.Code != http.StatusOK {
+		t.Errorf("rejected metrics over series quota: want code %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func Test_quota_notCharged(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	r := NewRouter()
+	r.SetupRouter(RouterParams{
+		Ping:     func(c *gin.Context) { c.Status(http.StatusOK) },
+		Store:    store.NewMemStorage(),
+		LogLevel: slog.LevelError,
+		Quota:    ratelimit.NewQuota(0, 1),
+	})
+	send := func(url, batchID string) int {
+		req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(`[{"id":"PollCount","type":"counter","delta":1}]`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(mt.BatchIDHeader, batchID)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	// Некорректная метрика и повтор примененного пакета не расходуют квоту.
+	if code := send("/update/gauge/Alloc/NaN", ""); code != http.StatusBadRequest {
+		t.Errorf("invalid metric: want code %d, got %d", http.StatusBadRequest, code)
+	}
+	for _, id := range []string{"batch1", "batch1"} {
+		if code := send("/updates/", id); code != http.StatusOK {
+			t.Errorf("batch %s: want code %d, got %d", id, http.StatusOK, code)
+		}
+	}
+	if code := send("/updates/", "batch2"); code != http.StatusTooManyRequests {
+		t.Errorf("new batch over quota: want code %d, got %d", http.StatusTooManyRequests, code)
+	}
+}
+
+func Test_verifyHash_replay(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	r := NewRouter()
+	r.SetupRouter(RouterParams{
+		Ping:     func(c *gin.Context) { c.Status(http.StatusOK) },
+		Store:    store.NewMemStorage(),
+		LogLevel: slog.LevelError,
+		Key:      []byte("test"),
+	})
+	const body = `[{"id":"PollCount","type":"counter","delta":1}]`
+	send := func(timestamp, nonce string, sign string) int {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(signing.TimestampHeader, timestamp)
+		req.Header.Set(signing.NonceHeader, nonce)
+		req.Header.Set(signing.HashHeader, sign)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	now := signing.Timestamp(time.Now())
+	stale := signing.Timestamp(time.Now().Add(-time.Hour))
+	key := []byte("test")
+	signed := mt.CanonicalBody([]byte(body), "")
+
+	tests := []struct {
+		name      string
+		timestamp string
+		nonce     string
+		sign      string
+		wantCode  int
+	}{
+		{"signed", now, "n1", signing.SignHex(key, now, "n1", signed), http.StatusOK},
+		{"replayed", now, "n1", signing.SignHex(key, now, "n1", signed), http.StatusBadRequest},
+		{"new nonce", now, "n2", signing.SignHex(key, now, "n2", signed), http.StatusOK},
+		{"stale", stale, "n3", signing.SignHex(key, stale, "n3", signed), http.StatusBadRequest},
+		{"nonce not signed", now, "n4", signing.SignHex(key, now, "n1", signed), http.StatusBadRequest},
+		{"no timestamp", "", "", signing.SignHex(key, "", "", signed), http.StatusBadRequest},
+		{"not signed", now, "n5", "", http.StatusUnauthorized},
+		{"short hash", now, "n6", "abcd", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := send(tt.timestamp, tt.nonce, tt.sign); code != tt.wantCode {
+				t.Errorf("want code %d, got %d", tt.wantCode, code)
+			}
+		})
+	}
+}
+
+// Test_clientSignature checks that the server accepts metrics signed by the HTTP client,
+// also when the body is encrypted and the signature covers the decrypted metrics.
+func Test_clientSignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := asc.NewKeyring(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		serverKey string
+		keys      *asc.Keyring
+		clientKey string
+		publicKey *asc.PublicKey
+		wantSaved bool
+	}{
+		{"signed", "test", nil, "test", nil, true},
+		{"signed and encrypted", "test", keys, "test", &rsaKey.PublicKey, true},
+		{"other key", "test", keys, "other", &rsaKey.PublicKey, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := store.NewMemStorage()
+			gin.SetMode(gin.ReleaseMode)
+			r := NewRouter()
+			r.SetupRouter(RouterParams{
+				Ping:     func(c *gin.Context) { c.Status(http.StatusOK) },
+				Store:    st,
+				LogLevel: slog.LevelError,
+				Key:      []byte(tt.serverKey),
+				Keys:     tt.keys,
+			})
+			srv := httptest.NewServer(r)
+			defer srv.Close()
+
+			delta := int64(3)
+			value := 0.25
+			msgs := mt.MetricsList{
+				{ID: "PollCount", MType: mt.CounterName, Delta: &delta},
+				{ID: "Alloc", MType: mt.GaugeName, Value: &value},
+			}
+			cl := httpclient.New(base.Client{Addr: strings.TrimPrefix(srv.URL, "http://"), Key: tt.clientKey, PublicKey: tt.publicKey})
+			defer cl.Close()
+			if err := cl.Send(context.Background(), 1, msgs); (err == nil) != tt.wantSaved {
+				t.Errorf("Send() error = %v", err)
+			}
+			_, saved := st.Get(mt.CounterName, "PollCount")
+			if saved != tt.wantSaved {
+				t.Errorf("metrics saved = %v, want %v", saved, tt.wantSaved)
+			}
+		})
+	}
+}
+
+func Test_decryptBody(t *testing.T) {
+	oldKey, err := asc.GetPrivateKey("../asymcrypto/private.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := asc.NewKeyring(oldKey, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newID, err := asc.KeyID(&newKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewMemStorage()
+	gin.SetMode(gin.ReleaseMode)
+	r := NewRouter()
+	r.SetupRouter(RouterParams{
+		Ping:     func(c *gin.Context) { c.Status(http.StatusOK) },
+		Store:    st,
+		LogLevel: slog.LevelError,
+		Keys:     keys,
+	})
+	const body = `[{"id":"PollCount","type":"counter","delta":1}]`
+
+	sessionKey, v1, err := asc.Encrypt(&oldKey.PublicKey, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := asc.Seal(&oldKey.PublicKey, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2New, err := asc.Seal(&newKey.PublicKey, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Clone(v2)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name     string
+		body     []byte
+		key      []byte
+		keyID    string
+		wantCode int
+	}{
+		{"plain", []byte(body), nil, "", http.StatusOK},
+		{"v1", v1, sessionKey, "", http.StatusOK},
+		{"v2", v2, nil, "", http.StatusOK},
+		{"v2 new key without id", v2New, nil, "", http.StatusOK},
+		{"v2 new key with id", v2New, nil, newID, http.StatusOK},
+		{"v2 unknown id", v2New, nil, "0123456789abcdef", http.StatusBadRequest},
+		{"v2 tampered", tampered, nil, "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.key != nil {
+				req.Header.Set("X-Key", hex.EncodeToString(tt.key))
+			}
+			if tt.keyID != "" {
+				req.Header.Set(asc.KeyIDHeader, tt.keyID)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("want code %d, got %d", tt.wantCode, w.Code)
+			}
+		})
+	}
+	if v, _ := st.Get("counter", "PollCount"); v != "5" {
+		t.Errorf("PollCount = %v, want 5", v)
+	}
+}
+
+func Test_identifyClient(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	_, subnet, _ := net.ParseCIDR("192.168.1.0/26")
+	r := gin.New()
+	r.Use(identifyClient(subnet))
+	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, c.GetString(clientKey)) })
+
+	withCert := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "agent-1"}}}},
+	}
+	tests := []struct {
+		name   string
+		ip     string
+		tls    *tls.ConnectionState
+		wantID string
+	}{
+		{"remote address", "", nil, "192.0.2.1"},
+		{"trusted ip", "192.168.1.12", nil, "192.168.1.12"},
+		{"untrusted ip", "10.0.0.1", nil, "192.0.2.1"},
+		{"tls without client certificate", "192.168.1.12", &tls.ConnectionState{}, "192.168.1.12"},
+		{"client certificate", "192.168.1.12", withCert, "agent-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.ip != "" {
+				req.Header.Set("X-Real-IP", tt.ip)
+			}
+			req.TLS = tt.tls
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Body.String() != tt.wantID {
+				t.Errorf("client = %q, want %q", w.Body.String(), tt.wantID)
+			}
+		})
+	}
+}
+
+func Test_checkIP(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	_, subnet, _ := net.ParseCIDR("192.168.1.0/26")
+	r := gin.New()
+	r.Use(checkIP(subnet))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	withCert := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "agent-1"}}}},
+	}
+	tests := []struct {
+		name     string
+		ip       string
+		tls      *tls.ConnectionState
+		wantCode int
+	}{
+		{"trusted ip", "192.168.1.12", nil, http.StatusOK},
+		{"untrusted ip", "10.0.0.1", nil, http.StatusForbidden},
+		{"tls without client certificate", "10.0.0.1", &tls.ConnectionState{}, http.StatusForbidden},
+		{"client certificate", "10.0.0.1", withCert, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Real-IP", tt.ip)
+			req.TLS = tt.tls
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("want code %d, got %d", tt.wantCode, w.Code)
+			}
+		})
+	}
+}
+
+func Test_requireScope(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	data := `{"tokens": [
+		{"name": "agent", "hash": "` + auth.HashToken("agent-secret") + `", "scopes": ["write"]},
+		{"name": "dashboard", "hash": "` + auth.HashToken("dash-secret") + `", "scopes": ["read"]},
+		{"name": "root", "hash": "` + auth.HashToken("root-secret") + `", "scopes": ["admin"]}
+	]}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewMemStorage()
+	var auditBuf bytes.Buffer
+	gin.SetMode(gin.ReleaseMode)
+	r := NewRouter()
+	r.SetupRouter(RouterParams{
+		Ping:     func(c *gin.Context) { c.Status(http.StatusOK) },
+		Store:    st,
+		LogLevel: slog.LevelError,
+		Admin:    st,
+		Tokens:   tokens,
+		Audit:    audit.New(&auditBuf),
+	})
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		token    string
+		wantCode int
+	}{
+		{"ping without token", http.MethodGet, "/ping", "", http.StatusOK},
+		{"update without token", http.MethodPost, "/update/counter/PollCount/1", "", http.StatusUnauthorized},
+		{"update with unknown token", http.MethodPost, "/update/counter/PollCount/1", "wrong", http.StatusUnauthorized},
+		{"update with read token", http.MethodPost, "/update/counter/PollCount/1", "dash-secret", http.StatusForbidden},
+		{"update with write token", http.MethodPost, "/update/counter/PollCount/1", "agent-secret", http.StatusOK},
+		{"value with write token", http.MethodGet, "/value/counter/PollCount", "agent-secret", http.StatusForbidden},
+		{"value with read token", http.MethodGet, "/value/counter/PollCount", "dash-secret", http.StatusOK},
+		{"value with admin token", http.MethodGet, "/value/counter/PollCount", "root-secret", http.StatusOK},
+		{"admin with read token", http.MethodPost, "/admin/metrics/PollCount/reset", "dash-secret", http.StatusForbidden},
+		{"admin with admin token", http.MethodPost, "/admin/metrics/PollCount/reset", "root-secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("want code %d, got %d", tt.wantCode, w.Code)
+			}
+			if tt.wantCode == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("WWW-Authenticate = %q, want %q", w.Header().Get("WWW-Authenticate"), "Bearer")
+			}
+		})
+	}
+	if !strings.Contains(auditBuf.String(), `"actor":"root"`) {
+		t.Errorf("audit record without the token name: %s", auditBuf.String())
+	}
+}
+
 func TestRunServer(t *testing.T) {
 	cfg := conf.Config{}
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 	m := NewMockRouter(ctrl)
-	m.EXPECT().SetupRouter(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(1)
+	m.EXPECT().SetupRouter(gomock.Any()).Times(1)
 	anyErr := fmt.Errorf("error")
 	m.EXPECT().Run(cfg.Address).Return(anyErr).Times(1)
 	err := RunServer(m, cfg)
@@ -635,3 +1184,38 @@ func TestRunServer(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func Test_metrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mock.NewMockReaderWriter(ctrl)
+	gin.SetMode(gin.ReleaseMode)
+	r := NewRouter()
+	stats := telemetry.NewServer()
+	r.SetupRouter(RouterParams{
+		Ping:     func(c *gin.Context) { c.Status(http.StatusOK) },
+		Store:    newStatsStore(m, stats),
+		LogLevel: slog.LevelError,
+		Stats:    stats,
+	})
+
+	m.EXPECT().String().Return("some string")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	res := w.Result()
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatal("Status code mismatch. want:", http.StatusOK, "got:", res.StatusCode)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `go_monitor_http_requests_total{method="GET",route="/",status="200"} 1`
+	if !strings.Contains(string(body), want) {
+		t.Errorf("%q not found in body:\n%s", want, body)
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	mtrTypes "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+	"github.com/xoxloviwan/go-monitor/internal/store"
+	"github.com/xoxloviwan/go-monitor/internal/telemetry"
+)
+
+// statsStore wraps a Storage and records latency and errors of batch operations.
+type statsStore struct {
+	Storage
+	stats *telemetry.Server
+}
+
+// newStatsStore returns the store wrapped with self-instrumentation.
+//
+// If stats is nil the store is returned as is.
+func newStatsStore(s Storage, stats *telemetry.Server) Storage {
+	if stats == nil {
+		return s
+	}
+	return &statsStore{Storage: s, stats: stats}
+}
+
+func (s *statsStore) observe(op string, start time.Time, err error) {
+	s.stats.StoreDuration.Since(start, op)
+	if err != nil {
+		s.stats.StoreErrors.Inc(op)
+	}
+}
+
+// AddMetrics calls AddMetrics of the wrapped store and records its latency and errors.
+func (s *statsStore) AddMetrics(ctx context.Context, m *mtrTypes.MetricsList) error {
+	start := time.Now()
+	err := s.Storage.AddMetrics(ctx, m)
+	s.observe("AddMetrics", start, err)
+	return err
+}
+
+// GetMetrics calls GetMetrics of the wrapped store and records its latency and errors.
+func (s *statsStore) GetMetrics(ctx context.Context, m mtrTypes.MetricsList) (mtrTypes.MetricsList, error) {
+	start := time.Now()
+	res, err := s.Storage.GetMetrics(ctx, m)
+	s.observe("GetMetrics", start, err)
+	return res, err
+}
+
+// BatchApplied calls BatchApplied of the wrapped store if it remembers applied batches.
+func (s *statsStore) BatchApplied(ctx context.Context, id string) (bool, error) {
+	if checker, ok := s.Storage.(store.BatchChecker); ok {
+		return checker.BatchApplied(ctx, id)
+	}
+	return false, nil
+}
+
+// saveSelfMetrics writes the current self-metrics into the store as gauges.
+func saveSelfMetrics(ctx context.Context, s Storage, stats *telemetry.Server) error {
+	snapshot := stats.Snapshot()
+	list := make(mtrTypes.MetricsList, 0, len(snapshot))
+	for name, v := range snapshot {
+		val := v
+		list = append(list, mtrTypes.Metrics{
+			ID:    name,
+			MType: mtrTypes.GaugeName,
+			Value: &val,
+		})
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return s.AddMetrics(ctx, &list)
+}
+
+func statsHandler(stats *telemetry.Server) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4")
+		c.Status(http.StatusOK)
+		if err := stats.WriteText(c.Writer); err != nil {
+			c.Error(err)
+		}
+	}
+}
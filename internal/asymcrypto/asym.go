@@ -5,8 +5,7 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
+	"fmt"
 	"io"
 	"os"
 )
@@ -17,34 +16,45 @@ type PrivateKey = rsa.PrivateKey
 // PublicKey alias
 type PublicKey = rsa.PublicKey
 
-func getCommonKey(path string) (*pem.Block, error) {
-	keyBytes, err := os.ReadFile(path)
+// GetPublicKey returns the RSA public key in PKCS#1 or PKIX format from the given path
+func GetPublicKey(path string) (*PublicKey, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	block, _ := pem.Decode(keyBytes)
-	return block, nil
-}
-
-// GetPublicKey returns the public key from the given path
-func GetPublicKey(path string) (*PublicKey, error) {
-	block, err := getCommonKey(path)
+	key, err := ParsePublicKey(data)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: %w", path, err)
 	}
-	return x509.ParsePKCS1PublicKey(block.Bytes)
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotRSA)
+	}
+	return rsaKey, nil
 }
 
-// GetPrivateKey returns the private key from the given path
+// GetPrivateKey returns the RSA private key in PKCS#1 or PKCS#8 format from the given path
 func GetPrivateKey(path string) (*PrivateKey, error) {
-	block, err := getCommonKey(path)
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	return x509.ParsePKCS1PrivateKey(block.Bytes)
+	key, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotRSA)
+	}
+	return rsaKey, nil
 }
 
 // Encrypt encrypts the data using the RSA public key and returns the encrypted session key and encrypted data
+//
+// This is the V1 format without integrity protection, it is kept for compatibility.
+//
+// Deprecated: use Seal.
 func Encrypt(publicKey *rsa.PublicKey, data []byte) (encryptedSessionKey []byte, encryptedData []byte, err error) {
 	// Generate a random session key (AES key)
 	sessionKey := make([]byte, 32) // 256-bit AES key
@@ -72,6 +82,8 @@ func Encrypt(publicKey *rsa.PublicKey, data []byte) (encryptedSessionKey []byte,
 }
 
 // Decrypt decrypts the data using the RSA private key and returns the decrypted data
+//
+// It reads the V1 format made by Encrypt, new data should be decrypted with Open.
 func Decrypt(privateKey *rsa.PrivateKey, encryptedSessionKey []byte, encryptedData []byte) (decryptedData []byte, err error) {
 	// Decrypt the session key using the private key
 	sessionKey, err := rsa.DecryptPKCS1v15(rand.Reader, privateKey, encryptedSessionKey)
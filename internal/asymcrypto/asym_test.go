@@ -2,6 +2,7 @@ package asymcrypto
 
 import (
 	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -69,3 +70,60 @@ func TestEncrypt(t *testing.T) {
 		t.Errorf("want %v, get %v", string(msg), string(got))
 	}
 }
+
+func TestSeal(t *testing.T) {
+	publicKey, err := GetPublicKey(filepath.Join(path, "public.pem"))
+	if err != nil {
+		t.Fatalf("GetPublicKey() error = %v", err)
+	}
+	privateKey, err := GetPrivateKey(filepath.Join(path, "private.pem"))
+	if err != nil {
+		t.Fatalf("GetPrivateKey() error = %v", err)
+	}
+
+	msg := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	envelope, err := Seal(publicKey, msg)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if !IsEnvelope(envelope) || envelope[3] != V2 {
+		t.Fatalf("Seal() header = %v", envelope[:4])
+	}
+	if IsEnvelope(msg) {
+		t.Error("IsEnvelope() of JSON must be false")
+	}
+	got, err := Open(privateKey, envelope)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Errorf("want %v, get %v", string(msg), string(got))
+	}
+
+	other, err := Seal(publicKey, msg)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if bytes.Equal(other, envelope) {
+		t.Error("Seal() must use random key and nonce")
+	}
+
+	tests := []struct {
+		name   string
+		modify func(b []byte) []byte
+	}{
+		{"ciphertext", func(b []byte) []byte { b[len(b)-1] ^= 1; return b }},
+		{"wrapped key", func(b []byte) []byte { b[10] ^= 1; return b }},
+		{"version", func(b []byte) []byte { b[3] = 3; return b }},
+		{"truncated", func(b []byte) []byte { return b[:20] }},
+		{"not envelope", func([]byte) []byte { return msg }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.modify(bytes.Clone(envelope))
+			if _, err := Open(privateKey, b); !errors.Is(err, ErrInvalidEnvelope) {
+				t.Errorf("Open() error = %v, want %v", err, ErrInvalidEnvelope)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package asymcrypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Версии формата зашифрованных данных.
+const (
+	// V1 is the legacy format of Encrypt: the session key is sent separately in the X-Key header.
+	V1 byte = 1
+	// V2 is the envelope format of Seal.
+	V2 byte = 2
+)
+
+// envelopeMagic starts every envelope, it is followed by the version byte.
+// JSON bodies never start with it, so envelopes are detected by the prefix.
+var envelopeMagic = []byte("GME")
+
+// oaepLabel binds the wrapped key to the envelope format.
+var oaepLabel = []byte("go-monitor envelope v2")
+
+// ErrInvalidEnvelope is returned when the data is not a valid envelope.
+var ErrInvalidEnvelope = errors.New("invalid envelope")
+
+// IsEnvelope reports whether data starts with the envelope header.
+func IsEnvelope(data []byte) bool {
+	return len(data) > len(envelopeMagic) && string(data[:len(envelopeMagic)]) == string(envelopeMagic)
+}
+
+// Seal encrypts data into a V2 envelope.
+//
+// A random AES-256 key is wrapped with RSA-OAEP (SHA-256), the data is encrypted with AES-256-GCM with a random nonce.
+// The envelope layout is:
+//
+//	"GME" | version (1 byte) | wrapped key length (uint16 BE) | wrapped key | nonce | ciphertext with tag
+//
+// The header is authenticated as additional data, so the version can't be changed.
+func Seal(publicKey *rsa.PublicKey, data []byte) ([]byte, error) {
+	sessionKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, sessionKey); err != nil {
+		return nil, err
+	}
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, sessionKey, oaepLabel)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(envelopeMagic)+3+len(wrappedKey)+gcm.NonceSize())
+	header = append(header, envelopeMagic...)
+	header = append(header, V2)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrappedKey)))
+	header = append(header, wrappedKey...)
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	header = append(header, nonce...)
+	return gcm.Seal(header, nonce, data, header), nil
+}
+
+// Open decrypts the envelope made by Seal and checks its integrity.
+func Open(privateKey *rsa.PrivateKey, envelope []byte) ([]byte, error) {
+	if !IsEnvelope(envelope) {
+		return nil, ErrInvalidEnvelope
+	}
+	rest := envelope[len(envelopeMagic):]
+	if version := rest[0]; version != V2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidEnvelope, version)
+	}
+	rest = rest[1:]
+	if len(rest) < 2 {
+		return nil, ErrInvalidEnvelope
+	}
+	keyLen := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < keyLen {
+		return nil, ErrInvalidEnvelope
+	}
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, rest[:keyLen], oaepLabel)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEnvelope, err)
+	}
+	rest = rest[keyLen:]
+	gcm, err := newGCM(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < gcm.NonceSize() {
+		return nil, ErrInvalidEnvelope
+	}
+	nonce, ciphertext := rest[:gcm.NonceSize()], rest[gcm.NonceSize():]
+	header := envelope[:len(envelope)-len(ciphertext)]
+	data, err := gcm.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEnvelope, err)
+	}
+	return data, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,107 @@
+package asymcrypto
+
+import (
+	"errors"
+	"fmt"
+)
+
+// KeyIDHeader is the header with the KeyID of the public key used to encrypt the request body.
+const KeyIDHeader = "X-Key-ID"
+
+// ErrUnknownKey is returned when the keyring has no key with the requested ID.
+var ErrUnknownKey = errors.New("unknown key id")
+
+// Keyring holds several private keys selected by their KeyID.
+//
+// Keys are rotated without downtime: the server loads both the old and the new key,
+// agents switch to the new public key, then the old key is removed from the server.
+type Keyring struct {
+	ids  []string
+	keys map[string]*PrivateKey
+}
+
+// NewKeyring returns a keyring with the keys. The order of keys is the order of attempts for requests without a key ID.
+func NewKeyring(keys ...*PrivateKey) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]*PrivateKey, len(keys))}
+	for _, key := range keys {
+		id, err := KeyID(&key.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := k.keys[id]; ok {
+			continue
+		}
+		k.ids = append(k.ids, id)
+		k.keys[id] = key
+	}
+	return k, nil
+}
+
+// LoadKeyring reads RSA private keys from the files.
+func LoadKeyring(paths ...string) (*Keyring, error) {
+	keys := make([]*PrivateKey, 0, len(paths))
+	for _, path := range paths {
+		key, err := GetPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return NewKeyring(keys...)
+}
+
+// IDs returns the key IDs in the order of the keys.
+func (k *Keyring) IDs() []string {
+	return append([]string(nil), k.ids...)
+}
+
+// candidates returns the key with the id, or all keys if the id is empty.
+func (k *Keyring) candidates(id string) ([]*PrivateKey, error) {
+	if id != "" {
+		key, ok := k.keys[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+		}
+		return []*PrivateKey{key}, nil
+	}
+	if len(k.ids) == 0 {
+		return nil, ErrUnknownKey
+	}
+	keys := make([]*PrivateKey, len(k.ids))
+	for i, id := range k.ids {
+		keys[i] = k.keys[id]
+	}
+	return keys, nil
+}
+
+// Open decrypts the envelope with the key with the id.
+// If the id is empty, each key is tried in turn.
+func (k *Keyring) Open(id string, envelope []byte) ([]byte, error) {
+	keys, err := k.candidates(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		var data []byte
+		if data, err = Open(key, envelope); err == nil {
+			return data, nil
+		}
+	}
+	return nil, err
+}
+
+// Decrypt decrypts the V1 data with the key with the id.
+// If the id is empty, each key is tried in turn.
+func (k *Keyring) Decrypt(id string, encryptedSessionKey []byte, encryptedData []byte) ([]byte, error) {
+	keys, err := k.candidates(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		var data []byte
+		if data, err = Decrypt(key, encryptedSessionKey, encryptedData); err == nil {
+			return data, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,233 @@
+package asymcrypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Ошибки разбора и генерации ключей.
+var (
+	ErrNoPEM          = errors.New("no PEM block found")
+	ErrNotRSA         = errors.New("key is not RSA")
+	ErrUnsupportedKey = errors.New("unsupported key")
+	ErrKeyMismatch    = errors.New("private and public keys do not match")
+	ErrNotEd25519     = errors.New("key is not Ed25519")
+)
+
+// KeyType is the algorithm of a generated key.
+type KeyType string
+
+// Поддерживаемые алгоритмы ключей.
+const (
+	// RSA keys are used to encrypt request bodies.
+	RSA KeyType = "rsa"
+	// EC keys are ECDSA keys on a NIST curve selected by the size: 256, 384 or 521 bits.
+	EC KeyType = "ec"
+	// Ed25519 keys are used by agents to sign requests, the size is ignored.
+	Ed25519 KeyType = "ed25519"
+)
+
+// Format is the encoding of a key in a PEM block.
+type Format string
+
+// Поддерживаемые форматы ключей.
+const (
+	// PKCS1 is "RSA PRIVATE KEY" or "RSA PUBLIC KEY", RSA only.
+	PKCS1 Format = "pkcs1"
+	// PKCS8 is "PRIVATE KEY" of any type.
+	PKCS8 Format = "pkcs8"
+	// SEC1 is "EC PRIVATE KEY", EC only.
+	SEC1 Format = "sec1"
+	// PKIX is "PUBLIC KEY" of any type.
+	PKIX Format = "pkix"
+)
+
+func decodePEM(data []byte) (*pem.Block, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNoPEM
+	}
+	return block, nil
+}
+
+// ParsePrivateKey parses a PKCS#1, PKCS#8 or SEC1 private key from PEM data.
+//
+// The format is detected by the content, so a wrong PEM type is tolerated.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, err := decodePEM(data)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKey, block.Type)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+	return signer, nil
+}
+
+// ParsePublicKey parses a PKCS#1 or PKIX public key from PEM data.
+//
+// The format is detected by the content, so a wrong PEM type is tolerated.
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, err := decodePEM(data)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKey, block.Type)
+	}
+	return key, nil
+}
+
+// GenerateKey generates a private key of the type.
+// The size is the modulus length for RSA and the curve size for EC.
+func GenerateKey(keyType KeyType, size int) (crypto.Signer, error) {
+	switch keyType {
+	case RSA:
+		if size < 2048 {
+			return nil, fmt.Errorf("%w: RSA key size %d is less than 2048", ErrUnsupportedKey, size)
+		}
+		return rsa.GenerateKey(rand.Reader, size)
+	case EC:
+		var curve elliptic.Curve
+		switch size {
+		case 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w: EC curve size %d", ErrUnsupportedKey, size)
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case Ed25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("%w: key type %q", ErrUnsupportedKey, keyType)
+	}
+}
+
+// MarshalPrivateKey encodes the private key to PEM in the format.
+func MarshalPrivateKey(key crypto.Signer, format Format) ([]byte, error) {
+	var (
+		block = &pem.Block{}
+		err   error
+	)
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if format == PKCS1 {
+			block.Type, block.Bytes = "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(k)
+			return pem.EncodeToMemory(block), nil
+		}
+	case *ecdsa.PrivateKey:
+		if format == SEC1 {
+			block.Type = "EC PRIVATE KEY"
+			if block.Bytes, err = x509.MarshalECPrivateKey(k); err != nil {
+				return nil, err
+			}
+			return pem.EncodeToMemory(block), nil
+		}
+	}
+	if format != PKCS8 {
+		return nil, fmt.Errorf("%w: %T in %s format", ErrUnsupportedKey, key, format)
+	}
+	block.Type = "PRIVATE KEY"
+	if block.Bytes, err = x509.MarshalPKCS8PrivateKey(key); err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// MarshalPublicKey encodes the public key to PEM in the format.
+func MarshalPublicKey(key crypto.PublicKey, format Format) ([]byte, error) {
+	switch format {
+	case PKCS1:
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T in %s format", ErrUnsupportedKey, key, format)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(k)}), nil
+	case PKIX:
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+	default:
+		return nil, fmt.Errorf("%w: public key in %s format", ErrUnsupportedKey, format)
+	}
+}
+
+// Fingerprint returns the hex SHA-256 of the PKIX encoding of the public key.
+// It doesn't depend on the file format of the key.
+func Fingerprint(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// KeyID returns the short identifier of the public key sent in the X-Key-ID header.
+// It is the first 16 hex digits of the fingerprint.
+func KeyID(key crypto.PublicKey) (string, error) {
+	fp, err := Fingerprint(key)
+	if err != nil {
+		return "", err
+	}
+	return fp[:16], nil
+}
+
+// CheckPair checks that the public key belongs to the private key.
+func CheckPair(private crypto.Signer, public crypto.PublicKey) error {
+	pub, ok := private.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(public) {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+// GetSigningKey returns the Ed25519 private key in PKCS#8 format from the given path
+func GetSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotEd25519)
+	}
+	return edKey, nil
+}
@@ -0,0 +1,143 @@
+package asymcrypto
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMarshalParse(t *testing.T) {
+	rsaKey, err := GenerateKey(RSA, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := GenerateKey(EC, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		key        crypto.Signer
+		format     Format
+		pubFormats []Format
+	}{
+		{"rsa pkcs1", rsaKey, PKCS1, []Format{PKCS1, PKIX}},
+		{"rsa pkcs8", rsaKey, PKCS8, []Format{PKIX}},
+		{"ec sec1", ecKey, SEC1, []Format{PKIX}},
+		{"ec pkcs8", ecKey, PKCS8, []Format{PKIX}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := tt.key
+			data, err := MarshalPrivateKey(signer, tt.format)
+			if err != nil {
+				t.Fatalf("MarshalPrivateKey() error = %v", err)
+			}
+			got, err := ParsePrivateKey(data)
+			if err != nil {
+				t.Fatalf("ParsePrivateKey() error = %v", err)
+			}
+			for _, f := range tt.pubFormats {
+				pubData, err := MarshalPublicKey(signer.Public(), f)
+				if err != nil {
+					t.Fatalf("MarshalPublicKey(%s) error = %v", f, err)
+				}
+				pub, err := ParsePublicKey(pubData)
+				if err != nil {
+					t.Fatalf("ParsePublicKey(%s) error = %v", f, err)
+				}
+				if err = CheckPair(got, pub); err != nil {
+					t.Errorf("CheckPair(%s) error = %v", f, err)
+				}
+			}
+		})
+	}
+
+	if _, err = MarshalPrivateKey(ecKey, PKCS1); !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("MarshalPrivateKey(ec, pkcs1) error = %v, want %v", err, ErrUnsupportedKey)
+	}
+	if err = CheckPair(rsaKey, ecKey.Public()); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("CheckPair() error = %v, want %v", err, ErrKeyMismatch)
+	}
+	if _, err = GenerateKey(EC, 128); !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("GenerateKey(ec, 128) error = %v, want %v", err, ErrUnsupportedKey)
+	}
+}
+
+func TestGetPrivateKey_errors(t *testing.T) {
+	dir := t.TempDir()
+	noPEM := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(noPEM, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetPrivateKey(noPEM); !errors.Is(err, ErrNoPEM) {
+		t.Errorf("GetPrivateKey() error = %v, want %v", err, ErrNoPEM)
+	}
+	if _, err := GetPublicKey(noPEM); !errors.Is(err, ErrNoPEM) {
+		t.Errorf("GetPublicKey() error = %v, want %v", err, ErrNoPEM)
+	}
+
+	ecKey, err := GenerateKey(EC, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MarshalPrivateKey(ecKey, PKCS8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPath := filepath.Join(dir, "ec.pem")
+	if err = os.WriteFile(ecPath, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetPrivateKey(ecPath); !errors.Is(err, ErrNotRSA) {
+		t.Errorf("GetPrivateKey() error = %v, want %v", err, ErrNotRSA)
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	oldKey, err := GetPrivateKey(filepath.Join(path, "private.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := GenerateKey(RSA, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey := signer.(*rsa.PrivateKey)
+	k, err := NewKeyring(oldKey, newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := k.IDs()
+	if len(ids) != 2 {
+		t.Fatalf("IDs() = %v, want 2 keys", ids)
+	}
+	fp, err := Fingerprint(&newKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fp) != 64 || ids[1] != fp[:16] {
+		t.Errorf("Fingerprint() = %v, IDs() = %v", fp, ids)
+	}
+
+	msg := []byte("Hello world!")
+	envelope, err := Seal(&newKey.PublicKey, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"", ids[1]} {
+		if got, err := k.Open(id, envelope); err != nil || string(got) != string(msg) {
+			t.Errorf("Open(%q) = %s, %v", id, got, err)
+		}
+	}
+	if _, err = k.Open(ids[0], envelope); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Errorf("Open() with wrong key error = %v, want %v", err, ErrInvalidEnvelope)
+	}
+	if _, err = k.Open("unknown", envelope); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Open() with unknown key error = %v, want %v", err, ErrUnknownKey)
+	}
+}
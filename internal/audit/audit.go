@@ -0,0 +1,85 @@
+// Package audit records administrative operations on the metrics storage.
+package audit
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Transports of audited requests.
+const (
+	TransportHTTP = "http"
+	TransportGRPC = "grpc"
+)
+
+// Entry describes a single administrative operation.
+type Entry struct {
+	// Actor is the address of the client that requested the operation.
+	Actor string
+	// Transport is TransportHTTP or TransportGRPC.
+	Transport string
+	// Op is the name of the operation, e.g. "delete".
+	Op string
+	// Args are the operation arguments as key-value pairs.
+	Args []any
+	// Affected is the number of affected metrics.
+	Affected int
+	// Err is the operation error, nil on success.
+	Err error
+}
+
+// Logger writes audit entries as JSON lines.
+//
+// A nil Logger discards entries.
+type Logger struct {
+	log    *slog.Logger
+	closer io.Closer
+}
+
+// New returns a Logger writing to w.
+func New(w io.Writer) *Logger {
+	return &Logger{log: slog.New(slog.NewJSONHandler(w, nil))}
+}
+
+// Open returns a Logger appending to the file at path.
+//
+// The file is created if it does not exist.
+func Open(path string) (*Logger, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l := New(f)
+	l.closer = f
+	return l, nil
+}
+
+// Record writes the entry to the audit log.
+func (l *Logger) Record(ctx context.Context, e Entry) {
+	if l == nil {
+		return
+	}
+	attrs := []any{
+		slog.String("actor", e.Actor),
+		slog.String("transport", e.Transport),
+		slog.String("op", e.Op),
+		slog.Group("args", e.Args...),
+		slog.Int("affected", e.Affected),
+	}
+	if e.Err != nil {
+		attrs = append(attrs, slog.String("error", e.Err.Error()))
+		l.log.WarnContext(ctx, "audit", attrs...)
+		return
+	}
+	l.log.InfoContext(ctx, "audit", attrs...)
+}
+
+// Close closes the underlying file if the Logger was created by Open.
+func (l *Logger) Close() error {
+	if l == nil || l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
@@ -0,0 +1,39 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestLogger_Record(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf)
+	l.Record(context.Background(), Entry{
+		Actor:     "127.0.0.1",
+		Transport: TransportHTTP,
+		Op:        "rename",
+		Args:      []any{"old", "Alloc", "new", "HeapAlloc"},
+		Err:       errors.New("metric not found"),
+	})
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("audit line is not JSON: %v", err)
+	}
+	if got["actor"] != "127.0.0.1" || got["transport"] != "http" || got["op"] != "rename" || got["level"] != "WARN" {
+		t.Errorf("unexpected entry %v", got)
+	}
+	if got["error"] != "metric not found" {
+		t.Errorf("error = %v, want %v", got["error"], "metric not found")
+	}
+	args, _ := got["args"].(map[string]any)
+	if args["old"] != "Alloc" || args["new"] != "HeapAlloc" {
+		t.Errorf("args = %v", got["args"])
+	}
+
+	var nilLogger *Logger
+	nilLogger.Record(context.Background(), Entry{Op: "delete"})
+}
@@ -0,0 +1,194 @@
+// Package auth authenticates API tokens and checks their scopes.
+//
+// Tokens are loaded from a JSON file which stores only SHA-256 hashes of the secrets:
+//
+//	{"tokens": [
+//		{"name": "agent-1", "hash": "sha256:<hex>", "scopes": ["write"]},
+//		{"name": "grafana", "hash": "sha256:<hex>", "scopes": ["read"]}
+//	]}
+//
+// The hash of a token can be computed with HashToken or with `printf %s <token> | sha256sum`.
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Scope is a permission granted to a token.
+type Scope string
+
+// Token scopes.
+const (
+	// ScopeRead allows reading metric values.
+	ScopeRead Scope = "read"
+	// ScopeWrite allows sending metrics, it is granted to agents.
+	ScopeWrite Scope = "write"
+	// ScopeAdmin allows everything including the admin API.
+	ScopeAdmin Scope = "admin"
+)
+
+const hashPrefix = "sha256:"
+
+// Errors returned by Store.Authenticate.
+var (
+	ErrNoToken      = errors.New("no token")
+	ErrInvalidToken = errors.New("invalid token")
+	ErrForbidden    = errors.New("token scope not allowed")
+)
+
+// Identity is an authenticated token.
+type Identity struct {
+	Name   string
+	Scopes []Scope
+}
+
+// Allows reports whether the identity has the scope. The admin scope allows everything.
+func (id Identity) Allows(scope Scope) bool {
+	for _, s := range id.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenEntry struct {
+	Name   string  `json:"name"`
+	Hash   string  `json:"hash"`
+	Scopes []Scope `json:"scopes"`
+}
+
+type tokensFile struct {
+	Tokens []tokenEntry `json:"tokens"`
+}
+
+// HashToken returns the hash of the token secret as stored in the tokens file.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// Store holds tokens loaded from a file.
+//
+// It is safe for concurrent use, the tokens may be reloaded while requests are authenticated.
+type Store struct {
+	path    string
+	tokens  atomic.Pointer[map[string]Identity]
+	modTime time.Time
+	size    int64
+}
+
+// Load reads the tokens file.
+func Load(path string) (*Store, error) {
+	s := &Store{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the tokens file again.
+//
+// If the file is invalid, the previously loaded tokens are kept.
+func (s *Store) Reload() error {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	tokens, err := parse(data)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", s.path, err)
+	}
+	s.tokens.Store(&tokens)
+	s.modTime, s.size = fi.ModTime(), fi.Size()
+	return nil
+}
+
+func parse(data []byte) (map[string]Identity, error) {
+	var f tokensFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	tokens := make(map[string]Identity, len(f.Tokens))
+	for i, t := range f.Tokens {
+		hash, ok := strings.CutPrefix(t.Hash, hashPrefix)
+		if !ok || len(hash) != 2*sha256.Size {
+			return nil, fmt.Errorf("token %d %q: hash must be %s followed by 64 hex digits", i, t.Name, hashPrefix)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("token %d %q: %w", i, t.Name, err)
+		}
+		for _, sc := range t.Scopes {
+			if sc != ScopeRead && sc != ScopeWrite && sc != ScopeAdmin {
+				return nil, fmt.Errorf("token %d %q: unknown scope %q", i, t.Name, sc)
+			}
+		}
+		tokens[hashPrefix+strings.ToLower(hash)] = Identity{Name: t.Name, Scopes: t.Scopes}
+	}
+	return tokens, nil
+}
+
+// Watch reloads the tokens file every interval if it was modified, until done is closed.
+func (s *Store) Watch(done <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fi, err := os.Stat(s.path)
+			if err != nil {
+				slog.Error("stat tokens file error", "path", s.path, "error", err)
+				continue
+			}
+			if fi.ModTime().Equal(s.modTime) && fi.Size() == s.size {
+				continue
+			}
+			if err := s.Reload(); err != nil {
+				// Не повторяем попытку, пока файл не изменится снова.
+				s.modTime, s.size = fi.ModTime(), fi.Size()
+				slog.Error("reload tokens file error", "path", s.path, "error", err)
+				continue
+			}
+			slog.Info("Tokens reloaded", "path", s.path)
+		case <-done:
+			return
+		}
+	}
+}
+
+// Authenticate returns the identity of the token and checks that it has the scope.
+func (s *Store) Authenticate(token string, scope Scope) (Identity, error) {
+	if token == "" {
+		return Identity{}, ErrNoToken
+	}
+	id, ok := (*s.tokens.Load())[HashToken(token)]
+	if !ok {
+		return Identity{}, ErrInvalidToken
+	}
+	if !id.Allows(scope) {
+		return id, fmt.Errorf("%w: %s requires %q", ErrForbidden, id.Name, scope)
+	}
+	return id, nil
+}
+
+// BearerToken extracts the token from the value of the Authorization header.
+func BearerToken(header string) string {
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return ""
+	}
+	return token
+}
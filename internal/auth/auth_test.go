@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTokens(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStore_Authenticate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	writeTokens(t, path, `{"tokens": [
+		{"name": "agent", "hash": "`+HashToken("agent-secret")+`", "scopes": ["write"]},
+		{"name": "dashboard", "hash": "`+HashToken("dash-secret")+`", "scopes": ["read"]},
+		{"name": "root", "hash": "`+HashToken("root-secret")+`", "scopes": ["admin"]}
+	]}`)
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		scope Scope
+		want  error
+	}{
+		{"agent writes", "agent-secret", ScopeWrite, nil},
+		{"agent reads", "agent-secret", ScopeRead, ErrForbidden},
+		{"dashboard reads", "dash-secret", ScopeRead, nil},
+		{"dashboard writes", "dash-secret", ScopeWrite, ErrForbidden},
+		{"admin writes", "root-secret", ScopeWrite, nil},
+		{"admin administers", "root-secret", ScopeAdmin, nil},
+		{"agent administers", "agent-secret", ScopeAdmin, ErrForbidden},
+		{"no token", "", ScopeRead, ErrNoToken},
+		{"unknown token", "guess", ScopeRead, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Authenticate(tt.token, tt.scope); !errors.Is(err, tt.want) {
+				t.Errorf("Authenticate() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStore_Watch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	writeTokens(t, path, `{"tokens": [{"name": "agent", "hash": "`+HashToken("old")+`", "scopes": ["write"]}]}`)
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go s.Watch(done, 10*time.Millisecond)
+
+	// Некорректный файл не заменяет загруженные токены.
+	writeTokens(t, path, `{"tokens": [{"name": "agent", "hash": "md5:123", "scopes": ["write"]}]}`)
+	time.Sleep(50 * time.Millisecond)
+	if _, err := s.Authenticate("old", ScopeWrite); err != nil {
+		t.Errorf("old token after invalid reload: %v", err)
+	}
+
+	writeTokens(t, path, `{"tokens": [{"name": "agent", "hash": "`+HashToken("new")+`", "scopes": ["write"]}]}`)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, err := s.Authenticate("new", ScopeWrite)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("new token was not reloaded: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := s.Authenticate("old", ScopeWrite); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("old token after reload: error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestLoad_invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	writeTokens(t, path, `{"tokens": [{"name": "agent", "hash": "`+HashToken("x")+`", "scopes": ["root"]}]}`)
+	if _, err := Load(path); err == nil {
+		t.Error("Load() must fail on unknown scope")
+	}
+}
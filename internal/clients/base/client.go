@@ -1,7 +1,13 @@
 package base
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"time"
+
 	asc "github.com/xoxloviwan/go-monitor/internal/asymcrypto"
+	"github.com/xoxloviwan/go-monitor/internal/signing"
 )
 
 // Client represents a client connection to the server.
@@ -10,4 +16,56 @@ type Client struct {
 	Key       string
 	LocalIP   string
 	PublicKey *asc.PublicKey
+	// Token is sent as "Authorization: Bearer <token>", empty token is not sent.
+	Token string
+	// TLS enables TLS with the configuration, nil uses plain connections.
+	TLS *tls.Config
+	// AgentID identifies the agent in the server registry, it is sent with signatures made by SignKey.
+	AgentID string
+	// SignKey signs requests instead of the shared Key.
+	SignKey ed25519.PrivateKey
+	// DialTimeout limits establishing a connection to the server, zero means no limit.
+	DialTimeout time.Duration
+	// RequestTimeout limits each attempt to send a batch, zero means no limit.
+	RequestTimeout time.Duration
+	// Timeout limits sending a batch with all retries, zero means no limit.
+	Timeout time.Duration
+	// Retry is the policy of retrying failed batches, the zero policy makes no retries.
+	Retry RetryPolicy
+	// Breaker stops sending while the server is unavailable, nil disables it.
+	// It is shared by all copies of the client.
+	Breaker *Breaker
+	// MaxIdleConns is the number of idle HTTP connections kept to the server, usually the number of workers.
+	// Zero keeps http.DefaultMaxIdleConnsPerHost connections.
+	MaxIdleConns int
+}
+
+// WithTimeout returns the context limited by the timeout, zero timeout returns the context as is.
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// SignHeaders returns the headers signing the body with a new timestamp and nonce.
+//
+// The body is signed with SignKey if it is set, otherwise with the shared Key.
+// Without keys it returns nil.
+func (c *Client) SignHeaders(body []byte) map[string]string {
+	if c.SignKey == nil && c.Key == "" {
+		return nil
+	}
+	timestamp, nonce := signing.Timestamp(time.Now()), signing.NewNonce()
+	headers := map[string]string{
+		signing.TimestampHeader: timestamp,
+		signing.NonceHeader:     nonce,
+	}
+	if c.SignKey != nil {
+		headers[signing.AgentHeader] = c.AgentID
+		headers[signing.SignatureHeader] = signing.SignEd25519(c.SignKey, timestamp, nonce, body)
+	} else {
+		headers[signing.HashHeader] = signing.SignHex([]byte(c.Key), timestamp, nonce, body)
+	}
+	return headers
 }
@@ -0,0 +1,194 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RetryPolicy configures retrying of batches which failed with a retryable error.
+//
+// The delay before the retry n (from 0) is BaseDelay*2^n limited by MaxDelay, a random part of it is cut by Jitter,
+// so that agents restarted together do not retry at the same moments. The delay requested by the server
+// with Retry-After is used as is. The zero RetryPolicy makes no retries.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt, zero disables retries.
+	MaxRetries int
+	// BaseDelay is the delay before the first retry.
+	BaseDelay time.Duration
+	// MaxDelay limits the delay between retries, zero means no limit.
+	MaxDelay time.Duration
+	// Jitter is the part of the delay from 0 to 1 which is randomized.
+	Jitter float64
+}
+
+// Backoff returns the delay before the retry n. The rnd returns a random number in [0, 1).
+func (p RetryPolicy) Backoff(n int, rnd func() float64) time.Duration {
+	d := p.BaseDelay
+	for i := 0; i < n && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	jitter := min(max(p.Jitter, 0), 1)
+	return d - time.Duration(jitter*rnd()*float64(d))
+}
+
+// RetryableError is an error of an attempt which may succeed if it is retried.
+type RetryableError struct {
+	Err error
+	// After is the delay requested by the server, zero uses the backoff of the policy.
+	After time.Duration
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable marks the error as retryable after the delay, zero delay uses the backoff.
+func Retryable(err error, after time.Duration) error {
+	return &RetryableError{Err: err, After: after}
+}
+
+// StatusError is returned for an unexpected HTTP status code of the response.
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", e.Code)
+}
+
+// RetryableStatus reports whether the request failed with the HTTP status code may succeed later.
+func RetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Retry calls attempt until it succeeds, fails with an error which is not a RetryableError,
+// the retries of the policy are exhausted or the ctx is done.
+//
+// The breaker, if not nil, is checked before each attempt and records its result. The worker is used for logging.
+func (p RetryPolicy) Retry(ctx context.Context, breaker *Breaker, worker int, attempt func(ctx context.Context) error) error {
+	var last error
+	for retry := 0; ; retry++ {
+		if err := breaker.Allow(); err != nil {
+			return errors.Join(err, last)
+		}
+		err := attempt(ctx)
+		last = err
+		var re *RetryableError
+		if !errors.As(err, &re) {
+			// Сервер ответил, пусть и ошибкой, значит он доступен.
+			breaker.Success()
+			return err
+		}
+		breaker.Failure()
+		if retry >= p.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+		after := re.After
+		if after == 0 {
+			after = p.Backoff(retry, rand.Float64)
+		}
+		slog.Warn("Retry attempt", "worker", worker, "error", err, "retry", retry+1, "after", after)
+		select {
+		case <-time.After(after):
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		}
+	}
+}
+
+// Temporary reports whether sending failed because the server is unavailable, so the batch may be sent later.
+func Temporary(err error) bool {
+	var re *RetryableError
+	return errors.As(err, &re) || errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// ErrCircuitOpen is returned by Breaker.Allow while the server is considered unavailable.
+var ErrCircuitOpen = errors.New("circuit breaker is open, server is unavailable")
+
+// Breaker is a circuit breaker which stops sending to a server after consecutive failures.
+//
+// After the threshold of failures in a row the breaker opens and rejects attempts for the cooldown.
+// Then it lets one attempt through: its success closes the breaker, its failure opens it again.
+// A nil *Breaker allows every attempt. It is safe for concurrent use by the workers of the agent.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewBreaker returns a breaker opening after threshold consecutive failures for the cooldown.
+// Zero threshold returns nil which disables the breaker.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &Breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// Allow returns ErrCircuitOpen if the attempt must not be made.
+func (b *Breaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	}
+	// Пробную попытку после паузы делает только один работник.
+	if b.probing || b.now().Sub(b.openedAt) < b.cooldown {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// Success records a successful attempt and closes the breaker.
+func (b *Breaker) Success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures, b.probing = 0, false
+}
+
+// Failure records a failed attempt, the breaker opens on the threshold failure.
+func (b *Breaker) Failure() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.failures >= b.threshold {
+		if b.failures == b.threshold {
+			slog.Warn("Circuit breaker opened", "failures", b.failures, "cooldown", b.cooldown)
+		}
+		b.openedAt = b.now()
+	}
+}
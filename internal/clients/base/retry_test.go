@@ -0,0 +1,150 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second, Jitter: 0.5}
+	tests := []struct {
+		name string
+		n    int
+		rnd  float64
+		want time.Duration
+	}{
+		{"first", 0, 0, time.Second},
+		{"second", 1, 0, 2 * time.Second},
+		{"third", 2, 0, 4 * time.Second},
+		{"limited", 3, 0, 5 * time.Second},
+		{"far retry", 100, 0, 5 * time.Second},
+		{"jitter", 1, 0.75, 1250 * time.Millisecond},
+		{"half jitter", 2, 0.5, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := p.Backoff(tt.n, func() float64 { return tt.rnd })
+			if got < tt.want-time.Microsecond || got > tt.want+time.Microsecond {
+				t.Errorf("Backoff(%d) = %v, want %v", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Retry(t *testing.T) {
+	errDown := errors.New("connection refused")
+	errBad := errors.New("bad request")
+	p := RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond}
+	tests := []struct {
+		name         string
+		results      []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{"success", []error{nil}, 1, nil},
+		{"success after retries", []error{Retryable(errDown, 0), Retryable(errDown, time.Millisecond), nil}, 3, nil},
+		{"not retryable", []error{errBad}, 1, errBad},
+		{"retries exhausted", []error{Retryable(errDown, 0), Retryable(errDown, 0), Retryable(errDown, 0), Retryable(errDown, 0)}, 4, errDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := p.Retry(context.Background(), nil, 1, func(context.Context) error {
+				attempts++
+				return tt.results[attempts-1]
+			})
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("Retry() error = %v, want %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Retry_context(t *testing.T) {
+	p := RetryPolicy{MaxRetries: 10, BaseDelay: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	attempts := 0
+	err := p.Retry(ctx, nil, 1, func(context.Context) error {
+		attempts++
+		return Retryable(errors.New("unavailable"), 0)
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Retry() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	b := NewBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.Failure()
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() after one failure error = %v", err)
+	}
+	b.Failure()
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow() after threshold error = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() of probe after cooldown error = %v", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Allow() during probe error = %v, want %v", err, ErrCircuitOpen)
+	}
+	b.Failure()
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Allow() after failed probe error = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() of second probe error = %v", err)
+	}
+	b.Success()
+	if err := b.Allow(); err != nil {
+		t.Errorf("Allow() after success error = %v", err)
+	}
+
+	if NewBreaker(0, time.Minute) != nil {
+		t.Error("NewBreaker(0) is not nil")
+	}
+	var disabled *Breaker
+	disabled.Failure()
+	if err := disabled.Allow(); err != nil {
+		t.Errorf("nil Allow() error = %v", err)
+	}
+}
+
+func TestTemporary(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"retryable", Retryable(errors.New("connection refused"), 0), true},
+		{"retryable after timeout", errors.Join(Retryable(errors.New("unavailable"), 0), context.DeadlineExceeded), true},
+		{"circuit open", errors.Join(ErrCircuitOpen, nil), true},
+		{"deadline", context.DeadlineExceeded, true},
+		{"bad request", &StatusError{Code: 400}, false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Temporary(tt.err); got != tt.want {
+				t.Errorf("Temporary() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
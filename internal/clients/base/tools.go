@@ -8,6 +8,9 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net"
+	"net/http"
+	"strconv"
+	"time"
 )
 
 // GetHash calculates an HMAC-SHA256 hash of the provided data using the given secret key.
@@ -57,3 +60,23 @@ func GetIP() (net.IP, error) {
 
 	return localAddr.IP, nil
 }
+
+// RetryAfter parses the Retry-After header value given in seconds or as an HTTP date.
+//
+// It returns false if the value is empty or invalid.
+func RetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if sec, err := strconv.Atoi(value); err == nil {
+		if sec < 0 {
+			return 0, false
+		}
+		return time.Duration(sec) * time.Second, true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(0, t.Sub(now)), true
+}
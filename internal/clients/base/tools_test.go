@@ -2,6 +2,7 @@ package base
 
 import (
 	"testing"
+	"time"
 )
 
 func Test_GetIP(t *testing.T) {
@@ -11,3 +12,28 @@ func Test_GetIP(t *testing.T) {
 		t.Errorf("getIP() error = %v, wantErr %v", err, nil)
 	}
 }
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		{"seconds", "3", 3 * time.Second, true},
+		{"http date", "Tue, 01 Oct 2024 12:00:05 GMT", 5 * time.Second, true},
+		{"past date", "Tue, 01 Oct 2024 11:00:00 GMT", 0, true},
+		{"empty", "", 0, false},
+		{"negative", "-1", 0, false},
+		{"invalid", "soon", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := RetryAfter(tt.value, now)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("RetryAfter() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
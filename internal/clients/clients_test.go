@@ -1,21 +1,17 @@
 package clients
 
 import (
-	"reflect"
 	"testing"
 
-	asc "github.com/xoxloviwan/go-monitor/internal/asymcrypto"
+	"github.com/xoxloviwan/go-monitor/internal/clients/base"
 	"github.com/xoxloviwan/go-monitor/internal/clients/grpc"
 	"github.com/xoxloviwan/go-monitor/internal/clients/http"
 )
 
 func TestNewSender(t *testing.T) {
 	type args struct {
-		grpcFlag  bool
-		addr      string
-		key       string
-		localIP   string
-		publicKey *asc.PublicKey
+		grpcFlag bool
+		cl       base.Client
 	}
 	tests := []struct {
 		name string
@@ -25,40 +21,43 @@ func TestNewSender(t *testing.T) {
 		{
 			name: "make http client",
 			args: args{
-				grpcFlag:  false,
-				addr:      "localhost:8080",
-				key:       "",
-				localIP:   "",
-				publicKey: nil,
-			},
-			want: &http.Client{
-				Addr:      "localhost:8080",
-				Key:       "",
-				LocalIP:   "",
-				PublicKey: nil,
+				grpcFlag: false,
+				cl: base.Client{
+					Addr:  "localhost:8080",
+					Token: "secret",
+				},
 			},
+			want: &http.Client{},
 		},
 		{
 			name: "make grpc client",
 			args: args{
-				grpcFlag:  true,
-				addr:      "localhost:8080",
-				key:       "",
-				localIP:   "",
-				publicKey: nil,
-			},
-			want: &grpc.Client{
-				Addr:      "localhost:8080",
-				Key:       "",
-				LocalIP:   "",
-				PublicKey: nil,
+				grpcFlag: true,
+				cl: base.Client{
+					Addr: "localhost:8080",
+				},
 			},
+			want: &grpc.Client{},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := NewSender(tt.args.grpcFlag, tt.args.addr, tt.args.key, tt.args.localIP, tt.args.publicKey); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("NewSender() = %v, want %v", got, tt.want)
+			got, err := NewSender(tt.args.grpcFlag, tt.args.cl)
+			if err != nil {
+				t.Fatalf("NewSender() error = %v", err)
+			}
+			defer got.Close()
+			switch got := got.(type) {
+			case *http.Client:
+				if _, ok := tt.want.(*http.Client); !ok || got.Client.Addr != tt.args.cl.Addr || got.Token != tt.args.cl.Token {
+					t.Errorf("NewSender() = %+v, want HTTP client with %+v", got.Client, tt.args.cl)
+				}
+			case *grpc.Client:
+				if _, ok := tt.want.(*grpc.Client); !ok || got.Client.Addr != tt.args.cl.Addr {
+					t.Errorf("NewSender() = %+v, want gRPC client with %+v", got.Client, tt.args.cl)
+				}
+			default:
+				t.Errorf("NewSender() = %T", got)
 			}
 		})
 	}
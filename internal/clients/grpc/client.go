@@ -3,62 +3,151 @@ package grpc
 import (
 	"context"
 	"log/slog"
+	"time"
 
+	asc "github.com/xoxloviwan/go-monitor/internal/asymcrypto"
 	"github.com/xoxloviwan/go-monitor/internal/clients/base"
 	mcv "github.com/xoxloviwan/go-monitor/internal/metrics_convert"
 	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
 	pb "github.com/xoxloviwan/go-monitor/internal/metrics_types/proto"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Keepalive pings of idle connections, the server permits them not more often than grpcservice.KeepaliveMinTime.
+const (
+	keepaliveTime    = 30 * time.Second
+	keepaliveTimeout = 10 * time.Second
 )
 
 // Client is a gRPC client that sends metrics to a server.
-// It embeds the base.Client type, which provides common client functionality.
-type Client base.Client
+//
+// It keeps one connection to the server for all batches, the connection is established on the first call
+// and reconnected when it breaks. The client should be closed at the end.
+type Client struct {
+	base.Client
+	conn    *grpc.ClientConn
+	metrics pb.MetricsServiceClient
+}
 
-// Send sends a list of metrics to the gRPC server using the default options.
-// The worker parameter is used for logging purposes.
-func (s *Client) Send(worker int, msgs api.MetricsList) (err error) {
-	return s.SendWithOpts(worker, msgs)
+// New returns a client with the configuration cl.
+// The opts parameter allows for customizing the gRPC connection, such as setting a dialer.
+func New(cl base.Client, opts ...grpc.DialOption) (*Client, error) {
+	creds := insecure.NewCredentials()
+	if cl.TLS != nil {
+		creds = credentials.NewTLS(cl.TLS)
+	}
+	opts = append(opts,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                keepaliveTime,
+			Timeout:             keepaliveTimeout,
+			PermitWithoutStream: true,
+		}),
+	)
+	if cl.DialTimeout > 0 {
+		opts = append(opts, grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           backoff.DefaultConfig,
+			MinConnectTimeout: cl.DialTimeout,
+		}))
+	}
+	conn, err := grpc.NewClient(cl.Addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Client: cl, conn: conn, metrics: pb.NewMetricsServiceClient(conn)}, nil
 }
 
-// SendWithOpts sends a list of metrics to the gRPC server using the provided dial options.
-// The worker parameter is used for logging purposes.
-// The opts parameter allows for customizing the gRPC connection, such as setting transport credentials.
-func (s *Client) SendWithOpts(worker int, msgs api.MetricsList, opts ...grpc.DialOption) (err error) {
+// Close closes the connection to the server.
+func (s *Client) Close() error {
+	return s.conn.Close()
+}
 
+// Send sends a list of metrics to the gRPC server.
+// The ctx parameter limits the call with retries, the worker parameter is used for logging purposes.
+func (s *Client) Send(ctx context.Context, worker int, msgs api.MetricsList) (err error) {
 	slog.Info("gRPC worker got task", "worker", worker)
-	// устанавливаем соединение с сервером
-	opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	conn, err := grpc.NewClient(s.Addr, opts...)
+	ctx, cancel := base.WithTimeout(ctx, s.Timeout)
+	defer cancel()
 
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-	// получаем переменную интерфейсного типа MetricsServiceClient,
-	// через которую будем отправлять сообщения
-	c := pb.NewMetricsServiceClient(conn)
 	md := metadata.New(map[string]string{
 		"X-Real-IP": s.LocalIP,
 	})
+	if s.Token != "" {
+		md.Set("authorization", "Bearer "+s.Token)
+	}
 	metrs := mcv.ConvMetrics(msgs)
-	if s.Key != "" {
-		msg := metrs.String()
-		sign, err := base.GetHash([]byte(msg), s.Key)
+	// Ключ пакета подписывается вместе с метриками, повторно отправленный пакет сервер не применит.
+	metrs.BatchId = api.OutgoingBatchID(ctx)
+	signed := msgs.Canonical(metrs.BatchId)
+	// Метрики вместе с ключом пакета шифруются и передаются в поле encrypted, подпись сервер проверяет после расшифровки.
+	if s.PublicKey != nil {
+		if metrs, err = encrypt(s.PublicKey, metrs); err != nil {
+			return err
+		}
+		keyID, err := asc.KeyID(s.PublicKey)
 		if err != nil {
 			return err
 		}
-		md.Set("HashSHA256", sign)
+		md.Set(asc.KeyIDHeader, keyID)
+	}
+	// Каждая попытка подписывается со своими временем и nonce, иначе сервер отклонит ее как повтор.
+	return s.Retry.Retry(ctx, s.Breaker, worker, func(ctx context.Context) error {
+		ctx, cancel := base.WithTimeout(ctx, s.RequestTimeout)
+		defer cancel()
+		callMD := md.Copy()
+		for k, v := range s.SignHeaders(signed) {
+			callMD.Set(k, v)
+		}
+		var trailer metadata.MD
+		MetricsResponse, err := s.metrics.AddMetrics(metadata.NewOutgoingContext(ctx, callMD), metrs,
+			grpc.UseCompressor(gzip.Name), grpc.Trailer(&trailer))
+		if err != nil {
+			return retryable(err, trailer)
+		}
+		slog.Info("gRPC worker got response", "worker", worker, "accepted", len(MetricsResponse.GetAccepted()))
+		// Отклоненные метрики не повторяются, но должны попасть в журнал.
+		for _, r := range MetricsResponse.GetRejected() {
+			slog.Warn("Metric rejected by server", "worker", worker,
+				"index", r.GetIndex(), "id", r.GetId(), "type", r.GetType(), "reason", r.GetReason())
+		}
+		return nil
+	})
+}
+
+// retryable marks the error of the call as retryable if the server is unavailable, overloaded or did not answer in time.
+// The delay is taken from the retry-after trailer.
+func retryable(err error, trailer metadata.MD) error {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded, codes.Aborted:
+		var after time.Duration
+		if v := trailer.Get("retry-after"); len(v) > 0 {
+			after, _ = base.RetryAfter(v[0], time.Now())
+		}
+		return base.Retryable(err, after)
+	}
+	return err
+}
+
+// encrypt seals the serialized metrics into the V2 envelope.
+// The envelope carries the wrapped session key, so no key is sent in metadata.
+func encrypt(key *asc.PublicKey, metrs *pb.Metrics) (*pb.Metrics, error) {
+	data, err := proto.Marshal(metrs)
+	if err != nil {
+		return nil, err
 	}
-	ctx := metadata.NewOutgoingContext(context.Background(), md)
-	MetricsResponse, err := c.AddMetrics(ctx, metrs, grpc.UseCompressor(gzip.Name))
+	envelope, err := asc.Seal(key, data)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	slog.Info("gRPC worker got response", "worker", worker, "response", MetricsResponse)
-	return nil
+	return &pb.Metrics{Encrypted: envelope}, nil
 }
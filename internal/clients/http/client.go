@@ -2,11 +2,12 @@ package http
 
 import (
 	"bytes"
-	"encoding/hex"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"time"
 
@@ -16,94 +17,142 @@ import (
 	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
 )
 
-// Client is a wrapper around base.Client that provides HTTP-specific functionality.
-type Client base.Client
+// idleConnTimeout is how long an idle connection stays in the pool.
+const idleConnTimeout = 90 * time.Second
+
+// Client sends metrics to the server over HTTP.
+//
+// It keeps a pool of connections to the server, so one Client should be used for all batches and closed at the end.
+type Client struct {
+	base.Client
+	http *http.Client
+	url  string
+}
+
+// New returns a client with the configuration cl.
+func New(cl base.Client) *Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   cl.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:     cl.TLS,
+		TLSHandshakeTimeout: cl.DialTimeout,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        cl.MaxIdleConns,
+		MaxIdleConnsPerHost: cl.MaxIdleConns,
+		IdleConnTimeout:     idleConnTimeout,
+	}
+	scheme := "http://"
+	if cl.TLS != nil {
+		scheme = "https://"
+	}
+	return &Client{
+		Client: cl,
+		http:   &http.Client{Transport: transport},
+		url:    scheme + cl.Addr + "/updates/",
+	}
+}
+
+// Close closes the idle connections to the server.
+func (s *Client) Close() error {
+	s.http.CloseIdleConnections()
+	return nil
+}
 
 // Send
 //
+// ctx - ограничивает отправку вместе с повторами
 // workerID - идентификатор потока
 // msgs - список метрик
-func (s *Client) Send(workerID int, msgs api.MetricsList) (err error) {
-	cl := &http.Client{}
-
-	url := "http://" + s.Addr + "/updates/"
+func (s *Client) Send(ctx context.Context, workerID int, msgs api.MetricsList) error {
+	ctx, cancel := base.WithTimeout(ctx, s.Timeout)
+	defer cancel()
 
-	var body []byte
-	body, err = easyjson.Marshal(&msgs)
+	body, err := easyjson.Marshal(&msgs)
 	if err != nil {
 		return err
 	}
-	var sessionKey []byte
+	// Подписываются метрики, а не тело запроса, чтобы подпись не зависела от шифрования и транспорта.
+	// Один и тот же ключ пакета во всех попытках, чтобы сервер не применил пакет дважды.
+	batchID := api.OutgoingBatchID(ctx)
+	signed := msgs.Canonical(batchID)
+	var keyID string
 	if s.PublicKey != nil {
-		var err error
-		sessionKey, body, err = asc.Encrypt(s.PublicKey, body)
+		body, err = asc.Seal(s.PublicKey, body)
 		if err != nil {
 			return err
 		}
-	}
-	var sign string
-	if s.Key != "" {
-		sign, err = base.GetHash(body, s.Key)
-		if err != nil {
+		if keyID, err = asc.KeyID(s.PublicKey); err != nil {
 			return err
 		}
 	}
-	var gzbody []byte
-	gzbody, err = base.CompressGzip(body)
+	gzbody, err := base.CompressGzip(body)
 	if err != nil {
 		return err
 	}
-	var req *http.Request
-	req, err = http.NewRequest("POST", url, bytes.NewBuffer(gzbody))
-	if err != nil {
-		return err
-	}
-	// net.IPAddr
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Content-Encoding", "gzip")
-	req.Header.Set("Accept-Encoding", "gzip")
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Content-Encoding", "gzip")
+	header.Set("Accept-Encoding", "gzip")
+	header.Set(api.BatchIDHeader, batchID)
 	if s.LocalIP != "" {
-		req.Header.Set("X-Real-IP", s.LocalIP)
+		header.Set("X-Real-IP", s.LocalIP)
 	}
-	if sessionKey != nil {
-		req.Header.Set("X-Key", hex.EncodeToString(sessionKey))
-	}
-
-	if s.Key != "" {
-		req.Header.Set("HashSHA256", sign)
+	if keyID != "" {
+		header.Set(asc.KeyIDHeader, keyID)
 	}
-
-	var response *http.Response
-	retry := 0
-	response, err = cl.Do(req)
-	if err == nil && response.StatusCode != http.StatusOK {
-		slog.Warn("Unexpected status code", "worker", workerID, "status_code", response.StatusCode)
+	if s.Token != "" {
+		header.Set("Authorization", "Bearer "+s.Token)
 	}
-	for err != nil && retry < 3 {
-		if response != nil {
-			response.Body.Close()
+	// Каждая попытка подписывается со своими временем и nonce, иначе сервер отклонит ее как повтор.
+	return s.Retry.Retry(ctx, s.Breaker, workerID, func(ctx context.Context) error {
+		h := header.Clone()
+		for k, v := range s.SignHeaders(signed) {
+			h.Set(k, v)
+		}
+		code, retryAfter, err := s.post(ctx, gzbody, h)
+		switch {
+		case err != nil:
+			// Ошибка соединения или таймаут попытки, пакет с тем же ключом можно отправить повторно.
+			return base.Retryable(err, 0)
+		case code == http.StatusOK:
+			return nil
+		case base.RetryableStatus(code):
+			after, _ := base.RetryAfter(retryAfter, time.Now())
+			if code == http.StatusTooManyRequests {
+				slog.Warn("Rate limited", "worker", workerID, "retry_after", after)
+			}
+			return base.Retryable(&base.StatusError{Code: code}, after)
+		default:
+			return &base.StatusError{Code: code}
 		}
-		after := (retry+1)*2 - 1
-		time.Sleep(time.Duration(after) * time.Second)
-		slog.Warn("Retry attempt", "worker", workerID, "error", err, "retry", retry+1)
-		response, err = cl.Do(req)
-		retry++
+	})
+}
+
+// post sends the body once and returns the status code and the Retry-After header of the response.
+func (s *Client) post(ctx context.Context, body []byte, header http.Header) (code int, retryAfter string, err error) {
+	ctx, cancel := base.WithTimeout(ctx, s.RequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
 	}
+	req.Header = header
+	response, err := s.http.Do(req)
 	if err != nil {
-		return err
+		return 0, "", err
 	}
-
 	defer func() {
 		if closeErr := response.Body.Close(); closeErr != nil {
 			closeErr = fmt.Errorf("could not close response body: %w", closeErr)
 			err = errors.Join(err, closeErr)
 		}
 	}()
-
-	_, err = io.Copy(io.Discard, response.Body)
-	if err != nil {
-		return err
+	// Тело ответа дочитывается, чтобы соединение вернулось в пул.
+	if _, err = io.Copy(io.Discard, response.Body); err != nil {
+		return 0, "", err
 	}
-	return nil
+	return response.StatusCode, response.Header.Get("Retry-After"), nil
 }
@@ -0,0 +1,197 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xoxloviwan/go-monitor/internal/clients/base"
+	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+	"github.com/xoxloviwan/go-monitor/internal/signing"
+)
+
+func testMetrics() api.MetricsList {
+	delta := int64(1)
+	return api.MetricsList{{ID: "PollCount", MType: api.CounterName, Delta: &delta}}
+}
+
+func TestClient_Send_reusesConnection(t *testing.T) {
+	var conns, requests atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	cl := New(base.Client{Addr: strings.TrimPrefix(srv.URL, "http://"), RequestTimeout: time.Second})
+	defer cl.Close()
+	for i := 0; i < 3; i++ {
+		if err := cl.Send(context.Background(), 1, testMetrics()); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("requests = %d, want 3", got)
+	}
+	if got := conns.Load(); got != 1 {
+		t.Errorf("connections = %d, want 1", got)
+	}
+}
+
+func TestClient_Send_batchID(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get(api.BatchIDHeader))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cl := New(base.Client{Addr: strings.TrimPrefix(srv.URL, "http://"), RequestTimeout: time.Second})
+	defer cl.Close()
+	for _, ctx := range []context.Context{api.WithOutgoingBatchID(context.Background(), "replayed"), context.Background()} {
+		if err := cl.Send(ctx, 1, testMetrics()); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	if len(got) != 2 || got[0] != "replayed" || got[1] == "" || got[1] == "replayed" {
+		t.Errorf("batch IDs = %q, want the ID from the context and a new one", got)
+	}
+}
+
+func TestClient_Send_timeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	tests := []struct {
+		name string
+		cl   base.Client
+		ctx  func() (context.Context, context.CancelFunc)
+	}{
+		{
+			name: "overall timeout",
+			cl:   base.Client{Addr: addr, Timeout: 100 * time.Millisecond},
+			ctx:  func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) },
+		},
+		{
+			name: "context deadline",
+			cl:   base.Client{Addr: addr, RequestTimeout: time.Minute},
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 100*time.Millisecond)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := New(tt.cl)
+			defer cl.Close()
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			start := time.Now()
+			err := cl.Send(ctx, 1, testMetrics())
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Send() error = %v, want %v", err, context.DeadlineExceeded)
+			}
+			if d := time.Since(start); d > 5*time.Second {
+				t.Errorf("Send() took %v", d)
+			}
+		})
+	}
+}
+
+func TestClient_Send_retry(t *testing.T) {
+	retry := base.RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+	tests := []struct {
+		name         string
+		codes        []int
+		wantRequests int32
+		wantCode     int
+	}{
+		{"ok", []int{http.StatusOK}, 1, 0},
+		{"unavailable then ok", []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK}, 3, 0},
+		{"rate limited then ok", []int{http.StatusTooManyRequests, http.StatusOK}, 2, 0},
+		{"bad request is not retried", []int{http.StatusBadRequest}, 1, http.StatusBadRequest},
+		{"retries exhausted", []int{500, 500, 500, 500, 500}, 4, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			var nonces sync.Map
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := requests.Add(1)
+				if _, loaded := nonces.LoadOrStore(r.Header.Get(signing.NonceHeader), true); loaded {
+					t.Error("retry with the same nonce")
+				}
+				if _, err := io.ReadAll(r.Body); err != nil {
+					t.Error(err)
+				}
+				code := tt.codes[n-1]
+				if code == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", "0")
+				}
+				w.WriteHeader(code)
+			}))
+			defer srv.Close()
+
+			cl := New(base.Client{Addr: strings.TrimPrefix(srv.URL, "http://"), Key: "test", Retry: retry})
+			defer cl.Close()
+			err := cl.Send(context.Background(), 1, testMetrics())
+			var se *base.StatusError
+			switch {
+			case tt.wantCode == 0 && err != nil:
+				t.Errorf("Send() error = %v", err)
+			case tt.wantCode != 0 && (!errors.As(err, &se) || se.Code != tt.wantCode):
+				t.Errorf("Send() error = %v, want status %d", err, tt.wantCode)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestClient_Send_breaker(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	cl := New(base.Client{
+		Addr:    strings.TrimPrefix(srv.URL, "http://"),
+		Retry:   base.RetryPolicy{MaxRetries: 5, BaseDelay: time.Millisecond},
+		Breaker: base.NewBreaker(3, time.Hour),
+	})
+	defer cl.Close()
+	if err := cl.Send(context.Background(), 1, testMetrics()); !errors.Is(err, base.ErrCircuitOpen) {
+		t.Errorf("Send() error = %v, want %v", err, base.ErrCircuitOpen)
+	}
+	if err := cl.Send(context.Background(), 2, testMetrics()); !errors.Is(err, base.ErrCircuitOpen) {
+		t.Errorf("Send() with open breaker error = %v, want %v", err, base.ErrCircuitOpen)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("requests = %d, want 3", got)
+	}
+}
@@ -6,27 +6,84 @@ import (
 	"flag"
 	"log"
 	"os"
+	"runtime"
 
 	"github.com/caarlos0/env/v11"
 	"github.com/xoxloviwan/go-monitor/internal/helpers"
 )
 
 const (
-	addressDefault        = "localhost:8080"
-	pollIntervalDefault   = 2
-	reportIntervalDefault = 10
-	rateLimitDefault      = 1
+	addressDefault         = "localhost:8080"
+	pollIntervalDefault    = 2
+	reportIntervalDefault  = 10
+	rateLimitDefault       = 1
+	dialTimeoutDefault     = 5
+	requestTimeoutDefault  = 10
+	retriesDefault         = 3
+	retryDelayDefault      = 1
+	retryMaxDelayDefault   = 30
+	retryJitterDefault     = 0.5
+	breakerThreshDefault   = 5
+	breakerCooldownDefault = 30
+	outboxMaxSizeDefault   = 64
+	outboxMaxAgeDefault    = 3600
+	diskExcludeFSDefault   = "tmpfs,devtmpfs,overlay,squashfs"
+	netExcludeIfDefault    = "lo"
+	runtimeMemStatsDefault = true
+	// Минимум и максимум сохраняют всплески между отправками, "*=last" отключает их.
+	aggregateDefault = "*=last,min,max"
 )
 
+// collectorsDefault are the collectors enabled by default.
+// The disk and net collectors read procfs, so they are enabled by default only on Linux.
+// The cpu collector falls back to gopsutil elsewhere.
+var collectorsDefault = defaultCollectors(runtime.GOOS)
+
+func defaultCollectors(goos string) string {
+	if goos == "linux" {
+		return "runtime,memory,cpu,disk,net,random"
+	}
+	return "runtime,memory,cpu,random"
+}
+
 var (
-	address        = flag.String("a", addressDefault, "server adress")
-	pollInterval   = flag.Int("p", pollIntervalDefault, "poll interval in seconds")
-	reportInterval = flag.Int("r", reportIntervalDefault, "report interval in seconds")
-	key            = flag.String("k", "", "key for encrypting and decrypting data, e.g. 8c17b18522bf3f559864ac08f74c8ddb")
-	cryptoKey      = flag.String("crypto-key", "", "path to file with public key for encrypting data")
-	rateLimit      = flag.Int("l", rateLimitDefault, "number of outgoing requests at once")
-	config         = flag.String("c", "", "path to config file")
-	grpc           = flag.String("grpc", "", "address of gRPC server")
+	address         = flag.String("a", addressDefault, "server adress")
+	pollInterval    = flag.Int("p", pollIntervalDefault, "poll interval in seconds")
+	reportInterval  = flag.Int("r", reportIntervalDefault, "report interval in seconds")
+	key             = flag.String("k", "", "key for encrypting and decrypting data, e.g. 8c17b18522bf3f559864ac08f74c8ddb")
+	cryptoKey       = flag.String("crypto-key", "", "path to file with public key for encrypting data")
+	rateLimit       = flag.Int("l", rateLimitDefault, "number of outgoing requests at once")
+	config          = flag.String("c", "", "path to config file")
+	grpc            = flag.String("grpc", "", "address of gRPC server")
+	tlsCA           = flag.String("tls-ca", "", "path to PEM file with CA certificates for verifying the server, enables TLS")
+	tlsCert         = flag.String("tls-cert", "", "path to PEM file with agent certificate for mutual TLS, enables TLS")
+	tlsKey          = flag.String("tls-key", "", "path to PEM file with agent private key for mutual TLS")
+	signKey         = flag.String("sign-key", "", "path to file with Ed25519 private key for signing requests instead of the shared key")
+	agentID         = flag.String("agent-id", "", "agent ID in the server registry, the host name by default")
+	token           = flag.String("token", "", "bearer token for authentication on the server")
+	dialTimeout     = flag.Int("dial-timeout", dialTimeoutDefault, "timeout in seconds for connecting to the server, 0 disables the timeout")
+	requestTimeout  = flag.Int("request-timeout", requestTimeoutDefault, "timeout in seconds for each attempt to send a batch, 0 disables the timeout")
+	sendTimeout     = flag.Int("send-timeout", 0, "timeout in seconds for sending a batch with all retries, 0 means the report interval")
+	retries         = flag.Int("retries", retriesDefault, "number of retries of a batch after a transport error, a retryable status or a gRPC code, 0 disables retries")
+	retryDelay      = flag.Int("retry-delay", retryDelayDefault, "delay in seconds before the first retry, it doubles with each retry")
+	retryMaxDelay   = flag.Int("retry-max-delay", retryMaxDelayDefault, "maximum delay in seconds between retries")
+	retryJitter     = flag.Float64("retry-jitter", retryJitterDefault, "randomized part of the retry delay from 0 to 1")
+	breakerThresh   = flag.Int("breaker-threshold", breakerThreshDefault, "number of failed attempts in a row which stop sending to the server, 0 disables the circuit breaker")
+	breakerCooldown = flag.Int("breaker-cooldown", breakerCooldownDefault, "pause in seconds before trying the server again after the circuit breaker opened")
+	outboxDir       = flag.String("outbox-dir", "", "directory for batches failed while the server is unavailable, empty drops them")
+	outboxMaxSize   = flag.Int("outbox-max-size", outboxMaxSizeDefault, "maximum size of the outbox in megabytes, 0 means no limit")
+	outboxMaxAge    = flag.Int("outbox-max-age", outboxMaxAgeDefault, "maximum age in seconds of batches in the outbox, 0 means no limit")
+	collectors      = flag.String("collectors", collectorsDefault, "comma-separated list of enabled collectors with optional interval and timeout, e.g. runtime,cpu:10s,memory:30s:1s")
+	runtimeMemStats = flag.Bool("runtime-memstats", runtimeMemStatsDefault, "report the runtime metrics also with the names of runtime.MemStats fields, e.g. Alloc")
+	diskMounts      = flag.String("disk-mountpoints", "", "comma-separated patterns of mountpoints reported by the disk collector, empty means all")
+	diskExclMounts  = flag.String("disk-exclude-mountpoints", "", "comma-separated patterns of mountpoints skipped by the disk collector")
+	diskFS          = flag.String("disk-fs-types", "", "comma-separated patterns of filesystem types reported by the disk collector, empty means all")
+	diskExclFS      = flag.String("disk-exclude-fs-types", diskExcludeFSDefault, "comma-separated patterns of filesystem types skipped by the disk collector")
+	netIfs          = flag.String("net-interfaces", "", "comma-separated patterns of interfaces reported by the net collector, empty means all")
+	netExclIfs      = flag.String("net-exclude-interfaces", netExcludeIfDefault, "comma-separated patterns of interfaces skipped by the net collector")
+	cgroups         = flag.String("cgroups", "", "comma-separated paths of cgroups in the cgroup v2 hierarchy reported by the cgroup collector, empty means the own cgroup")
+	aggregate       = flag.String("aggregate", aggregateDefault, "semicolon-separated rules of gauge aggregates over the report window, the first matching rule is applied, e.g. CPUutilization_*=max,avg;*=last,min,max,avg,count; by default each gauge is reported with its last value and the _min and _max gauges, *=last reports only the last value")
+	processes       = flag.String("processes", "", "semicolon-separated list of processes watched by the process collector, e.g. nginx=name:^nginx$;db=pidfile:/run/postgresql.pid;app=cgroup:/system.slice/app.service")
 )
 
 // Config represents the configuration for the agent.
@@ -47,13 +104,79 @@ type Config struct {
 	RateLimit int `envDefault:"1"`
 	// GRPC is address for using gRPC transport
 	GRPC string `envDefault:""`
+	// Token is the bearer token for authentication on the server
+	Token string `envDefault:""`
+	// TLSCA is the path to CA certificates for verifying the server. TLS is used if TLSCA or TLSCert is set.
+	TLSCA string `envDefault:""`
+	// TLSCert is the path to the agent certificate for mutual TLS
+	TLSCert string `envDefault:""`
+	// TLSKey is the path to the agent private key for mutual TLS
+	TLSKey string `envDefault:""`
+	// SignKey is the path to the Ed25519 private key of the agent for signing requests
+	SignKey string `envDefault:""`
+	// AgentID is the agent ID in the server registry of agents
+	AgentID string `envDefault:""`
+	// DialTimeout is the timeout in seconds for connecting to the server
+	DialTimeout int64 `envDefault:"5"`
+	// RequestTimeout is the timeout in seconds for each attempt to send a batch
+	RequestTimeout int64 `envDefault:"10"`
+	// SendTimeout is the timeout in seconds for sending a batch with all retries, 0 means the report interval
+	SendTimeout int64 `envDefault:"0"`
+	// Retries is the number of retries of a failed batch
+	Retries int `envDefault:"3" json:"retries"`
+	// RetryDelay is the delay in seconds before the first retry
+	RetryDelay int64 `envDefault:"1"`
+	// RetryMaxDelay is the maximum delay in seconds between retries
+	RetryMaxDelay int64 `envDefault:"30"`
+	// RetryJitter is the randomized part of the retry delay
+	RetryJitter float64 `envDefault:"0.5" json:"retry_jitter"`
+	// BreakerThreshold is the number of failed attempts in a row which open the circuit breaker
+	BreakerThreshold int `envDefault:"5" json:"breaker_threshold"`
+	// BreakerCooldown is the pause in seconds before trying the server again
+	BreakerCooldown int64 `envDefault:"30"`
+	// OutboxDir is the directory for batches failed while the server is unavailable
+	OutboxDir string `envDefault:"" json:"outbox_dir"`
+	// OutboxMaxSize is the maximum size of the outbox in megabytes
+	OutboxMaxSize int64 `envDefault:"64" json:"outbox_max_size"`
+	// OutboxMaxAge is the maximum age in seconds of batches in the outbox
+	OutboxMaxAge int64 `envDefault:"3600"`
+	// Collectors is the comma-separated list of enabled collectors, each with optional interval and timeout.
+	// The default depends on the OS, see collectorsDefault.
+	Collectors string `envDefault:""`
+	// RuntimeMemStats reports the runtime metrics also with the names of runtime.MemStats fields
+	RuntimeMemStats bool `envDefault:"true" json:"runtime_memstats"`
+	// DiskMountpoints are the patterns of mountpoints reported by the disk collector
+	DiskMountpoints string `envDefault:"" json:"disk_mountpoints"`
+	// DiskExcludeMountpoints are the patterns of mountpoints skipped by the disk collector
+	DiskExcludeMountpoints string `envDefault:"" json:"disk_exclude_mountpoints"`
+	// DiskFSTypes are the patterns of filesystem types reported by the disk collector
+	DiskFSTypes string `envDefault:"" json:"disk_fs_types"`
+	// DiskExcludeFSTypes are the patterns of filesystem types skipped by the disk collector
+	DiskExcludeFSTypes string `envDefault:"tmpfs,devtmpfs,overlay,squashfs" json:"disk_exclude_fs_types"`
+	// NetInterfaces are the patterns of interfaces reported by the net collector
+	NetInterfaces string `envDefault:"" json:"net_interfaces"`
+	// NetExcludeInterfaces are the patterns of interfaces skipped by the net collector
+	NetExcludeInterfaces string `envDefault:"lo" json:"net_exclude_interfaces"`
+	// Processes is the list of processes watched by the process collector
+	Processes string `envDefault:""`
+	// Cgroups are the paths of cgroups reported by the cgroup collector
+	Cgroups string `envDefault:""`
+	// Aggregate are the rules selecting the aggregates of gauges over the report window
+	Aggregate string `envDefault:"*=last,min,max"`
 }
 
 // FileConfig represents the json configuration in file
 type FileConfig struct {
 	Config
-	ReportIntervalT helpers.Duration `json:"report_interval"`
-	PollIntervalT   helpers.Duration `json:"poll_interval"`
+	ReportIntervalT  helpers.Duration `json:"report_interval"`
+	PollIntervalT    helpers.Duration `json:"poll_interval"`
+	DialTimeoutT     helpers.Duration `json:"dial_timeout"`
+	RequestTimeoutT  helpers.Duration `json:"request_timeout"`
+	SendTimeoutT     helpers.Duration `json:"send_timeout"`
+	RetryDelayT      helpers.Duration `json:"retry_delay"`
+	RetryMaxDelayT   helpers.Duration `json:"retry_max_delay"`
+	BreakerCooldownT helpers.Duration `json:"breaker_cooldown"`
+	OutboxMaxAgeT    helpers.Duration `json:"outbox_max_age"`
 }
 
 // ConfigFull represents the env configuration for the agent with path to config file
@@ -68,12 +191,26 @@ type ConfigFull struct {
 // The instance is initialized with the given environment variables and command-line flags.
 func InitConfig() Config {
 	cfgDefaults := Config{
-		Address:        addressDefault,
-		PollInterval:   pollIntervalDefault,
-		ReportInterval: reportIntervalDefault,
-		RateLimit:      rateLimitDefault,
-		Key:            "",
-		CryptoKey:      "",
+		Address:              addressDefault,
+		PollInterval:         pollIntervalDefault,
+		ReportInterval:       reportIntervalDefault,
+		RateLimit:            rateLimitDefault,
+		DialTimeout:          dialTimeoutDefault,
+		RequestTimeout:       requestTimeoutDefault,
+		Retries:              retriesDefault,
+		RetryDelay:           retryDelayDefault,
+		RetryMaxDelay:        retryMaxDelayDefault,
+		RetryJitter:          retryJitterDefault,
+		BreakerThreshold:     breakerThreshDefault,
+		BreakerCooldown:      breakerCooldownDefault,
+		OutboxMaxSize:        outboxMaxSizeDefault,
+		OutboxMaxAge:         outboxMaxAgeDefault,
+		Collectors:           collectorsDefault,
+		DiskExcludeFSTypes:   diskExcludeFSDefault,
+		NetExcludeInterfaces: netExcludeIfDefault,
+		Aggregate:            aggregateDefault,
+		Key:                  "",
+		CryptoKey:            "",
 	}
 	cfg := ConfigFull{}
 	opts := env.Options{UseFieldNameByDefault: true}
@@ -93,13 +230,42 @@ func InitConfig() Config {
 	}
 
 	redefineConf(&cfgDefaults, Config{
-		Address:        *address,
-		PollInterval:   int64(*pollInterval),
-		ReportInterval: int64(*reportInterval),
-		RateLimit:      *rateLimit,
-		Key:            *key,
-		CryptoKey:      *cryptoKey,
-		GRPC:           *grpc,
+		Address:                *address,
+		PollInterval:           int64(*pollInterval),
+		ReportInterval:         int64(*reportInterval),
+		RateLimit:              *rateLimit,
+		Key:                    *key,
+		CryptoKey:              *cryptoKey,
+		GRPC:                   *grpc,
+		Token:                  *token,
+		TLSCA:                  *tlsCA,
+		TLSCert:                *tlsCert,
+		TLSKey:                 *tlsKey,
+		SignKey:                *signKey,
+		AgentID:                *agentID,
+		DialTimeout:            int64(*dialTimeout),
+		RequestTimeout:         int64(*requestTimeout),
+		SendTimeout:            int64(*sendTimeout),
+		Retries:                *retries,
+		RetryDelay:             int64(*retryDelay),
+		RetryMaxDelay:          int64(*retryMaxDelay),
+		RetryJitter:            *retryJitter,
+		BreakerThreshold:       *breakerThresh,
+		BreakerCooldown:        int64(*breakerCooldown),
+		OutboxDir:              *outboxDir,
+		OutboxMaxSize:          int64(*outboxMaxSize),
+		OutboxMaxAge:           int64(*outboxMaxAge),
+		Collectors:             *collectors,
+		RuntimeMemStats:        *runtimeMemStats,
+		DiskMountpoints:        *diskMounts,
+		DiskExcludeMountpoints: *diskExclMounts,
+		DiskFSTypes:            *diskFS,
+		DiskExcludeFSTypes:     *diskExclFS,
+		NetInterfaces:          *netIfs,
+		NetExcludeInterfaces:   *netExclIfs,
+		Processes:              *processes,
+		Cgroups:                *cgroups,
+		Aggregate:              *aggregate,
 	})
 	redefineConf(&cfgDefaults, cfg.Config)
 	log.Print(cfgDefaults)
@@ -131,6 +297,93 @@ func redefineConf(cfg *Config, leadCfg Config) {
 	if cfg.GRPC != leadCfg.GRPC && leadCfg.GRPC != "" {
 		cfg.GRPC = leadCfg.GRPC
 	}
+	if cfg.Token != leadCfg.Token && leadCfg.Token != "" {
+		cfg.Token = leadCfg.Token
+	}
+	if cfg.TLSCA != leadCfg.TLSCA && leadCfg.TLSCA != "" {
+		cfg.TLSCA = leadCfg.TLSCA
+	}
+	if cfg.TLSCert != leadCfg.TLSCert && leadCfg.TLSCert != "" {
+		cfg.TLSCert = leadCfg.TLSCert
+	}
+	if cfg.TLSKey != leadCfg.TLSKey && leadCfg.TLSKey != "" {
+		cfg.TLSKey = leadCfg.TLSKey
+	}
+	if cfg.SignKey != leadCfg.SignKey && leadCfg.SignKey != "" {
+		cfg.SignKey = leadCfg.SignKey
+	}
+	if cfg.AgentID != leadCfg.AgentID && leadCfg.AgentID != "" {
+		cfg.AgentID = leadCfg.AgentID
+	}
+	if cfg.DialTimeout != leadCfg.DialTimeout && leadCfg.DialTimeout != dialTimeoutDefault {
+		cfg.DialTimeout = leadCfg.DialTimeout
+	}
+	if cfg.RequestTimeout != leadCfg.RequestTimeout && leadCfg.RequestTimeout != requestTimeoutDefault {
+		cfg.RequestTimeout = leadCfg.RequestTimeout
+	}
+	if cfg.SendTimeout != leadCfg.SendTimeout && leadCfg.SendTimeout != 0 {
+		cfg.SendTimeout = leadCfg.SendTimeout
+	}
+	if cfg.Retries != leadCfg.Retries && leadCfg.Retries != retriesDefault {
+		cfg.Retries = leadCfg.Retries
+	}
+	if cfg.RetryDelay != leadCfg.RetryDelay && leadCfg.RetryDelay != retryDelayDefault {
+		cfg.RetryDelay = leadCfg.RetryDelay
+	}
+	if cfg.RetryMaxDelay != leadCfg.RetryMaxDelay && leadCfg.RetryMaxDelay != retryMaxDelayDefault {
+		cfg.RetryMaxDelay = leadCfg.RetryMaxDelay
+	}
+	if cfg.RetryJitter != leadCfg.RetryJitter && leadCfg.RetryJitter != retryJitterDefault {
+		cfg.RetryJitter = leadCfg.RetryJitter
+	}
+	if cfg.BreakerThreshold != leadCfg.BreakerThreshold && leadCfg.BreakerThreshold != breakerThreshDefault {
+		cfg.BreakerThreshold = leadCfg.BreakerThreshold
+	}
+	if cfg.BreakerCooldown != leadCfg.BreakerCooldown && leadCfg.BreakerCooldown != breakerCooldownDefault {
+		cfg.BreakerCooldown = leadCfg.BreakerCooldown
+	}
+	if cfg.OutboxDir != leadCfg.OutboxDir && leadCfg.OutboxDir != "" {
+		cfg.OutboxDir = leadCfg.OutboxDir
+	}
+	if cfg.OutboxMaxSize != leadCfg.OutboxMaxSize && leadCfg.OutboxMaxSize != outboxMaxSizeDefault {
+		cfg.OutboxMaxSize = leadCfg.OutboxMaxSize
+	}
+	if cfg.OutboxMaxAge != leadCfg.OutboxMaxAge && leadCfg.OutboxMaxAge != outboxMaxAgeDefault {
+		cfg.OutboxMaxAge = leadCfg.OutboxMaxAge
+	}
+	if cfg.Collectors != leadCfg.Collectors && leadCfg.Collectors != collectorsDefault && leadCfg.Collectors != "" {
+		cfg.Collectors = leadCfg.Collectors
+	}
+	if cfg.RuntimeMemStats != leadCfg.RuntimeMemStats && leadCfg.RuntimeMemStats != runtimeMemStatsDefault {
+		cfg.RuntimeMemStats = leadCfg.RuntimeMemStats
+	}
+	if cfg.DiskMountpoints != leadCfg.DiskMountpoints && leadCfg.DiskMountpoints != "" {
+		cfg.DiskMountpoints = leadCfg.DiskMountpoints
+	}
+	if cfg.DiskExcludeMountpoints != leadCfg.DiskExcludeMountpoints && leadCfg.DiskExcludeMountpoints != "" {
+		cfg.DiskExcludeMountpoints = leadCfg.DiskExcludeMountpoints
+	}
+	if cfg.DiskFSTypes != leadCfg.DiskFSTypes && leadCfg.DiskFSTypes != "" {
+		cfg.DiskFSTypes = leadCfg.DiskFSTypes
+	}
+	if cfg.DiskExcludeFSTypes != leadCfg.DiskExcludeFSTypes && leadCfg.DiskExcludeFSTypes != diskExcludeFSDefault && leadCfg.DiskExcludeFSTypes != "" {
+		cfg.DiskExcludeFSTypes = leadCfg.DiskExcludeFSTypes
+	}
+	if cfg.NetInterfaces != leadCfg.NetInterfaces && leadCfg.NetInterfaces != "" {
+		cfg.NetInterfaces = leadCfg.NetInterfaces
+	}
+	if cfg.NetExcludeInterfaces != leadCfg.NetExcludeInterfaces && leadCfg.NetExcludeInterfaces != netExcludeIfDefault && leadCfg.NetExcludeInterfaces != "" {
+		cfg.NetExcludeInterfaces = leadCfg.NetExcludeInterfaces
+	}
+	if cfg.Processes != leadCfg.Processes && leadCfg.Processes != "" {
+		cfg.Processes = leadCfg.Processes
+	}
+	if cfg.Cgroups != leadCfg.Cgroups && leadCfg.Cgroups != "" {
+		cfg.Cgroups = leadCfg.Cgroups
+	}
+	if cfg.Aggregate != leadCfg.Aggregate && leadCfg.Aggregate != aggregateDefault && leadCfg.Aggregate != "" {
+		cfg.Aggregate = leadCfg.Aggregate
+	}
 }
 
 func configFromFile(path string) Config {
@@ -147,6 +400,47 @@ func configFromFile(path string) Config {
 	}
 	cfgFile.Config.ReportInterval = int64(cfgFile.ReportIntervalT.Seconds())
 	cfgFile.Config.PollInterval = int64(cfgFile.PollIntervalT.Seconds())
+	cfgFile.Config.DialTimeout = dialTimeoutDefault
+	if cfgFile.DialTimeoutT.Duration != 0 {
+		cfgFile.Config.DialTimeout = int64(cfgFile.DialTimeoutT.Seconds())
+	}
+	cfgFile.Config.RequestTimeout = requestTimeoutDefault
+	if cfgFile.RequestTimeoutT.Duration != 0 {
+		cfgFile.Config.RequestTimeout = int64(cfgFile.RequestTimeoutT.Seconds())
+	}
+	cfgFile.Config.SendTimeout = int64(cfgFile.SendTimeoutT.Seconds())
+	cfgFile.Config.RetryDelay = retryDelayDefault
+	if cfgFile.RetryDelayT.Duration != 0 {
+		cfgFile.Config.RetryDelay = int64(cfgFile.RetryDelayT.Seconds())
+	}
+	cfgFile.Config.RetryMaxDelay = retryMaxDelayDefault
+	if cfgFile.RetryMaxDelayT.Duration != 0 {
+		cfgFile.Config.RetryMaxDelay = int64(cfgFile.RetryMaxDelayT.Seconds())
+	}
+	cfgFile.Config.BreakerCooldown = breakerCooldownDefault
+	if cfgFile.BreakerCooldownT.Duration != 0 {
+		cfgFile.Config.BreakerCooldown = int64(cfgFile.BreakerCooldownT.Seconds())
+	}
+	cfgFile.Config.OutboxMaxAge = outboxMaxAgeDefault
+	if cfgFile.OutboxMaxAgeT.Duration != 0 {
+		cfgFile.Config.OutboxMaxAge = int64(cfgFile.OutboxMaxAgeT.Seconds())
+	}
+	if !bytes.Contains(data, []byte("outbox_max_size")) {
+		cfgFile.Config.OutboxMaxSize = outboxMaxSizeDefault
+	}
+	// Отсутствующие в файле параметры не должны отключать повторы и предохранитель.
+	if !bytes.Contains(data, []byte(`"retries"`)) {
+		cfgFile.Config.Retries = retriesDefault
+	}
+	if !bytes.Contains(data, []byte("retry_jitter")) {
+		cfgFile.Config.RetryJitter = retryJitterDefault
+	}
+	if !bytes.Contains(data, []byte("breaker_threshold")) {
+		cfgFile.Config.BreakerThreshold = breakerThreshDefault
+	}
+	if !bytes.Contains(data, []byte("runtime_memstats")) {
+		cfgFile.Config.RuntimeMemStats = runtimeMemStatsDefault
+	}
 	if !bytes.Contains(data, []byte("rate_limit")) {
 		cfgFile.Config.RateLimit = rateLimitDefault
 	}
@@ -1,6 +1,8 @@
 package config_test
 
 import (
+	"runtime"
+	"strings"
 	"testing"
 
 	conf "github.com/xoxloviwan/go-monitor/internal/config_agent"
@@ -11,4 +13,8 @@ func TestInitConfig(t *testing.T) {
 	if cfg.Address != "" {
 		t.Log("default address applied")
 	}
+	// Коллекторы procfs по умолчанию включены только в Linux.
+	if got := strings.Contains(cfg.Collectors, "cpu"); got != (runtime.GOOS == "linux") {
+		t.Errorf("Collectors = %q on %s", cfg.Collectors, runtime.GOOS)
+	}
 }
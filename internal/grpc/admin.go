@@ -0,0 +1,148 @@
+package grpcservice
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"path"
+	"strings"
+
+	"github.com/xoxloviwan/go-monitor/internal/audit"
+	"github.com/xoxloviwan/go-monitor/internal/auth"
+	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+	pb "github.com/xoxloviwan/go-monitor/internal/metrics_types/proto"
+	"github.com/xoxloviwan/go-monitor/internal/store"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// AdminHandler implements pb.AdminServiceServer.
+type AdminHandler struct {
+	pb.UnimplementedAdminServiceServer
+	admin store.Admin
+	audit *audit.Logger
+}
+
+// isAdmin reports whether the method belongs to the admin service.
+func isAdmin(method string) bool {
+	return strings.HasPrefix(method, "/"+pb.AdminService_ServiceDesc.ServiceName+"/")
+}
+
+// identityKey is the context key of the name of the token that authorized an admin call.
+type identityKey struct{}
+
+// adminInterceptor requires the "authorization: Bearer <token>" metadata for admin calls
+// holding either the admin token or a token with the admin scope.
+// An empty token and nil tokens reject all admin calls.
+// The name of the token that authorized the call is stored in the context.
+func adminInterceptor(token string, tokens *auth.Store) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !isAdmin(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		got := bearerToken(ctx)
+		if token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			return handler(context.WithValue(ctx, identityKey{}, "admin-token"), req)
+		}
+		if tokens == nil {
+			if got == "" {
+				return nil, status.Errorf(codes.Unauthenticated, "no token")
+			}
+			return nil, status.Errorf(codes.Unauthenticated, "invalid token")
+		}
+		id, err := tokens.Authenticate(got, auth.ScopeAdmin)
+		if err != nil {
+			return nil, authError(err)
+		}
+		return handler(context.WithValue(ctx, identityKey{}, id.Name), req)
+	}
+}
+
+// adminError converts storage errors to gRPC status errors.
+func adminError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, store.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, store.ErrExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, path.ErrBadPattern):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func (srv *AdminHandler) reply(ctx context.Context, op string, affected int, err error, args ...any) (*pb.AdminResponse, error) {
+	// Записываем того, кто разрешил операцию: имя токена, затем имя из проверенного
+	// сертификата и только потом адрес клиента.
+	actor, _ := ctx.Value(identityKey{}).(string)
+	if actor == "" {
+		actor = peerName(ctx)
+	}
+	if p, ok := peer.FromContext(ctx); ok && actor == "" {
+		actor = p.Addr.String()
+	}
+	srv.audit.Record(ctx, audit.Entry{
+		Actor:     actor,
+		Transport: audit.TransportGRPC,
+		Op:        op,
+		Args:      args,
+		Affected:  affected,
+		Err:       err,
+	})
+	if err != nil {
+		return nil, adminError(err)
+	}
+	return &pb.AdminResponse{Affected: int64(affected)}, nil
+}
+
+// DeleteMetrics deletes metrics by names or by pattern.
+func (srv *AdminHandler) DeleteMetrics(ctx context.Context, in *pb.DeleteRequest) (*pb.AdminResponse, error) {
+	switch {
+	case len(in.Names) > 0 && in.Pattern != "":
+		return nil, status.Errorf(codes.InvalidArgument, "names and pattern are mutually exclusive")
+	case in.Pattern != "":
+		n, err := srv.admin.DeleteMatching(ctx, in.Pattern)
+		return srv.reply(ctx, "delete", n, err, "pattern", in.Pattern)
+	case len(in.Names) > 0:
+		n, err := srv.admin.Delete(ctx, in.Names...)
+		return srv.reply(ctx, "delete", n, err, "names", in.Names)
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "names or pattern is required")
+	}
+}
+
+// ResetCounter sets the counter to zero.
+func (srv *AdminHandler) ResetCounter(ctx context.Context, in *pb.ResetCounterRequest) (*pb.AdminResponse, error) {
+	err := srv.admin.ResetCounter(ctx, in.Name)
+	n := 0
+	if err == nil {
+		n = 1
+	}
+	return srv.reply(ctx, "reset", n, err, "name", in.Name)
+}
+
+// RenameMetric renames the metric.
+func (srv *AdminHandler) RenameMetric(ctx context.Context, in *pb.RenameRequest) (*pb.AdminResponse, error) {
+	if err := api.ValidateName(in.NewName); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "new name: %v", err)
+	}
+	err := srv.admin.Rename(ctx, in.OldName, in.NewName)
+	n := 0
+	if err == nil {
+		n = 1
+	}
+	return srv.reply(ctx, "rename", n, err, "old", in.OldName, "new", in.NewName)
+}
+
+// SetupAdmin registers the AdminServiceServer implementation with the provided gRPC server.
+//
+// Operations are recorded to the audit log.
+func SetupAdmin(grpcS *grpc.Server, admin store.Admin, log *audit.Logger) {
+	pb.RegisterAdminServiceServer(grpcS, &AdminHandler{admin: admin, audit: log})
+}
@@ -5,24 +5,45 @@ import (
 	"context"
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
+	"errors"
 	"net"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/xoxloviwan/go-monitor/internal/agents"
+	asc "github.com/xoxloviwan/go-monitor/internal/asymcrypto"
+	"github.com/xoxloviwan/go-monitor/internal/auth"
 	mcv "github.com/xoxloviwan/go-monitor/internal/metrics_convert"
 	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
 	pb "github.com/xoxloviwan/go-monitor/internal/metrics_types/proto"
+	"github.com/xoxloviwan/go-monitor/internal/ratelimit"
+	"github.com/xoxloviwan/go-monitor/internal/signing"
+	"github.com/xoxloviwan/go-monitor/internal/store"
+	"github.com/xoxloviwan/go-monitor/internal/telemetry"
+	"github.com/xoxloviwan/go-monitor/internal/tlsconfig"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 // Storage is an interface that defines the methods for storing metrics.
-// The AddMetrics method adds a list of metrics to the storage.
+// The AddMetrics method adds a list of metrics to the storage,
+// the GetMetrics method returns the stored values of the metrics.
 type Storage interface {
 	AddMetrics(ctx context.Context, metrics *api.MetricsList) error
+	GetMetrics(ctx context.Context, metrics api.MetricsList) (api.MetricsList, error)
 }
 
 // MetricsHandler поддерживает все необходимые методы сервера.
@@ -54,9 +75,41 @@ func logInterceptor(log logger) grpc.UnaryServerInterceptor {
 	}
 }
 
+func statsInterceptor(stats *telemetry.Server) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if stats == nil {
+			return handler(ctx, req)
+		}
+		start := time.Now()
+		m, err := handler(ctx, req)
+		code := status.Code(err).String()
+		stats.GRPCRequests.Inc(info.FullMethod, code)
+		stats.GRPCDuration.Since(start, info.FullMethod, code)
+		return m, err
+	}
+}
+
+// isHealthCheck reports whether the method belongs to the grpc.health.v1 service.
+// Health checks come from orchestrators and load balancers, so they bypass readiness, subnet and signature checks.
+func isHealthCheck(method string) bool {
+	return strings.HasPrefix(method, "/"+healthpb.Health_ServiceDesc.ServiceName+"/")
+}
+
+// readyInterceptor rejects calls with Unavailable until the server is ready.
+func readyInterceptor(ready func() bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if ready == nil || ready() || isHealthCheck(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		return nil, status.Errorf(codes.Unavailable, "server is not ready")
+	}
+}
+
+// subnetInterceptor rejects calls from clients outside the trusted subnet by the X-Real-IP metadata.
+// A client with a verified certificate is identified by it, so the metadata is not trusted then.
 func subnetInterceptor(subnet *net.IPNet) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
-		if subnet == nil {
+		if subnet == nil || isHealthCheck(info.FullMethod) || peerName(ctx) != "" {
 			return handler(ctx, req)
 		}
 		md, ok := metadata.FromIncomingContext(ctx)
@@ -75,16 +128,88 @@ func subnetInterceptor(subnet *net.IPNet) grpc.UnaryServerInterceptor {
 	}
 }
 
-func verifyHashInterceptor(key []byte) grpc.UnaryServerInterceptor {
+// sessionKeyHeader is the metadata key with the session key of V1 encrypted metrics over HTTP.
+const sessionKeyHeader = "X-Key"
+
+// decryptInterceptor decrypts the metrics of AddMetrics calls sent in the encrypted field.
+//
+// Only the V2 envelope is accepted, it carries the wrapped session key and protects the integrity of the metrics.
+// The V1 format with the session key in the X-Key metadata has no integrity protection and no gRPC clients,
+// so such calls are rejected. The private key is selected by the X-Key-ID metadata, without it each key is tried.
+// The decrypted protobuf Metrics replace the request, calls without encrypted metrics are passed as is.
+func decryptInterceptor(keys *asc.Keyring) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
-		if len(key) == 0 {
+		metrs, ok := req.(*pb.Metrics)
+		if !ok || len(metrs.GetEncrypted()) == 0 {
+			return handler(ctx, req)
+		}
+		if keys == nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "encrypted metrics are not supported, the server has no private key")
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		if firstValue(md, sessionKeyHeader) != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "session key in metadata is not supported, send the envelope")
+		}
+		data, err := keys.Open(firstValue(md, asc.KeyIDHeader), metrs.Encrypted)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "decrypt metrics: %v", err)
+		}
+		var decrypted pb.Metrics
+		if err = proto.Unmarshal(data, &decrypted); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "decrypted metrics: %v", err)
+		}
+		if len(decrypted.Encrypted) > 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "nested encrypted metrics")
+		}
+		return handler(ctx, &decrypted)
+	}
+}
+
+// agentIDKey is the context key of the ID of the agent that signed the call.
+type agentIDKey struct{}
+
+// verifyHashInterceptor checks the signature of AddMetrics calls over the timestamp, nonce and metrics,
+// and rejects stale and replayed calls.
+//
+// The metrics are signed in the canonical serialization of metrictypes.MetricsList, the same as over HTTP.
+//
+// A call with the X-Agent-ID metadata must be signed with the Ed25519 key of the agent from the registry,
+// other calls are signed with the shared key in the HashSHA256 metadata.
+func verifyHashInterceptor(key []byte, registry *agents.Registry, guard *signing.Guard) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		// Admin calls are authenticated by the token and carry no metrics to sign.
+		if (len(key) == 0 && registry == nil) || isHealthCheck(info.FullMethod) || isAdmin(info.FullMethod) {
 			return handler(ctx, req)
 		}
 		md, ok := metadata.FromIncomingContext(ctx)
 		if !ok {
 			return nil, status.Errorf(codes.Unauthenticated, "no metadata")
 		}
-		gotSignHeader := md.Get("HashSHA256")
+		metrs, ok := req.(*pb.Metrics)
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "wrong data type")
+		}
+		timestamp, nonce := firstValue(md, signing.TimestampHeader), firstValue(md, signing.NonceHeader)
+		body := mcv.ConvMetricsInverse(metrs).Canonical(metrs.BatchId)
+
+		if agentID := firstValue(md, signing.AgentHeader); agentID != "" && registry != nil {
+			err := registry.Verify(agentID, timestamp, nonce, body, firstValue(md, signing.SignatureHeader))
+			switch {
+			case errors.Is(err, agents.ErrUnknownAgent), errors.Is(err, agents.ErrRevoked):
+				return nil, status.Error(codes.PermissionDenied, err.Error())
+			case err != nil:
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			if err = guard.Check(timestamp, nonce, time.Now()); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			return handler(context.WithValue(ctx, agentIDKey{}, agentID), req)
+		}
+		if len(key) == 0 {
+			return nil, status.Errorf(codes.Unauthenticated, "no signature")
+		}
+
+		gotSignHeader := md.Get(signing.HashHeader)
 		if len(gotSignHeader) == 0 {
 			return nil, status.Errorf(codes.Unauthenticated, "no hash")
 		}
@@ -93,51 +218,268 @@ func verifyHashInterceptor(key []byte) grpc.UnaryServerInterceptor {
 		if err != nil || len(gotSign) != sha256.Size {
 			return nil, status.Errorf(codes.InvalidArgument, "invalid hash")
 		}
+		sign := signing.Sign(key, timestamp, nonce, body)
+		if !hmac.Equal(sign, gotSign) {
+			return nil, status.Error(codes.InvalidArgument, "hash sum not match")
+		}
+		if err = guard.Check(timestamp, nonce, time.Now()); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return handler(ctx, req)
+	}
+}
+
+// firstValue returns the first value of the metadata key or an empty string.
+func firstValue(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
 
+// bearerToken returns the token from the "authorization: Bearer <token>" metadata.
+func bearerToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if header := md.Get("authorization"); len(header) > 0 {
+		return auth.BearerToken(header[0])
+	}
+	return ""
+}
+
+// authError converts authentication errors to gRPC status errors.
+func authError(err error) error {
+	if errors.Is(err, auth.ErrForbidden) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Unauthenticated, err.Error())
+}
+
+// authInterceptor requires a token with the write scope for the metrics service calls.
+// Health checks and admin calls are checked elsewhere.
+func authInterceptor(tokens *auth.Store) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if tokens == nil || isHealthCheck(info.FullMethod) || isAdmin(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		if _, err := tokens.Authenticate(bearerToken(ctx), auth.ScopeWrite); err != nil {
+			return nil, authError(err)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// peerName returns the common name of the verified certificate of the client,
+// or an empty string without mutual TLS.
+func peerName(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ""
+	}
+	return tlsconfig.PeerName(&info.State)
+}
+
+// clientID returns the client identifier used for rate limiting and quotas.
+//
+// The client is identified by the common name of its verified certificate if mutual TLS is used,
+// or by the ID of the agent that signed the call with its own key.
+// Otherwise it is identified by the peer address, or by the X-Real-IP metadata if it belongs to the trusted subnet.
+func clientID(ctx context.Context, subnet *net.IPNet) string {
+	if name := peerName(ctx); name != "" {
+		return name
+	}
+	if agentID, ok := ctx.Value(agentIDKey{}).(string); ok {
+		return agentID
+	}
+	if subnet != nil {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if ipHeader := md.Get("X-Real-IP"); len(ipHeader) > 0 {
+			if ip := net.ParseIP(ipHeader[0]); ip != nil && subnet.Contains(ip) {
+				return ip.String()
+			}
+		}
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+		return host
+	}
+	return p.Addr.String()
+}
+
+// limitError converts a *ratelimit.LimitError to ResourceExhausted with the retry-after trailer.
+func limitError(ctx context.Context, err error) error {
+	var le *ratelimit.LimitError
+	if !errors.As(err, &le) {
+		return err
+	}
+	grpc.SetTrailer(ctx, metadata.Pairs("retry-after", strconv.Itoa(le.RetryAfterSeconds())))
+	return status.Error(codes.ResourceExhausted, le.Error())
+}
+
+// rateLimitInterceptor limits the rate of AddMetrics calls and the volume of metrics of each client.
+// Retries of batches which batches reports as applied are not charged to the quota.
+func rateLimitInterceptor(l *ratelimit.Limiter, q *ratelimit.Quota, subnet *net.IPNet, batches store.BatchChecker) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
 		metrs, ok := req.(*pb.Metrics)
-		if !ok {
-			return nil, status.Errorf(codes.InvalidArgument, "wrong data type")
+		if !ok || (l == nil && q == nil) {
+			return handler(ctx, req)
 		}
-		body := []byte(metrs.String())
-		h := hmac.New(sha256.New, key)
-		h.Write(body)
-		sign := h.Sum(nil)
-		if !hmac.Equal(sign, gotSign) {
-			return nil, status.Errorf(codes.InvalidArgument, "hash sum not match %s %s", sign, gotSign)
+		key := clientID(ctx, subnet)
+		now := time.Now()
+		if l != nil {
+			if err := l.Allow(key, now); err != nil {
+				return nil, limitError(ctx, err)
+			}
+		}
+		if q != nil && !applied(ctx, batches, metrs.GetBatchId()) {
+			// Квота расходуется только на метрики, которые пройдут проверку.
+			accepted, _ := mcv.ConvMetricsInverse(metrs).Split()
+			series := make([]string, len(accepted))
+			for i, m := range accepted {
+				series[i] = ratelimit.SeriesKey(m.MType, m.ID)
+			}
+			if err := q.Allow(key, series, now); err != nil {
+				return nil, limitError(ctx, err)
+			}
 		}
 		return handler(ctx, req)
 	}
 }
 
-// AddMetrics
+// applied reports whether the batch was already applied, so the storage ignores it.
+func applied(ctx context.Context, batches store.BatchChecker, id string) bool {
+	if batches == nil || id == "" {
+		return false
+	}
+	ok, err := batches.BatchApplied(ctx, id)
+	return err == nil && ok
+}
+
+// AddMetrics adds valid metrics to the storage and reports accepted and rejected ones.
+//
+// The accepted metrics are reported with their stored values, like by the HTTP /updates/ route.
+// Success is true only if all metrics were accepted.
+// A batch with a batch_id that was already applied is ignored.
 func (srv *MetricsHandler) AddMetrics(ctx context.Context, in *pb.Metrics) (*pb.Response, error) {
 	metrics := mcv.ConvMetricsInverse(in)
 	var response pb.Response
+	ctx = store.WithBatchID(ctx, in.GetBatchId())
 
-	if err := srv.store.AddMetrics(ctx, metrics); err != nil {
-		return nil, err
+	accepted, rejected := metrics.Split()
+	var stored api.MetricsList
+	if len(accepted) > 0 {
+		if err := srv.store.AddMetrics(ctx, &accepted); err != nil {
+			return nil, err
+		}
+		var err error
+		if stored, err = srv.store.GetMetrics(ctx, accepted); err != nil {
+			return nil, err
+		}
 	}
-	response.Success = true
+	response.Success = len(rejected) == 0
+	response.Accepted = mcv.ConvMetrics(stored).Metrics
+	response.Rejected = mcv.ConvRejected(rejected)
 
 	return &response, nil
 }
 
-// NewGrpcServer creates a new gRPC server with the provided logger, key, and subnet interceptors.
-// The server will use the provided logger to log requests, the subnet interceptor to validate the
-// client's IP address is within the provided subnet, and the verifyHashInterceptor to validate
-// the HMAC signature of the request.
-func NewGrpcServer(log logger, key []byte, subnet *net.IPNet) *grpc.Server {
+// ServerParams holds the dependencies of the gRPC server interceptors.
+type ServerParams struct {
+	// Log is used to log requests.
+	Log logger
+	// Key for verifying the HMAC signature of requests.
+	Key []byte
+	// Subnet of trusted clients, nil allows any client.
+	Subnet *net.IPNet
+	// Stats collects per-method self-metrics, nil disables collecting.
+	Stats *telemetry.Server
+	// Ready reports whether the server may process calls, nil means always ready.
+	Ready func() bool
+	// AdminToken is the bearer token required by the admin service.
+	AdminToken string
+	// RateLimiter limits the rate of AddMetrics calls of each client, nil disables limiting.
+	RateLimiter *ratelimit.Limiter
+	// Quota limits series and samples accepted from each client, nil disables quotas.
+	Quota *ratelimit.Quota
+	// Batches reports applied batches whose retries are not charged to the quota, nil charges every call.
+	Batches store.BatchChecker
+	// Tokens authenticates bearer tokens and checks their scopes, nil disables token authentication.
+	Tokens *auth.Store
+	// TLS enables TLS with the configuration, nil serves plain connections.
+	TLS *tls.Config
+	// Nonces rejects replayed signed calls. It may be shared with the HTTP server, nil uses a new guard.
+	Nonces *signing.Guard
+	// Agents verifies calls signed with keys of agents, nil allows only the shared key.
+	Agents *agents.Registry
+	// Keys decrypt encrypted metrics, nil rejects them.
+	Keys *asc.Keyring
+}
+
+// KeepaliveMinTime is the minimum interval of keepalive pings of agents which keep idle connections to the server.
+const KeepaliveMinTime = 20 * time.Second
 
-	return grpc.NewServer(
+// NewGrpcServer creates a new gRPC server with the interceptors configured by p.
+// The server will use the provided logger to log requests, the stats interceptor to count calls
+// and measure their latency, the ready interceptor to reject calls until data is restored,
+// the subnet interceptor to validate the client's IP address is within the provided subnet,
+// the auth interceptor to check the token scope of metrics calls, the admin interceptor to check the token of admin calls,
+// the decrypt interceptor to decrypt encrypted metrics, the verifyHashInterceptor to validate the signature of the decrypted metrics,
+// and the rate limit interceptor to reject clients exceeding the rate limit or quotas.
+// If p.TLS is set, the server accepts only TLS connections.
+// Clients may keep idle connections with keepalive pings not more often than KeepaliveMinTime.
+func NewGrpcServer(p ServerParams) *grpc.Server {
+	nonces := p.Nonces
+	if nonces == nil {
+		nonces = signing.NewGuard(signing.Window)
+	}
+	opts := []grpc.ServerOption{
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             KeepaliveMinTime,
+			PermitWithoutStream: true,
+		}),
+	}
+	if p.TLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(p.TLS)))
+	}
+	return grpc.NewServer(append(opts,
 		grpc.ChainUnaryInterceptor(
-			grpc.UnaryServerInterceptor(logInterceptor(log)),
-			grpc.UnaryServerInterceptor(subnetInterceptor(subnet)),
-			grpc.UnaryServerInterceptor(verifyHashInterceptor(key)),
+			grpc.UnaryServerInterceptor(logInterceptor(p.Log)),
+			grpc.UnaryServerInterceptor(statsInterceptor(p.Stats)),
+			grpc.UnaryServerInterceptor(readyInterceptor(p.Ready)),
+			grpc.UnaryServerInterceptor(subnetInterceptor(p.Subnet)),
+			grpc.UnaryServerInterceptor(authInterceptor(p.Tokens)),
+			grpc.UnaryServerInterceptor(adminInterceptor(p.AdminToken, p.Tokens)),
+			grpc.UnaryServerInterceptor(decryptInterceptor(p.Keys)),
+			grpc.UnaryServerInterceptor(verifyHashInterceptor(p.Key, p.Agents, nonces)),
+			grpc.UnaryServerInterceptor(rateLimitInterceptor(p.RateLimiter, p.Quota, p.Subnet, p.Batches)),
 		),
-	)
+	)...)
 }
 
 // SetupServer registers the MetricsServiceServer implementation with the provided gRPC server and associates it with the provided Storage instance.
 func SetupServer(grpcS *grpc.Server, store Storage) {
 	pb.RegisterMetricsServiceServer(grpcS, &MetricsHandler{store: store})
 }
+
+// RegisterHealth registers the standard grpc.health.v1 service on the server.
+//
+// All services are reported as NOT_SERVING until SetServing is called.
+func RegisterHealth(grpcS *grpc.Server) *health.Server {
+	h := health.NewServer()
+	h.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	h.SetServingStatus(pb.MetricsService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(grpcS, h)
+	return h
+}
+
+// SetServing reports the server and the metrics service as SERVING.
+func SetServing(h *health.Server) {
+	h.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	h.SetServingStatus(pb.MetricsService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+}
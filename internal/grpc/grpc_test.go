@@ -1,22 +1,49 @@
 package grpcservice_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"log"
 	"log/slog"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/xoxloviwan/go-monitor/internal/agents"
 	mock "github.com/xoxloviwan/go-monitor/internal/api/mock"
+	asc "github.com/xoxloviwan/go-monitor/internal/asymcrypto"
+	"github.com/xoxloviwan/go-monitor/internal/audit"
+	"github.com/xoxloviwan/go-monitor/internal/auth"
+	"github.com/xoxloviwan/go-monitor/internal/clients/base"
 	grpcclient "github.com/xoxloviwan/go-monitor/internal/clients/grpc"
 	grpcservice "github.com/xoxloviwan/go-monitor/internal/grpc"
+	mcv "github.com/xoxloviwan/go-monitor/internal/metrics_convert"
 	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+	pb "github.com/xoxloviwan/go-monitor/internal/metrics_types/proto"
+	"github.com/xoxloviwan/go-monitor/internal/ratelimit"
+	"github.com/xoxloviwan/go-monitor/internal/signing"
+	"github.com/xoxloviwan/go-monitor/internal/store"
+	"github.com/xoxloviwan/go-monitor/internal/telemetry"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
 )
 
 const bufSize = 1024 * 1024
@@ -32,7 +59,12 @@ func setup(t *testing.T) (*mock.MockReaderWriter, []byte) {
 	h.Write([]byte("secret"))
 	key := h.Sum(nil)
 	_, netIP, _ := net.ParseCIDR("192.168.1.0/26")
-	s := grpcservice.NewGrpcServer(logger, key, netIP)
+	s := grpcservice.NewGrpcServer(grpcservice.ServerParams{
+		Log:    logger,
+		Key:    key,
+		Subnet: netIP,
+		Stats:  telemetry.NewServer(),
+	})
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
@@ -50,9 +82,20 @@ func bufDialer(context.Context, string) (net.Conn, error) {
 	return lis.Dial()
 }
 
+// send sends the metrics with a new gRPC client.
+func send(t *testing.T, cl base.Client, msgs api.MetricsList, opts ...grpc.DialOption) error {
+	t.Helper()
+	c, err := grpcclient.New(cl, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	return c.Send(context.Background(), 1, msgs)
+}
+
 func TestAddMetrics(t *testing.T) {
 	m, key := setup(t)
-	cl := grpcclient.Client{
+	cl := base.Client{
 		Addr:    "passthrough://bufnet",
 		LocalIP: "192.168.1.12",
 		Key:     string(key),
@@ -65,8 +108,594 @@ func TestAddMetrics(t *testing.T) {
 	metricItem.Value = &val
 	msg := api.MetricsList{metricItem}
 	m.EXPECT().AddMetrics(gomock.Any(), gomock.Any()).Return(nil).Times(1)
-	err := cl.SendWithOpts(1, msg, grpc.WithContextDialer(bufDialer))
+	m.EXPECT().GetMetrics(gomock.Any(), gomock.Any()).Return(msg, nil).Times(1)
+	err := send(t, cl, msg, grpc.WithContextDialer(bufDialer))
 	if err != nil {
 		t.Fatalf("AddMetrics failed: %v", err)
 	}
 }
+
+func TestHealth(t *testing.T) {
+	lis := bufconn.Listen(bufSize)
+	var ready atomic.Bool
+	s := grpcservice.NewGrpcServer(grpcservice.ServerParams{
+		Log:   slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Key:   []byte("secret"),
+		Ready: ready.Load,
+	})
+	h := grpcservice.RegisterHealth(s)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough://bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	res, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if res.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Check() = %v, want NOT_SERVING", res.Status)
+	}
+
+	ready.Store(true)
+	grpcservice.SetServing(h)
+	res, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "metrics.MetricsService"})
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if res.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Check() = %v, want SERVING", res.Status)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	lis := bufconn.Listen(bufSize)
+	s := grpcservice.NewGrpcServer(grpcservice.ServerParams{
+		Log:        slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Key:        []byte("secret"),
+		AdminToken: "token",
+	})
+	st := store.NewMemStorage()
+	st.Gauge["Alloc"] = 1
+	st.Counter["PollCount"] = 5
+	grpcservice.SetupAdmin(s, st, nil)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough://bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewAdminServiceClient(conn)
+
+	_, err = client.ResetCounter(context.Background(), &pb.ResetCounterRequest{Name: "PollCount"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("ResetCounter() without token error = %v, want %v", err, codes.Unauthenticated)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer token")
+	res, err := client.ResetCounter(ctx, &pb.ResetCounterRequest{Name: "PollCount"})
+	if err != nil {
+		t.Fatalf("ResetCounter() error = %v", err)
+	}
+	if res.Affected != 1 || st.Counter["PollCount"] != 0 {
+		t.Errorf("ResetCounter() = %v, counter = %v", res.Affected, st.Counter["PollCount"])
+	}
+
+	_, err = client.RenameMetric(ctx, &pb.RenameRequest{OldName: "undef", NewName: "other"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("RenameMetric() error = %v, want %v", err, codes.NotFound)
+	}
+
+	_, err = client.RenameMetric(ctx, &pb.RenameRequest{OldName: "Alloc", NewName: "bad name"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("RenameMetric() error = %v, want %v", err, codes.InvalidArgument)
+	}
+
+	res, err = client.DeleteMetrics(ctx, &pb.DeleteRequest{Names: []string{"Alloc", "PollCount"}})
+	if err != nil {
+		t.Fatalf("DeleteMetrics() error = %v", err)
+	}
+	if res.Affected != 2 {
+		t.Errorf("DeleteMetrics() = %v, want %v", res.Affected, 2)
+	}
+}
+
+func TestAddMetrics_rejected(t *testing.T) {
+	lis := bufconn.Listen(bufSize)
+	s := grpcservice.NewGrpcServer(grpcservice.ServerParams{
+		Log: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+	})
+	st := store.NewMemStorage()
+	grpcservice.SetupServer(s, st)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough://bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewMetricsServiceClient(conn)
+
+	st.Add("counter", "PollCount", "5")
+	res, err := client.AddMetrics(context.Background(), &pb.Metrics{Metrics: []*pb.Metric{
+		{Id: "PollCount", Type: "counter", Delta: 2},
+		{Id: "bad name", Type: "gauge", Value: 1},
+		{Id: "Alloc", Type: "histogram"},
+	}})
+	if err != nil {
+		t.Fatalf("AddMetrics() error = %v", err)
+	}
+	if res.Success {
+		t.Error("AddMetrics() success must be false when metrics are rejected")
+	}
+	// Счетчик возвращается с сохраненным значением, а не с присланным приращением.
+	if len(res.Accepted) != 1 || res.Accepted[0].Id != "PollCount" || res.Accepted[0].Delta != 7 {
+		t.Errorf("AddMetrics() accepted = %v", res.Accepted)
+	}
+	if len(res.Rejected) != 2 || res.Rejected[0].Index != 1 || res.Rejected[1].Index != 2 {
+		t.Errorf("AddMetrics() rejected = %v", res.Rejected)
+	}
+	if v, ok := st.Get("counter", "PollCount"); !ok || v != "7" {
+		t.Errorf("PollCount = %v, %v, want 7", v, ok)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	lis := bufconn.Listen(bufSize)
+	s := grpcservice.NewGrpcServer(grpcservice.ServerParams{
+		Log:         slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		RateLimiter: ratelimit.NewLimiter(1, 1),
+	})
+	grpcservice.SetupServer(s, store.NewMemStorage())
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough://bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewMetricsServiceClient(conn)
+	in := &pb.Metrics{Metrics: []*pb.Metric{{Id: "PollCount", Type: "counter", Delta: 1}}}
+
+	if _, err = client.AddMetrics(context.Background(), in); err != nil {
+		t.Fatalf("AddMetrics() error = %v", err)
+	}
+	var trailer metadata.MD
+	_, err = client.AddMetrics(context.Background(), in, grpc.Trailer(&trailer))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("AddMetrics() over limit error = %v, want %v", err, codes.ResourceExhausted)
+	}
+	if got := trailer.Get("retry-after"); len(got) != 1 || got[0] != "1" {
+		t.Errorf("retry-after trailer = %v, want [1]", got)
+	}
+}
+
+func TestQuota_rejected(t *testing.T) {
+	lis := bufconn.Listen(bufSize)
+	s := grpcservice.NewGrpcServer(grpcservice.ServerParams{
+		Log:   slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Quota: ratelimit.NewQuota(1, 0),
+	})
+	grpcservice.SetupServer(s, store.NewMemStorage())
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough://bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewMetricsServiceClient(conn)
+
+	// Отклоненные метрики не расходуют квоту на число рядов.
+	res, err := client.AddMetrics(context.Background(), &pb.Metrics{Metrics: []*pb.Metric{
+		{Id: "bad 1", Type: "counter", Delta: 1},
+		{Id: "bad 2", Type: "counter", Delta: 1},
+		{Id: "PollCount", Type: "counter", Delta: 1},
+	}})
+	if err != nil {
+		t.Fatalf("AddMetrics() error = %v", err)
+	}
+	if len(res.Accepted) != 1 || len(res.Rejected) != 2 {
+		t.Errorf("AddMetrics() = %v", res)
+	}
+}
+
+func TestQuota_appliedBatch(t *testing.T) {
+	lis := bufconn.Listen(bufSize)
+	st := store.NewMemStorage()
+	s := grpcservice.NewGrpcServer(grpcservice.ServerParams{
+		Log:     slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Quota:   ratelimit.NewQuota(0, 1),
+		Batches: st,
+	})
+	grpcservice.SetupServer(s, st)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough://bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewMetricsServiceClient(conn)
+
+	// Повтор примененного пакета не расходует квоту, новый пакет сверх квоты отклоняется.
+	batch := func(id string) *pb.Metrics {
+		return &pb.Metrics{BatchId: id, Metrics: []*pb.Metric{{Id: "PollCount", Type: "counter", Delta: 1}}}
+	}
+	for _, id := range []string{"batch1", "batch1"} {
+		if _, err = client.AddMetrics(context.Background(), batch(id)); err != nil {
+			t.Fatalf("AddMetrics(%s) error = %v", id, err)
+		}
+	}
+	if _, err = client.AddMetrics(context.Background(), batch("batch2")); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("AddMetrics() over quota error = %v, want %v", err, codes.ResourceExhausted)
+	}
+	if got, _ := st.Get("counter", "PollCount"); got != "1" {
+		t.Errorf("PollCount = %s, want 1", got)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	data := `{"tokens": [
+		{"name": "agent", "hash": "` + auth.HashToken("agent-secret") + `", "scopes": ["write"]},
+		{"name": "dashboard", "hash": "` + auth.HashToken("dash-secret") + `", "scopes": ["read"]},
+		{"name": "root", "hash": "` + auth.HashToken("root-secret") + `", "scopes": ["admin"]}
+	]}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis := bufconn.Listen(bufSize)
+	s := grpcservice.NewGrpcServer(grpcservice.ServerParams{
+		Log:    slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Tokens: tokens,
+	})
+	st := store.NewMemStorage()
+	var auditBuf bytes.Buffer
+	grpcservice.SetupServer(s, st)
+	grpcservice.SetupAdmin(s, st, audit.New(&auditBuf))
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough://bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewMetricsServiceClient(conn)
+	admin := pb.NewAdminServiceClient(conn)
+	withToken := func(token string) context.Context {
+		if token == "" {
+			return context.Background()
+		}
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+	metrs := &pb.Metrics{Metrics: []*pb.Metric{{Id: "PollCount", Type: "counter", Delta: 1}}}
+
+	tests := []struct {
+		name  string
+		call  func(ctx context.Context) error
+		token string
+		want  codes.Code
+	}{
+		{"add without token", func(ctx context.Context) error { _, err := client.AddMetrics(ctx, metrs); return err }, "", codes.Unauthenticated},
+		{"add with unknown token", func(ctx context.Context) error { _, err := client.AddMetrics(ctx, metrs); return err }, "wrong", codes.Unauthenticated},
+		{"add with read token", func(ctx context.Context) error { _, err := client.AddMetrics(ctx, metrs); return err }, "dash-secret", codes.PermissionDenied},
+		{"add with write token", func(ctx context.Context) error { _, err := client.AddMetrics(ctx, metrs); return err }, "agent-secret", codes.OK},
+		{"admin with write token", func(ctx context.Context) error {
+			_, err := admin.ResetCounter(ctx, &pb.ResetCounterRequest{Name: "PollCount"})
+			return err
+		}, "agent-secret", codes.PermissionDenied},
+		{"admin with admin token", func(ctx context.Context) error {
+			_, err := admin.ResetCounter(ctx, &pb.ResetCounterRequest{Name: "PollCount"})
+			return err
+		}, "root-secret", codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.Code(tt.call(withToken(tt.token))); got != tt.want {
+				t.Errorf("code = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	// Операция записывается в журнал от имени токена.
+	if !strings.Contains(auditBuf.String(), `"actor":"root"`) {
+		t.Errorf("audit record without the token name: %s", auditBuf.String())
+	}
+}
+
+func TestReplay(t *testing.T) {
+	lis := bufconn.Listen(bufSize)
+	key := []byte("secret")
+	s := grpcservice.NewGrpcServer(grpcservice.ServerParams{
+		Log: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Key: key,
+	})
+	grpcservice.SetupServer(s, store.NewMemStorage())
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough://bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewMetricsServiceClient(conn)
+
+	metrs := &pb.Metrics{Metrics: []*pb.Metric{{Id: "PollCount", Type: "counter", Delta: 1}}}
+	signed := func(timestamp, nonce string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(),
+			signing.TimestampHeader, timestamp,
+			signing.NonceHeader, nonce,
+			signing.HashHeader, signing.SignHex(key, timestamp, nonce, mcv.ConvMetricsInverse(metrs).Canonical(metrs.BatchId)),
+		)
+	}
+	now := signing.Timestamp(time.Now())
+
+	if _, err = client.AddMetrics(signed(now, "n1"), metrs); err != nil {
+		t.Fatalf("AddMetrics() error = %v", err)
+	}
+	if _, err = client.AddMetrics(signed(now, "n1"), metrs); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("replayed AddMetrics() error = %v, want %v", err, codes.InvalidArgument)
+	}
+	stale := signing.Timestamp(time.Now().Add(-time.Hour))
+	if _, err = client.AddMetrics(signed(stale, "n2"), metrs); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("stale AddMetrics() error = %v, want %v", err, codes.InvalidArgument)
+	}
+}
+
+func TestAgentSignature(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "agents.json")
+	data := `{"agents": [{"id": "host-1", "public_key": "` + base64.StdEncoding.EncodeToString(pub) + `"}]}`
+	if err = os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	registry, err := agents.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis := bufconn.Listen(bufSize)
+	s := grpcservice.NewGrpcServer(grpcservice.ServerParams{
+		Log:    slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Key:    []byte("shared"),
+		Agents: registry,
+	})
+	st := store.NewMemStorage()
+	grpcservice.SetupServer(s, st)
+	go s.Serve(lis)
+	defer s.Stop()
+	dialer := grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() })
+
+	counter := 1.0
+	msg := api.MetricsList{{ID: "Alloc", MType: "gauge", Value: &counter}}
+	cl := base.Client{Addr: "passthrough://bufnet", AgentID: "host-1", SignKey: key}
+	if err = send(t, cl, msg, dialer); err != nil {
+		t.Fatalf("Send() signed by agent error = %v", err)
+	}
+	shared := base.Client{Addr: "passthrough://bufnet", Key: "shared"}
+	if err = send(t, shared, msg, dialer); err != nil {
+		t.Errorf("Send() signed by shared key error = %v", err)
+	}
+
+	if err = registry.Revoke("host-1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err = send(t, cl, msg, dialer); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Send() of revoked agent error = %v, want %v", err, codes.PermissionDenied)
+	}
+	unknown := base.Client{Addr: "passthrough://bufnet", AgentID: "host-2", SignKey: key}
+	if err = send(t, unknown, msg, dialer); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Send() of unknown agent error = %v, want %v", err, codes.PermissionDenied)
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := asc.NewKeyring(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(keys *asc.Keyring) (*store.MemStorage, grpc.DialOption, func()) {
+		lis := bufconn.Listen(bufSize)
+		s := grpcservice.NewGrpcServer(grpcservice.ServerParams{
+			Log:  slog.New(slog.NewTextHandler(os.Stdout, nil)),
+			Key:  []byte("secret"),
+			Keys: keys,
+		})
+		st := store.NewMemStorage()
+		grpcservice.SetupServer(s, st)
+		go s.Serve(lis)
+		return st, grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }), s.Stop
+	}
+	counter := int64(7)
+	msg := api.MetricsList{{ID: "PollCount", MType: api.CounterName, Delta: &counter}}
+
+	t.Run("envelope", func(t *testing.T) {
+		st, dialer, stop := serve(keys)
+		defer stop()
+		cl := base.Client{Addr: "passthrough://bufnet", Key: "secret", PublicKey: &rsaKey.PublicKey}
+		if err := send(t, cl, msg, dialer); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		if got, ok := st.Get(api.CounterName, "PollCount"); !ok || got != "7" {
+			t.Errorf("PollCount = %q, %v, want 7", got, ok)
+		}
+	})
+	t.Run("session key", func(t *testing.T) {
+		st, dialer, stop := serve(keys)
+		defer stop()
+		conn, err := grpc.NewClient("passthrough://bufnet", dialer, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		data, err := proto.Marshal(mcv.ConvMetrics(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessionKey, encrypted, err := asc.Encrypt(&rsaKey.PublicKey, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		timestamp, nonce := signing.Timestamp(time.Now()), signing.NewNonce()
+		ctx := metadata.AppendToOutgoingContext(context.Background(),
+			"X-Key", hex.EncodeToString(sessionKey),
+			signing.TimestampHeader, timestamp,
+			signing.NonceHeader, nonce,
+			signing.HashHeader, signing.SignHex([]byte("secret"), timestamp, nonce, msg.Canonical("")),
+		)
+		// Формат V1 без проверки целостности по gRPC не принимается.
+		_, err = pb.NewMetricsServiceClient(conn).AddMetrics(ctx, &pb.Metrics{Encrypted: encrypted})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("AddMetrics() error = %v, want %v", err, codes.InvalidArgument)
+		}
+		if _, ok := st.Get(api.CounterName, "PollCount"); ok {
+			t.Error("PollCount saved")
+		}
+	})
+	t.Run("other key", func(t *testing.T) {
+		_, dialer, stop := serve(keys)
+		defer stop()
+		cl := base.Client{Addr: "passthrough://bufnet", Key: "secret", PublicKey: &otherKey.PublicKey}
+		if err := send(t, cl, msg, dialer); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Send() error = %v, want %v", err, codes.InvalidArgument)
+		}
+	})
+	t.Run("no private key", func(t *testing.T) {
+		_, dialer, stop := serve(nil)
+		defer stop()
+		cl := base.Client{Addr: "passthrough://bufnet", Key: "secret", PublicKey: &rsaKey.PublicKey}
+		if err := send(t, cl, msg, dialer); status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("Send() error = %v, want %v", err, codes.FailedPrecondition)
+		}
+	})
+}
+
+// countingListener counts accepted connections.
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+func TestClient_reusesConnection(t *testing.T) {
+	bl := bufconn.Listen(bufSize)
+	cl := &countingListener{Listener: bl}
+	s := grpcservice.NewGrpcServer(grpcservice.ServerParams{Log: slog.New(slog.NewTextHandler(os.Stdout, nil))})
+	grpcservice.SetupServer(s, store.NewMemStorage())
+	go s.Serve(cl)
+	defer s.Stop()
+
+	c, err := grpcclient.New(base.Client{Addr: "passthrough://bufnet", RequestTimeout: time.Second},
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return bl.Dial() }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	value := 1.5
+	msg := api.MetricsList{{ID: "Alloc", MType: api.GaugeName, Value: &value}}
+	for i := 0; i < 3; i++ {
+		if err = c.Send(context.Background(), 1, msg); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	if got := cl.accepted.Load(); got != 1 {
+		t.Errorf("connections = %d, want 1", got)
+	}
+}
+
+func TestClient_retry(t *testing.T) {
+	lis := bufconn.Listen(bufSize)
+	var calls atomic.Int32
+	s := grpcservice.NewGrpcServer(grpcservice.ServerParams{
+		Log: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		// Сервер готов только с третьего вызова.
+		Ready: func() bool { return calls.Add(1) > 2 },
+	})
+	st := store.NewMemStorage()
+	grpcservice.SetupServer(s, st)
+	go s.Serve(lis)
+	defer s.Stop()
+	dialer := grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() })
+
+	value := 1.5
+	msg := api.MetricsList{{ID: "Alloc", MType: api.GaugeName, Value: &value}}
+	retry := base.RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond}
+	if err := send(t, base.Client{Addr: "passthrough://bufnet", Retry: retry}, msg, dialer); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("calls = %d, want 3", got)
+	}
+	if _, ok := st.Get(api.GaugeName, "Alloc"); !ok {
+		t.Error("Alloc not saved")
+	}
+
+	calls.Store(0)
+	err := send(t, base.Client{Addr: "passthrough://bufnet", Retry: base.RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond}}, msg, dialer)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Send() error = %v, want %v", err, codes.Unavailable)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("calls = %d, want 2", got)
+	}
+}
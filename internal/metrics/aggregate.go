@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+)
+
+// Aggregate is a statistic of the polled values of a gauge in the report window.
+type Aggregate string
+
+const (
+	// AggregateLast is the last value, reported with the name of the gauge.
+	AggregateLast Aggregate = "last"
+	// AggregateMin is the minimum, reported with the suffix _min.
+	AggregateMin Aggregate = "min"
+	// AggregateMax is the maximum, reported with the suffix _max.
+	AggregateMax Aggregate = "max"
+	// AggregateAvg is the average, reported with the suffix _avg.
+	AggregateAvg Aggregate = "avg"
+	// AggregateCount is the number of polled values, reported with the suffix _count.
+	AggregateCount Aggregate = "count"
+)
+
+// AggregationRule selects the aggregates reported for the gauges matching the pattern of path.Match.
+type AggregationRule struct {
+	Pattern    string
+	Aggregates []Aggregate
+}
+
+// ParseAggregation parses a semicolon-separated list of aggregation rules.
+//
+// Each element is a pattern of gauge names and a comma-separated list of aggregates,
+// the first matching rule is applied:
+//
+//	CPUutilization_*=max,avg;*=last,min,max,avg,count
+func ParseAggregation(s string) ([]AggregationRule, error) {
+	var rules []AggregationRule
+	for _, elem := range strings.Split(s, ";") {
+		elem = strings.TrimSpace(elem)
+		if elem == "" {
+			continue
+		}
+		pattern, list, ok := strings.Cut(elem, "=")
+		pattern = strings.TrimSpace(pattern)
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("aggregation %q: want pattern=aggregates", elem)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("aggregation %q: %w", pattern, err)
+		}
+		rule := AggregationRule{Pattern: pattern}
+		for _, name := range strings.Split(list, ",") {
+			agg := Aggregate(strings.TrimSpace(name))
+			switch agg {
+			case AggregateLast, AggregateMin, AggregateMax, AggregateAvg, AggregateCount:
+			default:
+				return nil, fmt.Errorf("aggregation %q: unknown aggregate %q", pattern, agg)
+			}
+			if !slices.Contains(rule.Aggregates, agg) {
+				rule.Aggregates = append(rule.Aggregates, agg)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// aggregates returns the aggregates of the gauge, the last value without a matching rule.
+func aggregates(rules []AggregationRule, name string) []Aggregate {
+	for _, rule := range rules {
+		if ok, _ := path.Match(rule.Pattern, name); ok {
+			return rule.Aggregates
+		}
+	}
+	return []Aggregate{AggregateLast}
+}
+
+// window are the statistics of the polled values of a gauge in the report window.
+type window struct {
+	min, max, sum, last float64
+	count               int64
+}
+
+// observe adds a polled value.
+func (w *window) observe(v float64) {
+	if w.count == 0 {
+		w.min, w.max, w.sum = v, v, 0
+	}
+	w.min = min(w.min, v)
+	w.max = max(w.max, v)
+	w.sum += v
+	w.last = v
+	w.count++
+}
+
+// reset starts the next window, the values of an empty window are the last value.
+func (w *window) reset() {
+	w.min, w.max, w.sum, w.count = w.last, w.last, 0, 0
+}
+
+// metrics returns the gauges of the aggregates of the window.
+func (w *window) metrics(name string, aggs []Aggregate) api.MetricsList {
+	avg := w.last
+	if w.count > 0 {
+		avg = w.sum / float64(w.count)
+	}
+	msgs := make(api.MetricsList, 0, len(aggs))
+	for _, agg := range aggs {
+		switch agg {
+		case AggregateLast:
+			msgs = append(msgs, gauge(name, w.last))
+		case AggregateMin:
+			msgs = append(msgs, gauge(name+"_min", w.min))
+		case AggregateMax:
+			msgs = append(msgs, gauge(name+"_max", w.max))
+		case AggregateAvg:
+			msgs = append(msgs, gauge(name+"_avg", avg))
+		case AggregateCount:
+			msgs = append(msgs, gauge(name+"_count", float64(w.count)))
+		}
+	}
+	return msgs
+}
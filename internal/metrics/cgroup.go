@@ -0,0 +1,241 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+)
+
+// ErrNoCgroupV2 is returned when the agent is not in a cgroup v2 hierarchy.
+var ErrNoCgroupV2 = errors.New("cgroup v2 is not used")
+
+// cgroupCPUCounters are the counters of cpu.stat reported by the cgroup collector.
+var cgroupCPUCounters = []struct{ key, name string }{
+	{"usage_usec", "CgroupCPUUsage"},
+	{"user_usec", "CgroupCPUUser"},
+	{"system_usec", "CgroupCPUSystem"},
+	{"nr_periods", "CgroupCPUPeriods"},
+	{"nr_throttled", "CgroupCPUThrottledPeriods"},
+	{"throttled_usec", "CgroupCPUThrottled"},
+}
+
+// cgroupIOCounters are the counters of io.stat reported by the cgroup collector.
+var cgroupIOCounters = []struct{ key, name string }{
+	{"rbytes", "CgroupIOReadBytes"},
+	{"wbytes", "CgroupIOWriteBytes"},
+	{"rios", "CgroupIOReads"},
+	{"wios", "CgroupIOWrites"},
+}
+
+// Cgroup collects the resources used by cgroups v2, e.g. by the container of the agent.
+//
+// The cgroup is the name suffix: self for the own cgroup of the agent, otherwise its path.
+// For each cgroup the collector reports the counters of cpu.stat, the times are in microseconds:
+//
+//	CgroupCPUUsage_self, CgroupCPUUser_self, CgroupCPUSystem_self
+//	CgroupCPUPeriods_self, CgroupCPUThrottledPeriods_self, CgroupCPUThrottled_self
+//
+// the gauges of memory.current, memory.max and pids.current:
+//
+//	CgroupMemoryCurrent_self, CgroupMemoryMax_self, CgroupPids_self
+//
+// and the counters of io.stat for each device:
+//
+//	CgroupIOReadBytes_self_8:0, CgroupIOWriteBytes_self_8:0, CgroupIOReads_self_8:0, CgroupIOWrites_self_8:0
+//
+// Files of controllers not enabled for the cgroup are skipped, as well as memory.max without a limit.
+type Cgroup struct {
+	root  string
+	proc  string
+	paths []string
+}
+
+// NewCgroup returns the collector of the cgroups with the paths in the hierarchy, no paths means the own cgroup.
+func NewCgroup(paths []string) *Cgroup {
+	return &Cgroup{root: cgroupRoot, proc: procRoot, paths: paths}
+}
+
+func (*Cgroup) Name() string { return "cgroup" }
+
+func (*Cgroup) Interval() time.Duration { return 0 }
+
+func (c *Cgroup) Collect(context.Context) (api.MetricsList, error) {
+	type cgroup struct{ label, path string }
+	var cgroups []cgroup
+	for _, path := range c.paths {
+		label := strings.Trim(path, "/")
+		if label == "" {
+			label = "root"
+		}
+		cgroups = append(cgroups, cgroup{label: label, path: path})
+	}
+	if len(cgroups) == 0 {
+		path, err := ownCgroup(filepath.Join(c.proc, "self", "cgroup"))
+		if err != nil {
+			return nil, err
+		}
+		cgroups = append(cgroups, cgroup{label: "self", path: path})
+	}
+	var msgs api.MetricsList
+	var errs []error
+	for _, cg := range cgroups {
+		metrics, err := readCgroup(filepath.Join(c.root, cg.path), cg.label)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cgroup %s: %w", cg.path, err))
+		}
+		msgs = append(msgs, metrics...)
+	}
+	return msgs, errors.Join(errs...)
+}
+
+// ownCgroup returns the path of the process cgroup from the line of the unified hierarchy in /proc/self/cgroup.
+func ownCgroup(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if cgroup, ok := strings.CutPrefix(line, "0::"); ok {
+			return cgroup, nil
+		}
+	}
+	return "", ErrNoCgroupV2
+}
+
+// readCgroup reads the metrics of the cgroup in the directory.
+func readCgroup(dir, label string) (api.MetricsList, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	// В иерархии cgroup v1 нет файла cgroup.controllers.
+	if _, err := os.Stat(filepath.Join(dir, "cgroup.controllers")); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNoCgroupV2
+		}
+		return nil, err
+	}
+	var msgs api.MetricsList
+	var errs []error
+	// Файлов выключенного контроллера нет, это не ошибка.
+	missing := func(err error) bool {
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+		return err != nil
+	}
+
+	stat, err := readKeyValues(filepath.Join(dir, "cpu.stat"))
+	if !missing(err) {
+		for _, c := range cgroupCPUCounters {
+			if value, ok := stat[c.key]; ok {
+				msgs = append(msgs, counter(seriesName(c.name, label), value))
+			}
+		}
+	}
+	for _, g := range []struct{ file, name string }{
+		{"memory.current", "CgroupMemoryCurrent"},
+		{"memory.max", "CgroupMemoryMax"},
+		{"pids.current", "CgroupPids"},
+	} {
+		value, limited, err := readCgroupValue(filepath.Join(dir, g.file))
+		if !missing(err) && limited {
+			msgs = append(msgs, gauge(seriesName(g.name, label), float64(value)))
+		}
+	}
+	devices, err := readIOStat(filepath.Join(dir, "io.stat"))
+	if !missing(err) {
+		for _, d := range devices {
+			for _, c := range cgroupIOCounters {
+				if value, ok := d.values[c.key]; ok {
+					msgs = append(msgs, counter(seriesName(c.name, label, d.device), value))
+				}
+			}
+		}
+	}
+	return msgs, errors.Join(errs...)
+}
+
+// readCgroupValue reads a file with a single value, limited is false for the value max.
+func readCgroupValue(path string) (value int64, limited bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false, err
+	}
+	s := strings.TrimSpace(string(data))
+	if s == "max" {
+		return 0, false, nil
+	}
+	if value, err = strconv.ParseInt(s, 10, 64); err != nil {
+		return 0, false, fmt.Errorf("%s: %w", path, err)
+	}
+	return value, true, nil
+}
+
+// readKeyValues reads a flat keyed file of lines "key value", e.g. cpu.stat.
+func readKeyValues(path string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := make(map[string]int64)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, fields[0], err)
+		}
+		values[fields[0]] = value
+	}
+	return values, sc.Err()
+}
+
+// ioStat are the counters of a device in io.stat.
+type ioStat struct {
+	device string
+	values map[string]int64
+}
+
+// readIOStat reads a nested keyed file of lines "major:minor key=value...", e.g. io.stat.
+func readIOStat(path string) ([]ioStat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var stats []ioStat
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		stat := ioStat{device: fields[0], values: make(map[string]int64)}
+		for _, field := range fields[1:] {
+			key, s, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			value, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", path, stat.device, err)
+			}
+			stat.values[key] = value
+		}
+		stats = append(stats, stat)
+	}
+	return stats, sc.Err()
+}
@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+// fixtureCgroup returns the cgroup collector of the fixture hierarchy.
+func fixtureCgroup(paths ...string) *Cgroup {
+	c := NewCgroup(paths)
+	c.root = "testdata/cgroup"
+	c.proc = "testdata/proc"
+	return c
+}
+
+func TestCgroup_Collect_self(t *testing.T) {
+	msgs, err := fixtureCgroup().Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := values(msgs)
+	want := map[string]float64{
+		"CgroupCPUUsage_self":            8123456,
+		"CgroupCPUUser_self":             6000000,
+		"CgroupCPUSystem_self":           2123456,
+		"CgroupCPUPeriods_self":          1200,
+		"CgroupCPUThrottledPeriods_self": 37,
+		"CgroupCPUThrottled_self":        912345,
+		"CgroupMemoryCurrent_self":       268435456,
+		"CgroupMemoryMax_self":           536870912,
+		"CgroupPids_self":                42,
+		"CgroupIOReadBytes_self_8:0":     1048576,
+		"CgroupIOWriteBytes_self_8:0":    4194304,
+		"CgroupIOReads_self_8:0":         256,
+		"CgroupIOWrites_self_8:0":        1024,
+		"CgroupIOReadBytes_self_259:0":   512,
+		"CgroupIOWrites_self_259:0":      0,
+	}
+	for name, value := range want {
+		if v, ok := got[name]; !ok || v != value {
+			t.Errorf("%s = %v, want %v", name, v, value)
+		}
+	}
+	if len(got) != len(want)+2 {
+		t.Errorf("Collect() returned %d metrics, want %d", len(got), len(want)+2)
+	}
+	for _, m := range msgs {
+		wantType := "counter"
+		if strings.HasPrefix(m.ID, "CgroupMemory") || strings.HasPrefix(m.ID, "CgroupPids") {
+			wantType = "gauge"
+		}
+		if m.MType != wantType {
+			t.Errorf("%s has type %s, want %s", m.ID, m.MType, wantType)
+		}
+		if err := m.Validate(); err != nil {
+			t.Errorf("%s: %v", m.ID, err)
+		}
+	}
+}
+
+func TestCgroup_Collect_paths(t *testing.T) {
+	msgs, err := fixtureCgroup("/", "/system.slice/app.service").Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := values(msgs)
+	want := map[string]float64{
+		"CgroupCPUUsage_root":                 99000000,
+		"CgroupCPUUser_root":                  60000000,
+		"CgroupCPUSystem_root":                39000000,
+		"CgroupPids_system.slice_app.service": 3,
+	}
+	for name, value := range want {
+		if v, ok := got[name]; !ok || v != value {
+			t.Errorf("%s = %v, want %v", name, v, value)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("Collect() = %v, want only the files of enabled controllers", got)
+	}
+}
+
+func TestCgroup_Collect_errors(t *testing.T) {
+	t.Run("no limit", func(t *testing.T) {
+		c := NewCgroup([]string{"app"})
+		c.root = writeFiles(t, map[string]string{
+			"app/cgroup.controllers": "memory\n",
+			"app/memory.current":     "1024\n",
+			"app/memory.max":         "max\n",
+		})
+		msgs, err := c.Collect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := values(msgs); len(got) != 1 || got["CgroupMemoryCurrent_app"] != 1024 {
+			t.Errorf("Collect() = %v", got)
+		}
+	})
+	t.Run("cgroup v1", func(t *testing.T) {
+		c := NewCgroup(nil)
+		c.proc = writeFiles(t, map[string]string{"self/cgroup": "4:memory:/user.slice\n1:cpu:/\n"})
+		if _, err := c.Collect(context.Background()); !errors.Is(err, ErrNoCgroupV2) {
+			t.Errorf("Collect() error = %v, want %v", err, ErrNoCgroupV2)
+		}
+	})
+	t.Run("missing cgroup", func(t *testing.T) {
+		if _, err := fixtureCgroup("/system.slice/gone.service").Collect(context.Background()); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Collect() error = %v, want %v", err, fs.ErrNotExist)
+		}
+	})
+	t.Run("v1 hierarchy", func(t *testing.T) {
+		c := NewCgroup([]string{"memory"})
+		c.root = writeFiles(t, map[string]string{"memory/memory.usage_in_bytes": "1024\n"})
+		if _, err := c.Collect(context.Background()); !errors.Is(err, ErrNoCgroupV2) {
+			t.Errorf("Collect() error = %v, want %v", err, ErrNoCgroupV2)
+		}
+	})
+	t.Run("bad value", func(t *testing.T) {
+		c := NewCgroup([]string{"app"})
+		c.root = writeFiles(t, map[string]string{
+			"app/cgroup.controllers": "pids\n",
+			"app/pids.current":       "many\n",
+		})
+		if _, err := c.Collect(context.Background()); err == nil {
+			t.Error("Collect() error = nil")
+		}
+	})
+}
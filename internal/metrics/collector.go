@@ -0,0 +1,391 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+	"github.com/xoxloviwan/go-monitor/internal/telemetry"
+)
+
+// Collector collects a group of metrics of the agent host.
+type Collector interface {
+	// Name is the name of the collector in the configuration.
+	Name() string
+	// Interval is the default interval between collections, zero means the poll interval of the agent.
+	Interval() time.Duration
+	// Collect returns the current values of the metrics.
+	//
+	// Counters are returned as totals, e.g. since the boot: the registry reports their increase since the previous report.
+	// Metrics returned with an error are reported, so a collector may return the part it managed to collect.
+	Collect(ctx context.Context) (api.MetricsList, error)
+}
+
+// ErrUnknownCollector is returned for a collector name which is not registered.
+var ErrUnknownCollector = errors.New("unknown collector")
+
+// Spec enables a collector in the configuration of the agent.
+type Spec struct {
+	Name string
+	// Interval overrides the interval of the collector.
+	Interval time.Duration
+	// Timeout limits one collection, zero means the interval.
+	Timeout time.Duration
+}
+
+// ParseSpecs parses a comma-separated list of enabled collectors.
+//
+// Each element is a name optionally followed by the interval and the timeout:
+//
+//	runtime,cpu:10s,memory:30s:1s
+func ParseSpecs(s string) ([]Spec, error) {
+	var specs []Spec
+	for _, elem := range strings.Split(s, ",") {
+		elem = strings.TrimSpace(elem)
+		if elem == "" {
+			continue
+		}
+		parts := strings.Split(elem, ":")
+		if len(parts) > 3 {
+			return nil, fmt.Errorf("collector %q: too many options", elem)
+		}
+		spec := Spec{Name: parts[0]}
+		for i, dst := range []*time.Duration{&spec.Interval, &spec.Timeout} {
+			if len(parts) <= i+1 || parts[i+1] == "" {
+				continue
+			}
+			d, err := time.ParseDuration(parts[i+1])
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("collector %q: invalid duration %q", elem, parts[i+1])
+			}
+			*dst = d
+		}
+		if slices.ContainsFunc(specs, func(s Spec) bool { return s.Name == spec.Name }) {
+			return nil, fmt.Errorf("collector %q is enabled twice", spec.Name)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// Filter selects names, e.g. of mountpoints or interfaces, by shell patterns of path.Match.
+//
+// A name matches if it matches any of Include or Include is empty, and does not match any of Exclude.
+type Filter struct {
+	Include []string
+	Exclude []string
+}
+
+// NewFilter returns a filter with comma-separated lists of patterns.
+func NewFilter(include, exclude string) (Filter, error) {
+	var f Filter
+	for _, p := range []struct {
+		list string
+		dst  *[]string
+	}{{include, &f.Include}, {exclude, &f.Exclude}} {
+		for _, pattern := range strings.Split(p.list, ",") {
+			pattern = strings.TrimSpace(pattern)
+			if pattern == "" {
+				continue
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return Filter{}, fmt.Errorf("pattern %q: %w", pattern, err)
+			}
+			*p.dst = append(*p.dst, pattern)
+		}
+	}
+	return f, nil
+}
+
+// Match reports whether the filter selects the name.
+func (f Filter) Match(name string) bool {
+	matchAny := func(patterns []string) bool {
+		return slices.ContainsFunc(patterns, func(pattern string) bool {
+			ok, _ := path.Match(pattern, name)
+			return ok
+		})
+	}
+	return (len(f.Include) == 0 || matchAny(f.Include)) && !matchAny(f.Exclude)
+}
+
+// seriesName returns the name of a series of the metric, the labels are appended to the name
+// until the metrics have labels. The characters not allowed in names are replaced with underscores.
+func seriesName(name string, labels ...string) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, label := range labels {
+		b.WriteByte('_')
+		for _, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '.', c == ':':
+				b.WriteRune(c)
+			default:
+				b.WriteByte('_')
+			}
+		}
+	}
+	return b.String()
+}
+
+// entry is an enabled collector with its state.
+type entry struct {
+	c        Collector
+	interval time.Duration
+	timeout  time.Duration
+	last     time.Time
+	running  bool
+	metrics  api.MetricsList
+	// totals are the last totals of the counters, pending are their increase since the previous report.
+	totals  map[string]int64
+	pending map[string]int64
+	// windows are the polled values of the gauges since the previous report.
+	windows map[string]*window
+}
+
+// update stores the result of a collection.
+// The series which are not in the result are dropped.
+func (e *entry) update(msgs api.MetricsList) {
+	totals := make(map[string]int64)
+	pending := make(map[string]int64)
+	windows := make(map[string]*window)
+	for _, m := range msgs {
+		if m.MType == api.GaugeName && m.Value != nil {
+			w := e.windows[m.ID]
+			if w == nil {
+				w = &window{}
+			}
+			w.observe(*m.Value)
+			windows[m.ID] = w
+			continue
+		}
+		if m.MType != api.CounterName || m.Delta == nil {
+			continue
+		}
+		total := *m.Delta
+		totals[m.ID] = total
+		pending[m.ID] = e.pending[m.ID]
+		// Первое значение только запоминается, сброшенный счетчик считается с нуля.
+		if prev, ok := e.totals[m.ID]; ok {
+			if total >= prev {
+				pending[m.ID] += total - prev
+			} else {
+				pending[m.ID] += total
+			}
+		}
+	}
+	e.metrics, e.totals, e.pending, e.windows = msgs, totals, pending, windows
+}
+
+// Registry polls the enabled collectors, each with its own interval and timeout.
+//
+// Errors and durations of collections are reported with the metrics as self-metrics of the agent:
+//
+//	go_monitor_collector_errors_total_<name>
+//	go_monitor_collector_duration_seconds_<name>_count
+//	go_monitor_collector_duration_seconds_<name>_sum
+//
+// Gauges of collectors are polled more often than reported, so each report carries the aggregates
+// of the values polled since the previous one, see SetAggregation.
+//
+// It is safe for concurrent use.
+type Registry struct {
+	poll     time.Duration
+	entries  []*entry
+	self     *telemetry.Registry
+	errors   *telemetry.CounterVec
+	duration *telemetry.HistogramVec
+	now      func() time.Time
+
+	mu        sync.Mutex
+	pollCount int64
+	rules     []AggregationRule
+}
+
+// NewRegistry returns a registry with the collectors enabled by specs in their order.
+//
+// poll is the poll interval of the agent, collections are started only by Poll.
+// The collectors not enabled by specs are ignored.
+func NewRegistry(poll time.Duration, specs []Spec, collectors ...Collector) (*Registry, error) {
+	self := telemetry.NewRegistry()
+	r := &Registry{
+		poll:     poll,
+		self:     self,
+		errors:   self.Counter("collector_errors_total", "Total number of failed collections.", "collector"),
+		duration: self.Histogram("collector_duration_seconds", "Duration of collections.", nil, "collector"),
+		now:      time.Now,
+	}
+	for _, spec := range specs {
+		i := slices.IndexFunc(collectors, func(c Collector) bool { return c.Name() == spec.Name })
+		if i < 0 {
+			return nil, fmt.Errorf("%w %q", ErrUnknownCollector, spec.Name)
+		}
+		e := &entry{c: collectors[i], interval: spec.Interval, timeout: spec.Timeout}
+		if e.interval == 0 {
+			e.interval = e.c.Interval()
+		}
+		if e.interval == 0 {
+			e.interval = poll
+		}
+		if e.timeout == 0 {
+			e.timeout = e.interval
+		}
+		r.entries = append(r.entries, e)
+	}
+	return r, nil
+}
+
+// SetAggregation sets the rules selecting the aggregates of the gauges in the report window.
+// The gauges without a matching rule are reported with the last value.
+func (r *Registry) SetAggregation(rules []AggregationRule) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = rules
+}
+
+// due reports whether the collector should run at now.
+// Тикер агента может сработать чуть раньше, поэтому допускается половина интервала опроса.
+func (r *Registry) due(e *entry, now time.Time) bool {
+	return !e.running && (e.last.IsZero() || now.Sub(e.last)+r.poll/2 >= e.interval)
+}
+
+// Poll runs the due collectors concurrently and waits for them.
+//
+// A collector exceeding its timeout is not waited for: its run is counted as failed and its result is dropped.
+// The collector is not started again until the run ends.
+func (r *Registry) Poll(ctx context.Context) {
+	now := r.now()
+	r.mu.Lock()
+	r.pollCount++
+	var due []*entry
+	for _, e := range r.entries {
+		if r.due(e, now) {
+			e.running = true
+			e.last = now
+			due = append(due, e)
+		}
+	}
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(len(due))
+	for _, e := range due {
+		go func() {
+			defer wg.Done()
+			r.run(ctx, e)
+		}()
+	}
+	wg.Wait()
+}
+
+// result is the outcome of one collection.
+type result struct {
+	metrics api.MetricsList
+	err     error
+}
+
+// run collects the metrics of the collector within its timeout.
+func (r *Registry) run(ctx context.Context, e *entry) {
+	name := e.c.Name()
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	// Не все источники учитывают контекст, поэтому коллектор выполняется отдельно и не задерживает опрос.
+	done := make(chan result, 1)
+	start := time.Now()
+	go func() {
+		var res result
+		defer func() {
+			if p := recover(); p != nil {
+				res = result{err: fmt.Errorf("panic: %v", p)}
+			}
+			r.mu.Lock()
+			e.running = false
+			r.mu.Unlock()
+			done <- res
+		}()
+		res.metrics, res.err = e.c.Collect(ctx)
+	}()
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		res = result{err: ctx.Err()}
+	}
+	r.duration.Since(start, name)
+	if res.err != nil {
+		r.errors.Inc(name)
+		slog.Error("Collector failed", "collector", name, "error", res.err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if res.err != nil && len(res.metrics) == 0 {
+		// Сбой опроса не сбрасывает окно отчета: передаются агрегаты успешных опросов,
+		// а итоги счетчиков сохраняются, чтобы не потерять их прирост.
+		return
+	}
+	e.update(res.metrics)
+}
+
+// Metrics returns the last collected metrics of all collectors, the self-metrics and the PollCount counter.
+//
+// Counters of collectors are reported with their increase since the previous call
+// and gauges with the aggregates of the values polled since the previous call.
+// The state of the report window is reset at once, so a poll is counted in exactly one report.
+func (r *Registry) Metrics() api.MetricsList {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var msgs api.MetricsList
+	for _, e := range r.entries {
+		for _, m := range e.metrics {
+			switch {
+			case m.MType == api.CounterName && m.Delta != nil:
+				m = counter(m.ID, e.pending[m.ID])
+				e.pending[m.ID] = 0
+			case e.windows[m.ID] != nil:
+				w := e.windows[m.ID]
+				msgs = append(msgs, w.metrics(m.ID, aggregates(r.rules, m.ID))...)
+				w.reset()
+				continue
+			}
+			msgs = append(msgs, m)
+		}
+	}
+	self := r.self.Snapshot()
+	names := make([]string, 0, len(self))
+	for name := range self {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		msgs = append(msgs, gauge(name, self[name]))
+	}
+	return append(msgs, counter("PollCount", r.pollCount))
+}
+
+// MakeMessages returns a channel of the metrics which is closed after the last one.
+func MakeMessages(msgs api.MetricsList) chan api.Metrics {
+	ch := make(chan api.Metrics)
+	// через отдельную горутину генератор отправляет данные в канал
+	go func() {
+		// закрываем канал по завершению горутины — это отправитель
+		defer close(ch)
+		for _, m := range msgs {
+			ch <- m
+		}
+	}()
+	return ch
+}
+
+func gauge(id string, value float64) api.Metrics {
+	return api.Metrics{ID: id, MType: api.GaugeName, Value: &value}
+}
+
+func counter(id string, delta int64) api.Metrics {
+	return api.Metrics{ID: id, MType: api.CounterName, Delta: &delta}
+}
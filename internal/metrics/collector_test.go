@@ -0,0 +1,329 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+)
+
+// fake is a collector for tests.
+type fake struct {
+	name     string
+	interval time.Duration
+	calls    atomic.Int32
+	collect  func(ctx context.Context) (api.MetricsList, error)
+}
+
+func (f *fake) Name() string { return f.name }
+
+func (f *fake) Interval() time.Duration { return f.interval }
+
+func (f *fake) Collect(ctx context.Context) (api.MetricsList, error) {
+	f.calls.Add(1)
+	return f.collect(ctx)
+}
+
+func constant(name string, value float64) *fake {
+	return &fake{name: name, collect: func(context.Context) (api.MetricsList, error) {
+		return api.MetricsList{gauge(name, value)}, nil
+	}}
+}
+
+// values returns the metrics by name.
+func values(msgs api.MetricsList) map[string]float64 {
+	res := make(map[string]float64)
+	for _, m := range msgs {
+		if m.Value != nil {
+			res[m.ID] = *m.Value
+		} else {
+			res[m.ID] = float64(*m.Delta)
+		}
+	}
+	return res
+}
+
+func TestParseSpecs(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []Spec
+		wantErr bool
+	}{
+		{"names", "runtime, cpu", []Spec{{Name: "runtime"}, {Name: "cpu"}}, false},
+		{"options", "cpu:10s,memory:30s:1s,disk::2s", []Spec{
+			{Name: "cpu", Interval: 10 * time.Second},
+			{Name: "memory", Interval: 30 * time.Second, Timeout: time.Second},
+			{Name: "disk", Timeout: 2 * time.Second},
+		}, false},
+		{"empty", "", nil, false},
+		{"invalid duration", "cpu:often", nil, true},
+		{"too many options", "cpu:1s:1s:1s", nil, true},
+		{"twice", "cpu,cpu:5s", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSpecs(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSpecs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseSpecs() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewRegistry_unknown(t *testing.T) {
+	_, err := NewRegistry(time.Second, []Spec{{Name: "disk"}}, constant("cpu", 1))
+	if !errors.Is(err, ErrUnknownCollector) {
+		t.Errorf("NewRegistry() error = %v, want %v", err, ErrUnknownCollector)
+	}
+}
+
+func TestRegistry_Poll_intervals(t *testing.T) {
+	fast, slow, disabled := constant("fast", 1), constant("slow", 2), constant("disabled", 3)
+	slow.interval = 10 * time.Second
+	reg, err := NewRegistry(2*time.Second, []Spec{{Name: "fast"}, {Name: "slow"}}, fast, slow, disabled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	reg.now = func() time.Time { return now }
+	for i := 0; i < 6; i++ {
+		reg.Poll(context.Background())
+		// Тикер может сработать немного раньше интервала.
+		now = now.Add(2*time.Second - time.Millisecond)
+	}
+	if got := fast.calls.Load(); got != 6 {
+		t.Errorf("fast calls = %d, want 6", got)
+	}
+	if got := slow.calls.Load(); got != 2 {
+		t.Errorf("slow calls = %d, want 2", got)
+	}
+	if got := disabled.calls.Load(); got != 0 {
+		t.Errorf("disabled calls = %d, want 0", got)
+	}
+	got := values(reg.Metrics())
+	if got["fast"] != 1 || got["slow"] != 2 || got["PollCount"] != 6 {
+		t.Errorf("Metrics() = %v", got)
+	}
+	if _, ok := got["disabled"]; ok {
+		t.Error("Metrics() contains a disabled collector")
+	}
+	if got["go_monitor_collector_duration_seconds_slow_count"] != 2 {
+		t.Errorf("duration count of slow = %v, want 2", got["go_monitor_collector_duration_seconds_slow_count"])
+	}
+}
+
+func TestRegistry_Poll_errors(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	failing := &fake{name: "failing", collect: func(context.Context) (api.MetricsList, error) {
+		return api.MetricsList{gauge("partial", 1)}, errors.New("permission denied")
+	}}
+	hanging := &fake{name: "hanging", collect: func(context.Context) (api.MetricsList, error) {
+		<-release
+		return nil, nil
+	}}
+	panicking := &fake{name: "panicking", collect: func(context.Context) (api.MetricsList, error) {
+		var cores []float64
+		return api.MetricsList{gauge("core", cores[1])}, nil
+	}}
+	specs := []Spec{{Name: "failing"}, {Name: "hanging", Timeout: 10 * time.Millisecond}, {Name: "panicking"}, {Name: "ok"}}
+	reg, err := NewRegistry(time.Second, specs, failing, hanging, panicking, constant("ok", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	reg.Poll(context.Background())
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Poll() took %v", d)
+	}
+
+	got := values(reg.Metrics())
+	for _, name := range []string{"failing", "hanging", "panicking"} {
+		if n := got["go_monitor_collector_errors_total_"+name]; n != 1 {
+			t.Errorf("errors of %s = %v, want 1", name, n)
+		}
+	}
+	if _, ok := got["go_monitor_collector_errors_total_ok"]; ok {
+		t.Error("errors of ok are reported")
+	}
+	if got["partial"] != 1 || got["ok"] != 1 {
+		t.Errorf("Metrics() = %v", got)
+	}
+
+	// Зависший коллектор не запускается повторно, пока не завершится.
+	reg.now = func() time.Time { return start.Add(time.Hour) }
+	reg.Poll(context.Background())
+	if n := hanging.calls.Load(); n != 1 {
+		t.Errorf("hanging calls = %d, want 1", n)
+	}
+}
+
+func TestRegistry_Metrics_counters(t *testing.T) {
+	totals := []int64{100, 150, 170, 20, 30}
+	c := &fake{name: "net"}
+	c.collect = func(context.Context) (api.MetricsList, error) {
+		return api.MetricsList{counter("Bytes", totals[c.calls.Load()-1])}, nil
+	}
+	reg, err := NewRegistry(time.Second, []Spec{{Name: "net"}}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	reg.now = func() time.Time { return now }
+	poll := func(n int) {
+		for i := 0; i < n; i++ {
+			reg.Poll(context.Background())
+			now = now.Add(time.Second)
+		}
+	}
+	// Первое значение только запоминается, прирост копится до отчета, сброс счетчика считается с нуля.
+	for _, step := range []struct {
+		polls int
+		want  float64
+	}{{1, 0}, {2, 70}, {0, 0}, {2, 30}} {
+		poll(step.polls)
+		if got := values(reg.Metrics())["Bytes"]; got != step.want {
+			t.Errorf("Bytes after %d polls = %v, want %v", c.calls.Load(), got, step.want)
+		}
+	}
+}
+
+func TestRegistry_Metrics_aggregation(t *testing.T) {
+	polled := []float64{10, 40, 20, 5}
+	c := &fake{name: "cpu"}
+	c.collect = func(context.Context) (api.MetricsList, error) {
+		v := polled[c.calls.Load()-1]
+		return api.MetricsList{gauge("CPUutilization_total", v), gauge("TotalMemory", v)}, nil
+	}
+	reg, err := NewRegistry(time.Second, []Spec{{Name: "cpu"}}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := ParseAggregation("CPUutilization_*=last,min,max,avg,count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.SetAggregation(rules)
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	reg.now = func() time.Time { return now }
+	poll := func(n int) {
+		for i := 0; i < n; i++ {
+			reg.Poll(context.Background())
+			now = now.Add(time.Second)
+		}
+	}
+
+	poll(3)
+	got := values(reg.Metrics())
+	want := map[string]float64{
+		"CPUutilization_total":       20,
+		"CPUutilization_total_min":   10,
+		"CPUutilization_total_max":   40,
+		"CPUutilization_total_avg":   70.0 / 3,
+		"CPUutilization_total_count": 3,
+		"TotalMemory":                20,
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s = %v, want %v", name, got[name], value)
+		}
+	}
+	if _, ok := got["TotalMemory_max"]; ok {
+		t.Error("Metrics() contains aggregates of a gauge without a rule")
+	}
+
+	// Окно сбрасывается при отчете, без опросов передается последнее значение.
+	for _, step := range []struct {
+		polls int
+		want  map[string]float64
+	}{
+		{0, map[string]float64{"CPUutilization_total_min": 20, "CPUutilization_total_max": 20, "CPUutilization_total_avg": 20, "CPUutilization_total_count": 0}},
+		{1, map[string]float64{"CPUutilization_total_min": 5, "CPUutilization_total_max": 5, "CPUutilization_total_avg": 5, "CPUutilization_total_count": 1}},
+	} {
+		poll(step.polls)
+		got := values(reg.Metrics())
+		for name, value := range step.want {
+			if got[name] != value {
+				t.Errorf("after %d polls %s = %v, want %v", c.calls.Load(), name, got[name], value)
+			}
+		}
+	}
+}
+
+func TestRegistry_Metrics_failedPoll(t *testing.T) {
+	polled := []float64{10, 40}
+	c := &fake{name: "cpu"}
+	c.collect = func(context.Context) (api.MetricsList, error) {
+		n := int(c.calls.Load())
+		if n > len(polled) {
+			return nil, errors.New("stat is unavailable")
+		}
+		return api.MetricsList{gauge("CPUutilization_total", polled[n-1]), counter("ContextSwitches", int64(n*100))}, nil
+	}
+	reg, err := NewRegistry(time.Second, []Spec{{Name: "cpu"}}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := ParseAggregation("*=last,max,count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.SetAggregation(rules)
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	reg.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		reg.Poll(context.Background())
+		now = now.Add(time.Second)
+	}
+
+	// Последний опрос завершился ошибкой, но агрегаты окна и прирост счетчика передаются.
+	got := values(reg.Metrics())
+	want := map[string]float64{
+		"CPUutilization_total":       40,
+		"CPUutilization_total_max":   40,
+		"CPUutilization_total_count": 2,
+		"ContextSwitches":            100,
+	}
+	for name, value := range want {
+		if v, ok := got[name]; !ok || v != value {
+			t.Errorf("%s = %v, want %v", name, got[name], value)
+		}
+	}
+}
+
+func TestParseAggregation(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []AggregationRule
+		wantErr bool
+	}{
+		{"rules", "CPUutilization_*=max, avg;*=last,last", []AggregationRule{
+			{Pattern: "CPUutilization_*", Aggregates: []Aggregate{AggregateMax, AggregateAvg}},
+			{Pattern: "*", Aggregates: []Aggregate{AggregateLast}},
+		}, false},
+		{"empty", "", nil, false},
+		{"no aggregates", "*", nil, true},
+		{"unknown aggregate", "*=median", nil, true},
+		{"bad pattern", "[=max", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAggregation(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAggregation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseAggregation() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v4/cpu"
+
+	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+)
+
+// procRoot is the mount point of procfs.
+const procRoot = "/proc"
+
+// cpuModes are the columns of the cpu lines of /proc/stat.
+//
+// guest and guest_nice are already counted in user and nice, so they are not added to the total time.
+var cpuModes = []string{"user", "nice", "system", "idle", "iowait", "irq", "softirq", "steal", "guest", "guest_nice"}
+
+// cpuTotalModes is the number of modes which make up the total time.
+const cpuTotalModes = 8
+
+// userHZ is the number of ticks per second in /proc/stat.
+const userHZ = 100
+
+// legacyCPUGauge is the name of the utilization of the second core in earlier versions.
+//
+// Deprecated: it is reported along with CPUutilization_cpu1 for one release, use CPUutilization_cpu1.
+const legacyCPUGauge = "CPUutilization1"
+
+// CPU collects the utilization of the host CPU from /proc/stat and /proc/loadavg.
+//
+// The utilization is the percentage of time since the previous collection, at the first collection since the boot.
+// It is reported for all cores together and for each core, in total and by mode:
+//
+//	CPUutilization_total
+//	CPUutilization_total_iowait
+//	CPUutilization_cpu0
+//	CPUutilization_cpu0_user
+//
+// The total utilization is the time not spent in idle and iowait.
+// The CPUutilization1 gauge of earlier versions, the utilization of the second core, is now CPUutilization_cpu1.
+// It is still reported with the old name for one release.
+// On Linux the collector also reports LoadAverage1, LoadAverage5, LoadAverage15 and the ContextSwitches counter.
+// Other systems have no procfs, there only the utilization is reported from the CPU times of gopsutil.
+type CPU struct {
+	root string
+	// portable reads the CPU times with gopsutil instead of procfs.
+	portable bool
+
+	mu   sync.Mutex
+	prev map[string][]uint64
+}
+
+// NewCPU returns the collector of the host CPU.
+func NewCPU() *CPU {
+	return &CPU{root: procRoot, portable: runtime.GOOS != "linux"}
+}
+
+func (*CPU) Name() string { return "cpu" }
+
+func (*CPU) Interval() time.Duration { return 0 }
+
+func (c *CPU) Collect(ctx context.Context) (api.MetricsList, error) {
+	if c.portable {
+		return c.collectPortable(ctx)
+	}
+	times, ctxt, err := readStat(filepath.Join(c.root, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	msgs := c.utilization(times)
+	msgs = append(msgs, counter("ContextSwitches", int64(ctxt)))
+
+	load, err := readLoadAvg(filepath.Join(c.root, "loadavg"))
+	if err != nil {
+		return msgs, err
+	}
+	for i, period := range []string{"1", "5", "15"} {
+		msgs = append(msgs, gauge("LoadAverage"+period, load[i]))
+	}
+	return msgs, nil
+}
+
+// collectPortable reports the utilization from the CPU times of gopsutil on systems without procfs.
+func (c *CPU) collectPortable(ctx context.Context) (api.MetricsList, error) {
+	total, err := cpu.TimesWithContext(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	perCore, err := cpu.TimesWithContext(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	times := make([]cpuTimes, 0, len(perCore)+1)
+	for i, t := range append(total, perCore...) {
+		name := t.CPU
+		if i < len(total) {
+			name = "cpu"
+		}
+		// Время в секундах переводится в тики, как в /proc/stat, доли тика на проценты не влияют.
+		ticks := make([]uint64, 0, len(cpuModes))
+		for _, sec := range []float64{t.User, t.Nice, t.System, t.Idle, t.Iowait, t.Irq, t.Softirq, t.Steal, t.Guest, t.GuestNice} {
+			ticks = append(ticks, uint64(sec*userHZ))
+		}
+		times = append(times, cpuTimes{name: name, ticks: ticks})
+	}
+	return c.utilization(times), nil
+}
+
+// utilization returns the gauges of the utilization since the previous call and keeps the times for the next one.
+func (c *CPU) utilization(times []cpuTimes) api.MetricsList {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var msgs api.MetricsList
+	for _, core := range times {
+		prev := c.prev[core.name]
+		delta := make([]uint64, len(core.ticks))
+		var total uint64
+		for i, t := range core.ticks {
+			// Счетчики ядра, ушедшего в офлайн, начинаются заново.
+			if i < len(prev) && prev[i] <= t {
+				t -= prev[i]
+			}
+			delta[i] = t
+			if i < cpuTotalModes {
+				total += t
+			}
+		}
+		if total == 0 {
+			continue
+		}
+		name := core.name
+		if name == "cpu" {
+			name = "total"
+		}
+		percent := func(ticks uint64) float64 { return float64(ticks) / float64(total) * 100 }
+		msgs = append(msgs, gauge(seriesName("CPUutilization", name), percent(total-delta[3]-delta[4])))
+		// Старое имя загрузки второго ядра сохраняется на один выпуск.
+		if name == "cpu1" {
+			msgs = append(msgs, gauge(legacyCPUGauge, percent(total-delta[3]-delta[4])))
+		}
+		for i, mode := range cpuModes[:len(delta)] {
+			msgs = append(msgs, gauge(seriesName("CPUutilization", name, mode), percent(delta[i])))
+		}
+	}
+	c.prev = make(map[string][]uint64, len(times))
+	for _, core := range times {
+		c.prev[core.name] = core.ticks
+	}
+	return msgs
+}
+
+// cpuTimes are the times of a cpu line of /proc/stat in ticks.
+type cpuTimes struct {
+	name  string
+	ticks []uint64
+}
+
+// readStat reads the times of all cores and the number of context switches from /proc/stat.
+func readStat(path string) (times []cpuTimes, ctxt uint64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	// Строка intr на многоядерных машинах длиннее буфера по умолчанию.
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(fields[0], "cpu"):
+			cpu := cpuTimes{name: fields[0]}
+			for _, f := range fields[1:min(len(fields), len(cpuModes)+1)] {
+				t, err := strconv.ParseUint(f, 10, 64)
+				if err != nil {
+					return nil, 0, fmt.Errorf("%s: %s: %w", path, fields[0], err)
+				}
+				cpu.ticks = append(cpu.ticks, t)
+			}
+			if len(cpu.ticks) < 5 {
+				return nil, 0, fmt.Errorf("%s: %s: too few columns", path, fields[0])
+			}
+			times = append(times, cpu)
+		case fields[0] == "ctxt":
+			if ctxt, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+				return nil, 0, fmt.Errorf("%s: ctxt: %w", path, err)
+			}
+		}
+	}
+	if err = sc.Err(); err != nil {
+		return nil, 0, err
+	}
+	return times, ctxt, nil
+}
+
+// readLoadAvg reads the load averages for 1, 5 and 15 minutes from /proc/loadavg.
+func readLoadAvg(path string) ([3]float64, error) {
+	var load [3]float64
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return load, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return load, fmt.Errorf("%s: too few columns", path)
+	}
+	for i := range load {
+		if load[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return load, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return load, nil
+}
@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles creates the files in a temporary directory and returns it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+const loadavg = "0.52 0.34 0.28 2/72 2200\n"
+
+func TestCPU_Collect(t *testing.T) {
+	first := writeFiles(t, map[string]string{
+		"stat": `cpu  100 0 100 700 100 0 0 0 0 0
+cpu0 50 0 50 350 50 0 0 0 0 0
+cpu1 50 0 50 350 50 0 0 0 0 0
+intr 1218387 0 0 0
+ctxt 1000
+btime 1727784000
+`,
+		"loadavg": loadavg,
+	})
+	second := writeFiles(t, map[string]string{
+		"stat": `cpu  160 0 120 790 130 0 0 0 0 0
+cpu0 110 0 60 350 80 0 0 0 0 0
+cpu1 50 0 60 440 50 0 0 0 0 0
+intr 1218400 0 0 0
+ctxt 1500
+btime 1727784000
+`,
+		"loadavg": loadavg,
+	})
+	c := &CPU{root: first}
+	msgs, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := values(msgs)
+	// Первый сбор считается от загрузки системы.
+	for name, want := range map[string]float64{
+		"CPUutilization_total":        20,
+		"CPUutilization_total_user":   10,
+		"CPUutilization_total_idle":   70,
+		"CPUutilization_total_iowait": 10,
+		"CPUutilization_cpu0":         20,
+		"CPUutilization_cpu1":         20,
+		"ContextSwitches":             1000,
+		"LoadAverage1":                0.52,
+		"LoadAverage5":                0.34,
+		"LoadAverage15":               0.28,
+	} {
+		if got[name] != want {
+			t.Errorf("%s = %v, want %v", name, got[name], want)
+		}
+	}
+
+	c.root = second
+	if msgs, err = c.Collect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got = values(msgs)
+	for name, want := range map[string]float64{
+		"CPUutilization_total":        40,
+		"CPUutilization_total_user":   30,
+		"CPUutilization_total_system": 10,
+		"CPUutilization_total_idle":   45,
+		"CPUutilization_cpu0":         70,
+		"CPUutilization_cpu0_user":    60,
+		"CPUutilization_cpu0_iowait":  30,
+		"CPUutilization_cpu1":         10,
+		"CPUutilization_cpu1_idle":    90,
+		"CPUutilization1":             10,
+		"ContextSwitches":             1500,
+	} {
+		if got[name] != want {
+			t.Errorf("%s = %v, want %v", name, got[name], want)
+		}
+	}
+}
+
+func TestCPU_Collect_portable(t *testing.T) {
+	c := &CPU{portable: true}
+	msgs, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := values(msgs)
+	total, ok := got["CPUutilization_total"]
+	if !ok || total < 0 || total > 100 {
+		t.Errorf("CPUutilization_total = %v, %v, want a percentage", total, ok)
+	}
+	if _, ok = got["CPUutilization_cpu0_user"]; !ok {
+		t.Errorf("no CPUutilization_cpu0_user in %v", got)
+	}
+}
+
+func TestCPU_Collect_singleCore(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"stat":    "cpu  10 0 10 80 0 0 0 0\ncpu0 10 0 10 80 0 0 0 0\nctxt 5\n",
+		"loadavg": loadavg,
+	})
+	msgs, err := (&CPU{root: root}).Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := values(msgs)
+	if got["CPUutilization_cpu0"] != 20 || got["CPUutilization_total_steal"] != 0 {
+		t.Errorf("Collect() = %v", got)
+	}
+	if _, ok := got["CPUutilization_cpu0_guest"]; ok {
+		t.Error("Collect() reports modes missing in /proc/stat")
+	}
+}
+
+func TestCPU_Collect_errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{"no stat", map[string]string{"loadavg": loadavg}},
+		{"bad stat", map[string]string{"stat": "cpu 1 2 x 4 5\n", "loadavg": loadavg}},
+		{"bad loadavg", map[string]string{"stat": "cpu 1 2 3 4 5\n", "loadavg": "high\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := (&CPU{root: writeFiles(t, tt.files)}).Collect(context.Background()); err == nil {
+				t.Error("Collect() error = nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v4/disk"
+
+	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+)
+
+// sectorSize is the size of the sectors in /proc/diskstats regardless of the device.
+const sectorSize = 512
+
+// Disk collects the usage of the mounted filesystems and the IO of the block devices.
+//
+// For each mountpoint selected by the filters it reports the gauges in bytes and inodes,
+// the mountpoint is the name suffix, / is named root:
+//
+//	DiskTotal_root, DiskUsed_root, DiskFree_root
+//	DiskInodesTotal_var_lib, DiskInodesUsed_var_lib, DiskInodesFree_var_lib
+//
+// Filesystems without blocks, like proc or sysfs, are skipped.
+// For each block device with IO since the boot it reports the counters:
+//
+//	DiskReadBytes_sda, DiskWriteBytes_sda, DiskReads_sda, DiskWrites_sda, DiskIOTime_sda
+//
+// DiskIOTime is the time in milliseconds the device was busy with IO.
+type Disk struct {
+	root        string
+	mountpoints Filter
+	fsTypes     Filter
+	usage       func(ctx context.Context, path string) (*disk.UsageStat, error)
+}
+
+// NewDisk returns the collector of the mountpoints and the filesystem types selected by the filters.
+func NewDisk(mountpoints, fsTypes Filter) *Disk {
+	return &Disk{root: procRoot, mountpoints: mountpoints, fsTypes: fsTypes, usage: disk.UsageWithContext}
+}
+
+func (*Disk) Name() string { return "disk" }
+
+func (*Disk) Interval() time.Duration { return 0 }
+
+func (d *Disk) Collect(ctx context.Context) (api.MetricsList, error) {
+	mounts, err := readMounts(filepath.Join(d.root, "self", "mounts"))
+	if err != nil {
+		return nil, err
+	}
+	var msgs api.MetricsList
+	var errs []error
+	for _, m := range mounts {
+		if !d.mountpoints.Match(m.mountpoint) || !d.fsTypes.Match(m.fsType) {
+			continue
+		}
+		usage, err := d.usage(ctx, m.mountpoint)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if usage.Total == 0 {
+			continue
+		}
+		name := strings.Trim(m.mountpoint, "/")
+		if name == "" {
+			name = "root"
+		}
+		msgs = append(msgs,
+			gauge(seriesName("DiskTotal", name), float64(usage.Total)),
+			gauge(seriesName("DiskUsed", name), float64(usage.Used)),
+			gauge(seriesName("DiskFree", name), float64(usage.Free)),
+			gauge(seriesName("DiskInodesTotal", name), float64(usage.InodesTotal)),
+			gauge(seriesName("DiskInodesUsed", name), float64(usage.InodesUsed)),
+			gauge(seriesName("DiskInodesFree", name), float64(usage.InodesFree)),
+		)
+	}
+
+	stats, err := readDiskStats(filepath.Join(d.root, "diskstats"))
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, s := range stats {
+		if s.reads == 0 && s.writes == 0 {
+			continue
+		}
+		msgs = append(msgs,
+			counter(seriesName("DiskReadBytes", s.device), int64(s.readSectors*sectorSize)),
+			counter(seriesName("DiskWriteBytes", s.device), int64(s.writeSectors*sectorSize)),
+			counter(seriesName("DiskReads", s.device), int64(s.reads)),
+			counter(seriesName("DiskWrites", s.device), int64(s.writes)),
+			counter(seriesName("DiskIOTime", s.device), int64(s.ioTime)),
+		)
+	}
+	return msgs, errors.Join(errs...)
+}
+
+// mount is a line of /proc/self/mounts.
+type mount struct {
+	mountpoint string
+	fsType     string
+}
+
+// readMounts reads the mounted filesystems, a mountpoint mounted several times is returned once.
+func readMounts(path string) ([]mount, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var mounts []mount
+	index := make(map[string]int)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		m := mount{mountpoint: unescapeMount(fields[1]), fsType: fields[2]}
+		// Виден только последний из смонтированных в одну точку.
+		if i, ok := index[m.mountpoint]; ok {
+			mounts[i] = m
+			continue
+		}
+		index[m.mountpoint] = len(mounts)
+		mounts = append(mounts, m)
+	}
+	return mounts, sc.Err()
+}
+
+// unescapeMount replaces the octal escapes of spaces and other characters in the mountpoint, e.g. \040.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// diskStats are the IO counters of a block device.
+type diskStats struct {
+	device       string
+	reads        uint64
+	readSectors  uint64
+	writes       uint64
+	writeSectors uint64
+	ioTime       uint64
+}
+
+// readDiskStats reads the IO counters of the block devices from /proc/diskstats.
+func readDiskStats(path string) ([]diskStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var stats []diskStats
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 14 {
+			continue
+		}
+		// Номера колонок из Documentation/admin-guide/iostats.rst без major, minor и имени устройства.
+		var values [11]uint64
+		for i := range values {
+			if values[i], err = strconv.ParseUint(fields[i+3], 10, 64); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", path, fields[2], err)
+			}
+		}
+		stats = append(stats, diskStats{
+			device:       fields[2],
+			reads:        values[0],
+			readSectors:  values[2],
+			writes:       values[4],
+			writeSectors: values[6],
+			ioTime:       values[9],
+		})
+	}
+	return stats, sc.Err()
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/shirou/gopsutil/v4/disk"
+)
+
+// fixtureDisk returns the disk collector of the fixture procfs with the usage of all filesystems.
+func fixtureDisk(t *testing.T, mountpoints, fsTypes Filter) (*Disk, *[]string) {
+	t.Helper()
+	var stated []string
+	d := NewDisk(mountpoints, fsTypes)
+	d.root = "testdata/proc"
+	d.usage = func(_ context.Context, path string) (*disk.UsageStat, error) {
+		stated = append(stated, path)
+		if path == "/proc" {
+			return &disk.UsageStat{}, nil
+		}
+		return &disk.UsageStat{Total: 1000, Used: 400, Free: 600, InodesTotal: 100, InodesUsed: 10, InodesFree: 90}, nil
+	}
+	return d, &stated
+}
+
+func newFilter(t *testing.T, include, exclude string) Filter {
+	t.Helper()
+	f, err := NewFilter(include, exclude)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestDisk_Collect(t *testing.T) {
+	d, _ := fixtureDisk(t, Filter{}, newFilter(t, "", "tmpfs,overlay"))
+	msgs, err := d.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := values(msgs)
+	for name, want := range map[string]float64{
+		"DiskTotal_root":                 1000,
+		"DiskUsed_root":                  400,
+		"DiskFree_var_lib_docker":        600,
+		"DiskInodesUsed_mnt_backup_disk": 10,
+		"DiskInodesFree_mnt_old":         90,
+		"DiskReadBytes_sda":              1482930 * 512,
+		"DiskWriteBytes_sda":             2291880 * 512,
+		"DiskReads_sda":                  12034,
+		"DiskWrites_sda1":                40201,
+		"DiskIOTime_sda":                 51020,
+		"DiskReadBytes_nvme0n1":          240000 * 512,
+		"DiskIOTime_nvme0n1":             1800,
+	} {
+		if got[name] != want {
+			t.Errorf("%s = %v, want %v", name, got[name], want)
+		}
+	}
+	for name := range got {
+		for _, skipped := range []string{"_proc", "_run", "overlay2", "loop0"} {
+			if strings.Contains(name, skipped) {
+				t.Errorf("%s is reported", name)
+			}
+		}
+	}
+	for _, m := range msgs {
+		if err := m.Validate(); err != nil {
+			t.Errorf("%s: %v", m.ID, err)
+		}
+	}
+}
+
+func TestDisk_Collect_filters(t *testing.T) {
+	tests := []struct {
+		name        string
+		mountpoints Filter
+		fsTypes     Filter
+		want        []string
+	}{
+		{"all", Filter{}, Filter{}, []string{"/", "/proc", "/run", "/var/lib/docker", "/var/lib/docker/overlay2/4f1c/merged", "/mnt/backup disk", "/mnt/old"}},
+		{"include mountpoints", newFilter(t, "/,/mnt/*", ""), Filter{}, []string{"/", "/mnt/backup disk", "/mnt/old"}},
+		{"exclude mountpoints", newFilter(t, "", "/mnt/*,/proc"), newFilter(t, "", "tmpfs,overlay"), []string{"/", "/var/lib/docker"}},
+		{"include fs types", Filter{}, newFilter(t, "ext*", ""), []string{"/", "/mnt/backup disk", "/mnt/old"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, stated := fixtureDisk(t, tt.mountpoints, tt.fsTypes)
+			if _, err := d.Collect(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(*stated, tt.want) {
+				t.Errorf("mountpoints = %q, want %q", *stated, tt.want)
+			}
+		})
+	}
+}
+
+func TestDisk_Collect_partial(t *testing.T) {
+	d, _ := fixtureDisk(t, newFilter(t, "/", ""), Filter{})
+	errDenied := errors.New("permission denied")
+	d.usage = func(context.Context, string) (*disk.UsageStat, error) { return nil, errDenied }
+	msgs, err := d.Collect(context.Background())
+	if !errors.Is(err, errDenied) {
+		t.Errorf("Collect() error = %v, want %v", err, errDenied)
+	}
+	if got := values(msgs); got["DiskReads_sda"] != 12034 {
+		t.Errorf("Collect() without usage = %v", got)
+	}
+}
+
+func TestNewFilter(t *testing.T) {
+	if _, err := NewFilter("[", ""); err == nil {
+		t.Error("NewFilter() with bad pattern error = nil")
+	}
+	f := newFilter(t, "eth*, wlan0", "eth1")
+	for name, want := range map[string]bool{"eth0": true, "eth1": false, "wlan0": true, "lo": false} {
+		if got := f.Match(name); got != want {
+			t.Errorf("Match(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
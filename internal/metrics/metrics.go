@@ -1,124 +1,71 @@
+// Package metrics contains the collectors of the agent metrics.
 package metrics
 
 import (
+	"context"
 	"math/rand"
-	"runtime"
-	"sync"
+	"time"
 
-	"log/slog"
-
-	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/v4/mem"
 
 	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
-	"github.com/xoxloviwan/go-monitor/internal/store"
 )
 
-// MetricsPool is a pool of metrics.
-//
-// It provides methods for getting metrics and making messages.
-type MetricsPool store.MemStorage
+// Options configures the builtin collectors.
+type Options struct {
+	// RuntimeMemStats adds the names of runtime.MemStats fields to the metrics of the runtime collector.
+	RuntimeMemStats bool
+	// DiskMountpoints selects the mountpoints of the disk collector.
+	DiskMountpoints Filter
+	// DiskFSTypes selects the filesystem types of the disk collector.
+	DiskFSTypes Filter
+	// NetInterfaces selects the interfaces of the network collector.
+	NetInterfaces Filter
+	// Processes are the processes watched by the process collector.
+	Processes []ProcessMatcher
+	// Cgroups are the paths of the cgroups of the cgroup collector, no paths means the own cgroup.
+	Cgroups []string
+}
+
+// Builtin returns the collectors of the agent.
+func Builtin(opts Options) []Collector {
+	return []Collector{
+		NewRuntime(opts.RuntimeMemStats),
+		Memory{},
+		NewCPU(),
+		NewDisk(opts.DiskMountpoints, opts.DiskFSTypes),
+		NewNet(opts.NetInterfaces),
+		NewProcess(opts.Processes),
+		NewCgroup(opts.Cgroups),
+		Random{},
+	}
+}
 
-// GetMetrics returns a new MetricsPool instance.
-//
-// The instance is initialized with the given poll count.
-func GetMetrics(PollCount int64) *MetricsPool {
-	var wg sync.WaitGroup
-	var cpuUtilization1 float64
-	var MemStats runtime.MemStats
-	var vMem *mem.VirtualMemoryStat
-	wg.Add(3)
+// Memory collects the virtual memory of the host.
+type Memory struct{}
 
-	go func() {
-		runtime.ReadMemStats(&MemStats)
-		wg.Done()
-	}()
+func (Memory) Name() string { return "memory" }
 
-	go func() {
-		cpuUtilization, err := cpu.Percent(0, true) // вернет слайс с нагрузкой каждого ядра
-		if err != nil {
-			slog.Error("Getting cpu utilization failed", "error", err)
-			wg.Done()
-			return
-		}
-		cpuUtilization1 = cpuUtilization[1]
-		wg.Done()
-	}()
+func (Memory) Interval() time.Duration { return 0 }
 
-	go func() {
-		var err error
-		vMem, err = mem.VirtualMemory()
-		if err != nil {
-			slog.Error("Getting virtual memory failed:", "error", err)
-		}
-		wg.Done()
-	}()
-	wg.Wait()
-	return &MetricsPool{
-		Gauge: map[string]float64{
-			"Alloc":           float64(MemStats.Alloc),
-			"BuckHashSys":     float64(MemStats.BuckHashSys),
-			"Frees":           float64(MemStats.Frees),
-			"GCCPUFraction":   MemStats.GCCPUFraction,
-			"GCSys":           float64(MemStats.GCSys),
-			"HeapAlloc":       float64(MemStats.HeapAlloc),
-			"HeapIdle":        float64(MemStats.HeapIdle),
-			"HeapInuse":       float64(MemStats.HeapInuse),
-			"HeapObjects":     float64(MemStats.HeapObjects),
-			"HeapReleased":    float64(MemStats.HeapReleased),
-			"HeapSys":         float64(MemStats.HeapSys),
-			"LastGC":          float64(MemStats.LastGC),
-			"Lookups":         float64(MemStats.Lookups),
-			"MCacheInuse":     float64(MemStats.MCacheInuse),
-			"MCacheSys":       float64(MemStats.MCacheSys),
-			"MSpanInuse":      float64(MemStats.MSpanInuse),
-			"MSpanSys":        float64(MemStats.MSpanSys),
-			"Mallocs":         float64(MemStats.Mallocs),
-			"NextGC":          float64(MemStats.NextGC),
-			"NumForcedGC":     float64(MemStats.NumForcedGC),
-			"NumGC":           float64(MemStats.NumGC),
-			"OtherSys":        float64(MemStats.OtherSys),
-			"PauseTotalNs":    float64(MemStats.PauseTotalNs),
-			"StackInuse":      float64(MemStats.StackInuse),
-			"StackSys":        float64(MemStats.StackSys),
-			"Sys":             float64(MemStats.Sys),
-			"TotalAlloc":      float64(MemStats.TotalAlloc),
-			"TotalMemory":     float64(vMem.Total),
-			"FreeMemory":      float64(vMem.Free),
-			"CPUutilization1": cpuUtilization1,
-			"RandomValue":     rand.Float64(),
-		},
-		Counter: map[string]int64{
-			"PollCount": PollCount,
-		},
+func (Memory) Collect(ctx context.Context) (api.MetricsList, error) {
+	vMem, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return nil, err
 	}
+	return api.MetricsList{
+		gauge("TotalMemory", float64(vMem.Total)),
+		gauge("FreeMemory", float64(vMem.Free)),
+	}, nil
 }
 
-// MakeMessages returns a channel of metrics messages.
-//
-// The channel is populated with messages from the MetricsPool instance.
-func (s *MetricsPool) MakeMessages() chan api.Metrics {
-	ch := make(chan api.Metrics)
-	// через отдельную горутину генератор отправляет данные в канал
-	go func() {
-		// закрываем канал по завершению горутины — это отправитель
-		defer close(ch)
+// Random reports a random value, which shows that the agent is alive.
+type Random struct{}
+
+func (Random) Name() string { return "random" }
 
-		for metricName, metricValue := range s.Gauge {
-			ch <- api.Metrics{
-				ID:    metricName,
-				MType: api.GaugeName,
-				Value: &metricValue,
-			}
-		}
-		for metricName, metricValue := range s.Counter {
-			ch <- api.Metrics{
-				ID:    metricName,
-				MType: api.CounterName,
-				Delta: &metricValue,
-			}
-		}
-	}()
+func (Random) Interval() time.Duration { return 0 }
 
-	return ch
+func (Random) Collect(context.Context) (api.MetricsList, error) {
+	return api.MetricsList{gauge("RandomValue", rand.Float64())}, nil
 }
@@ -1,9 +1,13 @@
 package metrics_test
 
 import (
+	"context"
+	"runtime"
 	"testing"
+	"time"
 
 	m "github.com/xoxloviwan/go-monitor/internal/metrics"
+	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
 )
 
 var gauges = []string{
@@ -19,6 +23,7 @@ var gauges = []string{
 	"HeapReleased",
 	"HeapSys",
 	"LastGC",
+	"LastGCSeconds",
 	"Lookups",
 	"MCacheInuse",
 	"MCacheSys",
@@ -36,32 +41,64 @@ var gauges = []string{
 	"TotalAlloc",
 	"TotalMemory",
 	"FreeMemory",
-	"CPUutilization1",
 	"RandomValue",
 }
 
-func TestGetMetrics(t *testing.T) {
-	var cnt int64 = 1
-	metrics := m.GetMetrics(cnt)
+// poll returns the metrics of the builtin collectors after one poll.
+func poll(t *testing.T, collectors string) map[string]api.Metrics {
+	t.Helper()
+	specs, err := m.ParseSpecs(collectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := m.NewRegistry(time.Second, specs, m.Builtin(m.Options{RuntimeMemStats: true})...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.Poll(context.Background())
+	res := make(map[string]api.Metrics)
+	for _, msg := range reg.Metrics() {
+		res[msg.ID] = msg
+	}
+	return res
+}
+
+func TestBuiltin(t *testing.T) {
+	metrics := poll(t, "runtime,memory,random")
 
 	for _, gauge := range gauges {
-		if _, ok := metrics.Gauge[gauge]; !ok {
+		if msg, ok := metrics[gauge]; !ok || msg.MType != api.GaugeName {
 			t.Errorf("%s not exist", gauge)
 		}
 	}
-	if metrics.Counter["PollCount"] != cnt {
+	if msg := metrics["PollCount"]; msg.Delta == nil || *msg.Delta != 1 {
 		t.Errorf("PollCount wrong")
 	}
 }
 
+func TestBuiltin_cpu(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("procfs is not available")
+	}
+	metrics := poll(t, "cpu")
+	for _, name := range []string{"CPUutilization_total", "CPUutilization_cpu0_user", "LoadAverage1", "ContextSwitches"} {
+		if _, ok := metrics[name]; !ok {
+			t.Errorf("%s not exist", name)
+		}
+	}
+	if _, ok := metrics["go_monitor_collector_errors_total_cpu"]; ok {
+		t.Error("cpu collector failed")
+	}
+}
+
 func TestMakeMessage(t *testing.T) {
-	mp := m.GetMetrics(1)
-	ch := mp.MakeMessages()
+	msgs := api.MetricsList{{ID: "Alloc", MType: api.GaugeName}, {ID: "PollCount", MType: api.CounterName}}
+	ch := m.MakeMessages(msgs)
+	var got []string
 	for msg := range ch {
-		if msg.ID == "Alloc" {
-			if msg.MType != "gauge" {
-				t.Errorf("wrong MType %s", msg.MType)
-			}
-		}
+		got = append(got, msg.ID)
+	}
+	if len(got) != 2 || got[0] != "Alloc" || got[1] != "PollCount" {
+		t.Errorf("MakeMessages() = %v", got)
 	}
 }
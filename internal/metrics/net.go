@@ -0,0 +1,213 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+)
+
+// netDevCounters are the names of the columns of /proc/net/dev reported by the network collector.
+var netDevCounters = map[int]string{
+	0:  "NetRxBytes",
+	1:  "NetRxPackets",
+	2:  "NetRxErrors",
+	3:  "NetRxDrops",
+	8:  "NetTxBytes",
+	9:  "NetTxPackets",
+	10: "NetTxErrors",
+	11: "NetTxDrops",
+}
+
+// tcpStates are the states of TCP connections in /proc/net/tcp by their numbers.
+var tcpStates = []string{
+	1:  "established",
+	2:  "syn_sent",
+	3:  "syn_recv",
+	4:  "fin_wait1",
+	5:  "fin_wait2",
+	6:  "time_wait",
+	7:  "close",
+	8:  "close_wait",
+	9:  "last_ack",
+	10: "listen",
+	11: "closing",
+}
+
+// tcpCounters are the counters of the Tcp line of /proc/net/snmp reported by the network collector.
+var tcpCounters = []string{"ActiveOpens", "PassiveOpens", "AttemptFails", "EstabResets", "RetransSegs", "InErrs", "OutRsts"}
+
+// Net collects the traffic of the network interfaces and the TCP connections of the agent network namespace.
+//
+// For each interface selected by the filter it reports the counters:
+//
+//	NetRxBytes_eth0, NetRxPackets_eth0, NetRxErrors_eth0, NetRxDrops_eth0
+//	NetTxBytes_eth0, NetTxPackets_eth0, NetTxErrors_eth0, NetTxDrops_eth0
+//
+// The number of TCP connections over IPv4 and IPv6 is reported for each state, e.g. TCPConnections_time_wait,
+// and the counters of /proc/net/snmp, e.g. TCPRetransSegs.
+type Net struct {
+	root       string
+	interfaces Filter
+}
+
+// NewNet returns the collector of the interfaces selected by the filter.
+func NewNet(interfaces Filter) *Net {
+	return &Net{root: procRoot, interfaces: interfaces}
+}
+
+func (*Net) Name() string { return "net" }
+
+func (*Net) Interval() time.Duration { return 0 }
+
+func (n *Net) Collect(context.Context) (api.MetricsList, error) {
+	var msgs api.MetricsList
+	var errs []error
+	devs, err := readNetDev(filepath.Join(n.root, "net", "dev"))
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, dev := range devs {
+		if !n.interfaces.Match(dev.name) {
+			continue
+		}
+		for i, value := range dev.values {
+			if name, ok := netDevCounters[i]; ok {
+				msgs = append(msgs, counter(seriesName(name, dev.name), int64(value)))
+			}
+		}
+	}
+
+	states := make([]int64, len(tcpStates))
+	for _, file := range []string{"tcp", "tcp6"} {
+		err := countTCPStates(filepath.Join(n.root, "net", file), states)
+		// Без поддержки IPv6 файла tcp6 нет.
+		if err != nil && !(file == "tcp6" && errors.Is(err, fs.ErrNotExist)) {
+			errs = append(errs, err)
+		}
+	}
+	for i, state := range tcpStates {
+		if state != "" {
+			msgs = append(msgs, gauge(seriesName("TCPConnections", state), float64(states[i])))
+		}
+	}
+
+	tcp, err := readSNMP(filepath.Join(n.root, "net", "snmp"), "Tcp")
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, name := range tcpCounters {
+		if value, ok := tcp[name]; ok {
+			msgs = append(msgs, counter("TCP"+name, value))
+		}
+	}
+	return msgs, errors.Join(errs...)
+}
+
+// netDev are the counters of an interface in /proc/net/dev.
+type netDev struct {
+	name   string
+	values []uint64
+}
+
+// readNetDev reads the counters of the interfaces from /proc/net/dev.
+func readNetDev(path string) ([]netDev, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var devs []netDev
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// Первые две строки - заголовок, в них нет двоеточия.
+		name, data, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		dev := netDev{name: strings.TrimSpace(name)}
+		for _, field := range strings.Fields(data) {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", path, dev.name, err)
+			}
+			dev.values = append(dev.values, value)
+		}
+		if len(dev.values) < 16 {
+			return nil, fmt.Errorf("%s: %s: too few columns", path, dev.name)
+		}
+		devs = append(devs, dev)
+	}
+	return devs, sc.Err()
+}
+
+// countTCPStates adds the number of connections in each state of /proc/net/tcp or /proc/net/tcp6 to states.
+func countTCPStates(path string, states []int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		// Строка заголовка начинается с sl.
+		if len(fields) < 4 || fields[0] == "sl" {
+			continue
+		}
+		state, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if int(state) < len(states) {
+			states[state]++
+		}
+	}
+	return sc.Err()
+}
+
+// readSNMP reads the values of the protocol from /proc/net/snmp, where a line of names is followed by a line of values.
+func readSNMP(path, proto string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	prefix := proto + ":"
+	var names []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || fields[0] != prefix {
+			continue
+		}
+		if names == nil {
+			names = fields[1:]
+			continue
+		}
+		if len(fields)-1 != len(names) {
+			return nil, fmt.Errorf("%s: %s: %d names, %d values", path, proto, len(names), len(fields)-1)
+		}
+		values := make(map[string]int64, len(names))
+		for i, name := range names {
+			value, err := strconv.ParseInt(fields[i+1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", path, name, err)
+			}
+			values[name] = value
+		}
+		return values, nil
+	}
+	if err = sc.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%s: no %s values", path, proto)
+}
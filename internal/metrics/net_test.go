@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNet_Collect(t *testing.T) {
+	n := NewNet(newFilter(t, "", "lo"))
+	n.root = "testdata/proc"
+	msgs, err := n.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := values(msgs)
+	for name, want := range map[string]float64{
+		"NetRxBytes_eth0":            2769855,
+		"NetRxPackets_eth0":          292,
+		"NetRxErrors_eth0":           3,
+		"NetRxDrops_eth0":            1,
+		"NetTxBytes_eth0":            41205,
+		"NetTxPackets_eth0":          369,
+		"NetTxErrors_eth0":           2,
+		"NetTxDrops_eth0":            4,
+		"NetRxBytes_docker0":         1500,
+		"NetTxPackets_docker0":       20,
+		"TCPConnections_listen":      3,
+		"TCPConnections_established": 2,
+		"TCPConnections_time_wait":   1,
+		"TCPConnections_close_wait":  0,
+		"TCPActiveOpens":             75,
+		"TCPRetransSegs":             12,
+		"TCPOutRsts":                 16,
+	} {
+		if v, ok := got[name]; !ok || v != want {
+			t.Errorf("%s = %v, want %v", name, v, want)
+		}
+	}
+	for _, m := range msgs {
+		if strings.HasSuffix(m.ID, "_lo") {
+			t.Errorf("%s of the excluded interface is reported", m.ID)
+		}
+		wantType := "counter"
+		if strings.HasPrefix(m.ID, "TCPConnections_") {
+			wantType = "gauge"
+		}
+		if m.MType != wantType {
+			t.Errorf("%s has type %s, want %s", m.ID, m.MType, wantType)
+		}
+	}
+}
+
+func TestNet_Collect_noIPv6(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"net/dev":  "Inter-|\n face |\n  eth0: 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16\n",
+		"net/tcp":  "  sl  local_address rem_address   st\n   0: 0100007F:BC8F 00000000:0000 0A\n",
+		"net/snmp": "Tcp: ActiveOpens RetransSegs\nTcp: 5 6\n",
+	})
+	msgs, err := (&Net{root: root}).Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := values(msgs)
+	if got["TCPConnections_listen"] != 1 || got["NetTxBytes_eth0"] != 9 || got["TCPRetransSegs"] != 6 {
+		t.Errorf("Collect() = %v", got)
+	}
+}
+
+func TestNet_Collect_errors(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"net/dev":  "Inter-|\n face |\n  eth0: 1 2 3\n",
+		"net/tcp":  "",
+		"net/snmp": "Tcp: ActiveOpens\nTcp: 5\n",
+	})
+	msgs, err := (&Net{root: root}).Collect(context.Background())
+	if err == nil {
+		t.Error("Collect() error = nil")
+	}
+	if got := values(msgs); got["TCPActiveOpens"] != 5 {
+		t.Errorf("Collect() = %v, want TCP metrics despite the error", got)
+	}
+}
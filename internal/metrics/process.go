@@ -0,0 +1,295 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v4/process"
+
+	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+)
+
+// cgroupRoot is the mount point of the cgroup v2 hierarchy.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// ProcessMatcher selects the watched processes reported under one label.
+// Exactly one of Name, PIDFile and Cgroup is set.
+type ProcessMatcher struct {
+	Label string
+	// Name matches the process names.
+	Name *regexp.Regexp
+	// PIDFile is the path to the file with the process ID.
+	PIDFile string
+	// Cgroup is the path of the cgroup of the processes in the cgroup v2 hierarchy.
+	Cgroup string
+}
+
+// ParseProcessMatchers parses a semicolon-separated list of watched processes.
+//
+// Each element is a label and a matcher by name regexp, pidfile or cgroup:
+//
+//	nginx=name:^nginx$;db=pidfile:/run/postgresql.pid;app=cgroup:/system.slice/app.service
+func ParseProcessMatchers(s string) ([]ProcessMatcher, error) {
+	var matchers []ProcessMatcher
+	for _, elem := range strings.Split(s, ";") {
+		elem = strings.TrimSpace(elem)
+		if elem == "" {
+			continue
+		}
+		label, matcher, ok := strings.Cut(elem, "=")
+		kind, value, ok2 := strings.Cut(matcher, ":")
+		if !ok || !ok2 || label == "" || value == "" {
+			return nil, fmt.Errorf("process %q: want label=name:regexp, label=pidfile:path or label=cgroup:path", elem)
+		}
+		m := ProcessMatcher{Label: label}
+		switch kind {
+		case "name":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("process %q: %w", label, err)
+			}
+			m.Name = re
+		case "pidfile":
+			m.PIDFile = value
+		case "cgroup":
+			m.Cgroup = value
+		default:
+			return nil, fmt.Errorf("process %q: unknown matcher %q", label, kind)
+		}
+		if slices.ContainsFunc(matchers, func(m ProcessMatcher) bool { return m.Label == label }) {
+			return nil, fmt.Errorf("process %q is watched twice", label)
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+// procKey identifies a process, the creation time distinguishes processes with a reused ID.
+type procKey struct {
+	pid     int32
+	created int64
+}
+
+// processCPU is the CPU time of the processes of a label.
+type processCPU struct {
+	// total is the CPU time in seconds of all processes ever matched since the agent start.
+	total float64
+	prev  map[procKey]float64
+}
+
+// Process collects the resources used by the watched processes.
+//
+// The processes of a label are reported together, the label is the name suffix:
+//
+//	ProcessCount_nginx, ProcessCPUTime_nginx, ProcessRSS_nginx, ProcessFDs_nginx, ProcessThreads_nginx, ProcessUptime_nginx
+//
+// ProcessCPUTime is the counter of user and system time in milliseconds, ProcessRSS is in bytes
+// and ProcessUptime is the time in seconds since the start of the oldest process.
+// The series do not depend on the process IDs, so restarted processes do not leave stale series.
+// The CPU time of exited processes stays in the counter, so it does not go back.
+// While no process matches, the gauges are reported as zero, so the server does not keep the values of exited processes.
+type Process struct {
+	cgroupRoot string
+	matchers   []ProcessMatcher
+	now        func() time.Time
+
+	mu  sync.Mutex
+	cpu map[string]*processCPU
+}
+
+// NewProcess returns the collector of the processes selected by the matchers.
+func NewProcess(matchers []ProcessMatcher) *Process {
+	return &Process{cgroupRoot: cgroupRoot, matchers: matchers, now: time.Now, cpu: make(map[string]*processCPU)}
+}
+
+func (*Process) Name() string { return "process" }
+
+func (*Process) Interval() time.Duration { return 0 }
+
+func (p *Process) Collect(ctx context.Context) (api.MetricsList, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var msgs api.MetricsList
+	var errs []error
+	var names map[int32]string
+	for _, m := range p.matchers {
+		var pids []int32
+		var err error
+		switch {
+		case m.Name != nil:
+			if names == nil {
+				if names, err = processNames(ctx); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+			}
+			for pid, name := range names {
+				if m.Name.MatchString(name) {
+					pids = append(pids, pid)
+				}
+			}
+		case m.PIDFile != "":
+			pids, err = readPIDFile(m.PIDFile)
+		default:
+			pids, err = readPIDs(filepath.Join(p.cgroupRoot, m.Cgroup, "cgroup.procs"))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("process %s: %w", m.Label, err))
+		}
+		metrics, err := p.collect(ctx, m.Label, pids)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("process %s: %w", m.Label, err))
+		}
+		msgs = append(msgs, metrics...)
+	}
+	return msgs, errors.Join(errs...)
+}
+
+// collect returns the metrics of the processes of the label.
+func (p *Process) collect(ctx context.Context, label string, pids []int32) (api.MetricsList, error) {
+	cpu := p.cpu[label]
+	if cpu == nil {
+		cpu = &processCPU{}
+		p.cpu[label] = cpu
+	}
+	var errs []error
+	var count, rss, fds, threads int64
+	var oldest int64
+	seen := make(map[procKey]float64, len(pids))
+	for _, pid := range pids {
+		proc, err := process.NewProcessWithContext(ctx, pid)
+		if err != nil {
+			// Процесс завершился после получения списка.
+			continue
+		}
+		created, err := proc.CreateTimeWithContext(ctx)
+		if err != nil {
+			errs = append(errs, gone(err))
+			continue
+		}
+		times, err := proc.TimesWithContext(ctx)
+		if err != nil {
+			errs = append(errs, gone(err))
+			continue
+		}
+		key := procKey{pid: pid, created: created}
+		used := times.User + times.System
+		seen[key] = used
+		// Время нового процесса учитывается с его запуска.
+		cpu.total += used - min(cpu.prev[key], used)
+
+		count++
+		if oldest == 0 || created < oldest {
+			oldest = created
+		}
+		if mem, err := proc.MemoryInfoWithContext(ctx); err == nil {
+			rss += int64(mem.RSS)
+		} else {
+			errs = append(errs, gone(err))
+		}
+		if n, err := proc.NumFDsWithContext(ctx); err == nil {
+			fds += int64(n)
+		} else {
+			errs = append(errs, gone(err))
+		}
+		if n, err := proc.NumThreadsWithContext(ctx); err == nil {
+			threads += int64(n)
+		} else {
+			errs = append(errs, gone(err))
+		}
+	}
+	// Завершившиеся процессы забываются.
+	cpu.prev = seen
+
+	var uptime float64
+	if count > 0 {
+		uptime = max(p.now().Sub(time.UnixMilli(oldest)).Seconds(), 0)
+	}
+	msgs := api.MetricsList{
+		gauge(seriesName("ProcessCount", label), float64(count)),
+		counter(seriesName("ProcessCPUTime", label), int64(cpu.total*1000)),
+		gauge(seriesName("ProcessRSS", label), float64(rss)),
+		gauge(seriesName("ProcessFDs", label), float64(fds)),
+		gauge(seriesName("ProcessThreads", label), float64(threads)),
+		gauge(seriesName("ProcessUptime", label), uptime),
+	}
+	return msgs, errors.Join(errs...)
+}
+
+// gone drops the errors of processes which exited during the collection.
+func gone(err error) error {
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, process.ErrorProcessNotRunning) {
+		return nil
+	}
+	return err
+}
+
+// processNames returns the names of all processes.
+func processNames(ctx context.Context) (map[int32]string, error) {
+	pids, err := process.PidsWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int32]string, len(pids))
+	for _, pid := range pids {
+		proc, err := process.NewProcessWithContext(ctx, pid)
+		if err != nil {
+			continue
+		}
+		if name, err := proc.NameWithContext(ctx); err == nil {
+			names[pid] = name
+		}
+	}
+	return names, nil
+}
+
+// readPIDFile reads the process ID from the pidfile.
+// A missing pidfile means that the process is not running.
+func readPIDFile(path string) ([]int32, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return []int32{int32(pid)}, nil
+}
+
+// readPIDs reads the process IDs, one per line, e.g. from cgroup.procs.
+func readPIDs(path string) ([]int32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var pids []int32
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		pid, err := strconv.ParseInt(line, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		pids = append(pids, int32(pid))
+	}
+	return pids, sc.Err()
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestParseProcessMatchers(t *testing.T) {
+	got, err := ParseProcessMatchers("nginx=name:^nginx(-worker)?$; db=pidfile:/run/postgresql.pid;app=cgroup:/system.slice/app.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("ParseProcessMatchers() = %+v", got)
+	}
+	if got[0].Label != "nginx" || got[0].Name == nil || !got[0].Name.MatchString("nginx-worker") {
+		t.Errorf("name matcher = %+v", got[0])
+	}
+	if got[1].Label != "db" || got[1].PIDFile != "/run/postgresql.pid" {
+		t.Errorf("pidfile matcher = %+v", got[1])
+	}
+	if got[2].Label != "app" || got[2].Cgroup != "/system.slice/app.service" {
+		t.Errorf("cgroup matcher = %+v", got[2])
+	}
+
+	for _, s := range []string{"nginx", "nginx=name", "nginx=name:(", "nginx=exe:/usr/sbin/nginx", "a=pidfile:/a.pid;a=pidfile:/b.pid"} {
+		if _, err := ParseProcessMatchers(s); err == nil {
+			t.Errorf("ParseProcessMatchers(%q) error = nil", s)
+		}
+	}
+}
+
+func TestProcess_Collect(t *testing.T) {
+	dir := t.TempDir()
+	pid := strconv.Itoa(os.Getpid())
+	pidfile := filepath.Join(dir, "agent.pid")
+	if err := os.WriteFile(pidfile, []byte(pid+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Второй процесс группы уже завершился.
+	root := writeFiles(t, map[string]string{"app/cgroup.procs": pid + "\n4194305\n"})
+	name, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewProcess([]ProcessMatcher{
+		{Label: "by-pidfile", PIDFile: pidfile},
+		{Label: "by_cgroup", Cgroup: "app"},
+		{Label: "by_name", Name: regexp.MustCompile("^" + regexp.QuoteMeta(filepath.Base(name)) + "$")},
+		{Label: "missing", PIDFile: filepath.Join(dir, "missing.pid")},
+	})
+	p.cgroupRoot = root
+
+	msgs, err := p.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := values(msgs)
+	for _, label := range []string{"by-pidfile", "by_cgroup", "by_name"} {
+		if got["ProcessCount_"+label] != 1 {
+			t.Errorf("ProcessCount_%s = %v, want 1", label, got["ProcessCount_"+label])
+		}
+		for _, name := range []string{"ProcessRSS_", "ProcessFDs_", "ProcessThreads_"} {
+			if got[name+label] <= 0 {
+				t.Errorf("%s%s = %v", name, label, got[name+label])
+			}
+		}
+		if _, ok := got["ProcessUptime_"+label]; !ok {
+			t.Errorf("ProcessUptime_%s not exist", label)
+		}
+	}
+	if got["ProcessCount_missing"] != 0 {
+		t.Errorf("ProcessCount_missing = %v, want 0", got["ProcessCount_missing"])
+	}
+	if v, ok := got["ProcessRSS_missing"]; !ok || v != 0 {
+		t.Errorf("ProcessRSS_missing = %v, %v, want 0 without processes", v, ok)
+	}
+	cpuTime := got["ProcessCPUTime_by-pidfile"]
+
+	// Процесс завершился: датчики обнуляются, а счетчик не уменьшается.
+	if err = os.Remove(pidfile); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, err = p.Collect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got = values(msgs)
+	if got["ProcessCount_by-pidfile"] != 0 || got["ProcessCPUTime_by-pidfile"] != cpuTime {
+		t.Errorf("after exit count = %v, cpu time = %v, want 0 and %v", got["ProcessCount_by-pidfile"], got["ProcessCPUTime_by-pidfile"], cpuTime)
+	}
+	for _, name := range []string{"ProcessRSS_by-pidfile", "ProcessFDs_by-pidfile", "ProcessThreads_by-pidfile", "ProcessUptime_by-pidfile"} {
+		if v, ok := got[name]; !ok || v != 0 {
+			t.Errorf("%s = %v, %v after exit, want 0", name, v, ok)
+		}
+	}
+	if len(p.cpu["by-pidfile"].prev) != 0 {
+		t.Errorf("exited processes are kept: %v", p.cpu["by-pidfile"].prev)
+	}
+}
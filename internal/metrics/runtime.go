@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"context"
+	"math"
+	"runtime/debug"
+	rtmetrics "runtime/metrics"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+)
+
+// histogramQuantiles are the quantiles reported for the histograms of runtime/metrics.
+var histogramQuantiles = []struct {
+	suffix string
+	q      float64
+}{{"p50", 0.5}, {"p90", 0.9}, {"p99", 0.99}}
+
+// Runtime collects the metrics of the Go runtime of the agent from runtime/metrics, without stopping the world.
+//
+// All supported samples are reported, the name of a sample is converted to the name of the metric:
+//
+//	/gc/heap/allocs:bytes -> go_gc_heap_allocs_bytes
+//
+// Cumulative integer samples are counters, the other samples are gauges.
+// A histogram, e.g. /gc/pauses:seconds or /sched/latencies:seconds, is reported with
+// the counter of observations go_gc_pauses_seconds_count and the quantiles of the observations
+// since the previous collection: go_gc_pauses_seconds_p50, _p90 and _p99. The quantiles are zero without observations.
+// The time of the last garbage collection is reported as go_gc_last_timestamp_seconds.
+//
+// With the legacy option the collector also reports the gauges with the names of runtime.MemStats fields,
+// e.g. Alloc or NumGC, which are computed from the same samples.
+// The LastGC gauge is deprecated: it holds Unix nanoseconds, which lose precision in a float64 gauge.
+// Use LastGCSeconds or go_gc_last_timestamp_seconds instead.
+type Runtime struct {
+	legacy     bool
+	cumulative map[string]bool
+
+	mu      sync.Mutex
+	samples []rtmetrics.Sample
+	// prev are the counts of the histograms at the previous collection.
+	prev map[string][]uint64
+}
+
+// NewRuntime returns the collector of the Go runtime, legacy adds the names of runtime.MemStats fields.
+func NewRuntime(legacy bool) *Runtime {
+	descs := rtmetrics.All()
+	samples := make([]rtmetrics.Sample, len(descs))
+	cumulative := make(map[string]bool, len(descs))
+	for i, d := range descs {
+		samples[i].Name = d.Name
+		cumulative[d.Name] = d.Cumulative
+	}
+	return &Runtime{legacy: legacy, cumulative: cumulative, samples: samples, prev: make(map[string][]uint64)}
+}
+
+func (*Runtime) Name() string { return "runtime" }
+
+func (*Runtime) Interval() time.Duration { return 0 }
+
+func (r *Runtime) Collect(context.Context) (api.MetricsList, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rtmetrics.Read(r.samples)
+	var gcStats debug.GCStats
+	debug.ReadGCStats(&gcStats)
+
+	var msgs api.MetricsList
+	values := make(map[string]float64, len(r.samples))
+	for _, s := range r.samples {
+		name := runtimeMetricName(s.Name)
+		switch s.Value.Kind() {
+		case rtmetrics.KindUint64:
+			v := s.Value.Uint64()
+			values[s.Name] = float64(v)
+			if r.cumulative[s.Name] {
+				msgs = append(msgs, counter(name, int64(v)))
+			} else {
+				msgs = append(msgs, gauge(name, float64(v)))
+			}
+		case rtmetrics.KindFloat64:
+			v := s.Value.Float64()
+			values[s.Name] = v
+			msgs = append(msgs, gauge(name, v))
+		case rtmetrics.KindFloat64Histogram:
+			msgs = append(msgs, r.histogram(s.Name, name, s.Value.Float64Histogram())...)
+		}
+	}
+	if !gcStats.LastGC.IsZero() {
+		msgs = append(msgs, gauge("go_gc_last_timestamp_seconds", float64(gcStats.LastGC.UnixNano())/1e9))
+	}
+	if r.legacy {
+		msgs = append(msgs, legacyMemStats(values, gcStats)...)
+	}
+	return msgs, nil
+}
+
+// runtimeMetricName converts the name of a runtime/metrics sample to the name of the metric.
+func runtimeMetricName(sample string) string {
+	b := []byte("go" + sample)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return strings.TrimRight(string(b), "_")
+}
+
+// histogram returns the metrics of the histogram and remembers its counts.
+func (r *Runtime) histogram(sample, name string, h *rtmetrics.Float64Histogram) api.MetricsList {
+	prev := r.prev[sample]
+	delta := make([]uint64, len(h.Counts))
+	var total, observed uint64
+	for i, c := range h.Counts {
+		total += c
+		delta[i] = c
+		if len(prev) == len(h.Counts) && prev[i] <= c {
+			delta[i] -= prev[i]
+		}
+		observed += delta[i]
+	}
+	r.prev[sample] = append(prev[:0], h.Counts...)
+
+	msgs := api.MetricsList{counter(name+"_count", int64(total))}
+	for _, q := range histogramQuantiles {
+		msgs = append(msgs, gauge(name+"_"+q.suffix, quantile(q.q, h.Buckets, delta, observed)))
+	}
+	return msgs
+}
+
+// quantile returns the upper bound of the bucket with the quantile q of the observations,
+// the lower bound for the last bucket without an upper bound.
+func quantile(q float64, buckets []float64, counts []uint64, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(total)))
+	var seen uint64
+	for i, c := range counts {
+		seen += c
+		if seen < rank || c == 0 {
+			continue
+		}
+		if upper := buckets[i+1]; !math.IsInf(upper, 1) {
+			return upper
+		}
+		return max(buckets[i], 0)
+	}
+	return 0
+}
+
+// legacyMemStats returns the gauges with the names of runtime.MemStats fields computed like in the runtime.
+// Besides the fields it returns LastGCSeconds, the time of LastGC in Unix seconds.
+func legacyMemStats(v map[string]float64, gcStats debug.GCStats) api.MetricsList {
+	heapInuse := v["/memory/classes/heap/objects:bytes"] + v["/memory/classes/heap/unused:bytes"]
+	heapIdle := v["/memory/classes/heap/free:bytes"] + v["/memory/classes/heap/released:bytes"]
+	stackInuse := v["/memory/classes/heap/stacks:bytes"]
+	var gcCPUFraction float64
+	if total := v["/cpu/classes/total:cpu-seconds"]; total > 0 {
+		gcCPUFraction = v["/cpu/classes/gc/total:cpu-seconds"] / total
+	}
+	var lastGC, lastGCSeconds float64
+	if !gcStats.LastGC.IsZero() {
+		lastGC = float64(gcStats.LastGC.UnixNano())
+		lastGCSeconds = float64(gcStats.LastGC.UnixNano()) / 1e9
+	}
+	return api.MetricsList{
+		gauge("Alloc", v["/memory/classes/heap/objects:bytes"]),
+		gauge("BuckHashSys", v["/memory/classes/profiling/buckets:bytes"]),
+		gauge("Frees", v["/gc/heap/frees:objects"]+v["/gc/heap/tiny/allocs:objects"]),
+		gauge("GCCPUFraction", gcCPUFraction),
+		gauge("GCSys", v["/memory/classes/metadata/other:bytes"]),
+		gauge("HeapAlloc", v["/memory/classes/heap/objects:bytes"]),
+		gauge("HeapIdle", heapIdle),
+		gauge("HeapInuse", heapInuse),
+		gauge("HeapObjects", v["/gc/heap/objects:objects"]),
+		gauge("HeapReleased", v["/memory/classes/heap/released:bytes"]),
+		gauge("HeapSys", heapInuse+heapIdle),
+		// LastGC оставлен в наносекундах для совместимости, новые панели должны читать LastGCSeconds.
+		gauge("LastGC", lastGC),
+		gauge("LastGCSeconds", lastGCSeconds),
+		// Lookups в рантайме всегда равен нулю.
+		gauge("Lookups", 0),
+		gauge("MCacheInuse", v["/memory/classes/metadata/mcache/inuse:bytes"]),
+		gauge("MCacheSys", v["/memory/classes/metadata/mcache/inuse:bytes"]+v["/memory/classes/metadata/mcache/free:bytes"]),
+		gauge("MSpanInuse", v["/memory/classes/metadata/mspan/inuse:bytes"]),
+		gauge("MSpanSys", v["/memory/classes/metadata/mspan/inuse:bytes"]+v["/memory/classes/metadata/mspan/free:bytes"]),
+		gauge("Mallocs", v["/gc/heap/allocs:objects"]+v["/gc/heap/tiny/allocs:objects"]),
+		gauge("NextGC", v["/gc/heap/goal:bytes"]),
+		gauge("NumForcedGC", v["/gc/cycles/forced:gc-cycles"]),
+		gauge("NumGC", v["/gc/cycles/total:gc-cycles"]),
+		gauge("OtherSys", v["/memory/classes/other:bytes"]),
+		gauge("PauseTotalNs", float64(gcStats.PauseTotal.Nanoseconds())),
+		gauge("StackInuse", stackInuse),
+		gauge("StackSys", stackInuse+v["/memory/classes/os-stacks:bytes"]),
+		gauge("Sys", v["/memory/classes/total:bytes"]),
+		gauge("TotalAlloc", v["/gc/heap/allocs:bytes"]),
+	}
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"context"
+	"math"
+	"runtime/debug"
+	rtmetrics "runtime/metrics"
+	"testing"
+	"time"
+)
+
+func TestRuntimeMetricName(t *testing.T) {
+	tests := map[string]string{
+		"/gc/heap/allocs:bytes":                         "go_gc_heap_allocs_bytes",
+		"/sched/latencies:seconds":                      "go_sched_latencies_seconds",
+		"/cpu/classes/gc/total:cpu-seconds":             "go_cpu_classes_gc_total_cpu_seconds",
+		"/godebug/non-default-behavior/x509sha1:events": "go_godebug_non_default_behavior_x509sha1_events",
+	}
+	for sample, want := range tests {
+		if got := runtimeMetricName(sample); got != want {
+			t.Errorf("runtimeMetricName(%q) = %q, want %q", sample, got, want)
+		}
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	buckets := []float64{math.Inf(-1), 1, 2, 4, math.Inf(1)}
+	tests := []struct {
+		q      float64
+		counts []uint64
+		want   float64
+	}{
+		{0.5, []uint64{0, 0, 0, 0}, 0},
+		{0.5, []uint64{0, 10, 0, 0}, 2},
+		{0.5, []uint64{5, 0, 5, 0}, 1},
+		{0.9, []uint64{5, 0, 5, 0}, 4},
+		{0.99, []uint64{0, 90, 9, 1}, 4},
+		{0.5, []uint64{1, 0, 0, 0}, 1},
+	}
+	for _, tt := range tests {
+		var total uint64
+		for _, c := range tt.counts {
+			total += c
+		}
+		if got := quantile(tt.q, buckets, tt.counts, total); got != tt.want {
+			t.Errorf("quantile(%v, %v) = %v, want %v", tt.q, tt.counts, got, tt.want)
+		}
+	}
+}
+
+func TestRuntime_histogram(t *testing.T) {
+	r := NewRuntime(false)
+	h := &rtmetrics.Float64Histogram{
+		Buckets: []float64{0, 0.001, 0.01, 0.1, math.Inf(1)},
+		Counts:  []uint64{100, 0, 0, 0},
+	}
+	got := values(r.histogram("/gc/pauses:seconds", "go_gc_pauses_seconds", h))
+	if got["go_gc_pauses_seconds_count"] != 100 || got["go_gc_pauses_seconds_p99"] != 0.001 {
+		t.Errorf("histogram() = %v", got)
+	}
+
+	// Квантили считаются только по новым наблюдениям.
+	h.Counts = []uint64{100, 0, 10, 0}
+	got = values(r.histogram("/gc/pauses:seconds", "go_gc_pauses_seconds", h))
+	want := map[string]float64{
+		"go_gc_pauses_seconds_count": 110,
+		"go_gc_pauses_seconds_p50":   0.1,
+		"go_gc_pauses_seconds_p90":   0.1,
+		"go_gc_pauses_seconds_p99":   0.1,
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s = %v, want %v", name, got[name], value)
+		}
+	}
+
+	got = values(r.histogram("/gc/pauses:seconds", "go_gc_pauses_seconds", h))
+	if got["go_gc_pauses_seconds_count"] != 110 || got["go_gc_pauses_seconds_p50"] != 0 {
+		t.Errorf("histogram() without observations = %v", got)
+	}
+}
+
+func TestRuntime_Collect(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		msgs, err := NewRuntime(legacy).Collect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		types := make(map[string]string, len(msgs))
+		for _, m := range msgs {
+			if err := m.Validate(); err != nil {
+				t.Errorf("%s: %v", m.ID, err)
+			}
+			types[m.ID] = m.MType
+		}
+		for name, mType := range map[string]string{
+			"go_gc_heap_allocs_bytes":        "counter",
+			"go_gc_pauses_seconds_count":     "counter",
+			"go_sched_latencies_seconds_p99": "gauge",
+			"go_memory_classes_total_bytes":  "gauge",
+		} {
+			if types[name] != mType {
+				t.Errorf("legacy %v: %s has type %q, want %q", legacy, name, types[name], mType)
+			}
+		}
+		if _, ok := types["HeapAlloc"]; ok != legacy {
+			t.Errorf("legacy %v: HeapAlloc reported %v", legacy, ok)
+		}
+	}
+}
+
+func TestLegacyMemStats_lastGC(t *testing.T) {
+	msgs := legacyMemStats(map[string]float64{}, debug.GCStats{LastGC: time.Unix(1700000000, 500000000)})
+	got := values(msgs)
+	if got["LastGCSeconds"] != 1700000000.5 {
+		t.Errorf("LastGCSeconds = %v, want %v", got["LastGCSeconds"], 1700000000.5)
+	}
+	if got["LastGC"] != 1700000000.5e9 {
+		t.Errorf("LastGC = %v, want %v", got["LastGC"], 1700000000.5e9)
+	}
+}
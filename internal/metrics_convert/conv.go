@@ -26,7 +26,7 @@ func ConvMetricOne(m api.Metrics) *pb.Metric {
 // corresponding field in the api.Metrics struct.
 func ConvMetricOneInverse(m *pb.Metric) *api.Metrics {
 	converted := api.Metrics{ID: m.Id, MType: m.Type}
-	if m.Type == api.CounterName && m.Delta != 0 {
+	if m.Type == api.CounterName {
 		converted.Delta = &m.Delta
 	} else {
 		converted.Value = &m.Value
@@ -54,3 +54,12 @@ func ConvMetricsInverse(ms *pb.Metrics) *api.MetricsList {
 	}
 	return &converted
 }
+
+// ConvRejected converts a slice of api.Rejected structs to a slice of pb.Rejected structs.
+func ConvRejected(rs []api.Rejected) []*pb.Rejected {
+	converted := make([]*pb.Rejected, len(rs))
+	for i, r := range rs {
+		converted[i] = &pb.Rejected{Index: int32(r.Index), Id: r.ID, Type: r.MType, Reason: r.Reason}
+	}
+	return converted
+}
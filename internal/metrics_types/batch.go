@@ -0,0 +1,39 @@
+package metrictypes
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// BatchIDHeader is the HTTP header carrying the ID of a batch of metrics.
+//
+// The server ignores a batch whose ID was already applied, so agents may safely retry sending.
+const BatchIDHeader = "Idempotency-Key"
+
+// NewBatchID returns a random batch ID.
+func NewBatchID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+type outgoingBatchIDKey struct{}
+
+// WithOutgoingBatchID returns a copy of ctx carrying the batch ID the clients send the metrics with
+// instead of a new one, so a replayed batch keeps its ID.
+//
+// It is used by the agent, the server reads the ID of a received batch with store.BatchIDFromContext.
+func WithOutgoingBatchID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, outgoingBatchIDKey{}, id)
+}
+
+// OutgoingBatchID returns the batch ID stored in ctx by WithOutgoingBatchID or a new random one.
+func OutgoingBatchID(ctx context.Context) string {
+	if id, ok := ctx.Value(outgoingBatchIDKey{}).(string); ok && id != "" {
+		return id
+	}
+	return NewBatchID()
+}
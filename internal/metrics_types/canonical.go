@@ -0,0 +1,67 @@
+package metrictypes
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/mailru/easyjson"
+)
+
+// canonicalVersion is the first line of the canonical serialization, it changes with the format.
+const canonicalVersion = "go-monitor metrics v1"
+
+// Canonical returns the serialization of the batch of metrics which is signed by agents.
+//
+// It does not depend on the transport, so the same key gives the same signature over HTTP and gRPC:
+//
+//	go-monitor metrics v1
+//	batch "<batch ID>"
+//	"<id>" "<type>" <value>
+//
+// with a line per metric in the order of the list. The value of a counter is its delta,
+// the value of other types is the float value in the shortest form which parses back to the same number.
+// A missing value is written as zero, because protobuf does not distinguish them.
+func (ms MetricsList) Canonical(batchID string) []byte {
+	var b bytes.Buffer
+	b.WriteString(canonicalVersion)
+	b.WriteString("\nbatch ")
+	b.WriteString(strconv.Quote(batchID))
+	b.WriteByte('\n')
+	for _, m := range ms {
+		b.WriteString(strconv.Quote(m.ID))
+		b.WriteByte(' ')
+		b.WriteString(strconv.Quote(m.MType))
+		b.WriteByte(' ')
+		if m.MType == CounterName {
+			var delta int64
+			if m.Delta != nil {
+				delta = *m.Delta
+			}
+			b.WriteString(strconv.FormatInt(delta, 10))
+		} else {
+			var value float64
+			if m.Value != nil {
+				value = *m.Value
+			}
+			b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+		}
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// CanonicalBody returns the signed serialization of the JSON body of an HTTP request.
+//
+// The body with a list of metrics or a single metric is serialized with Canonical,
+// other bodies, for example the empty body of the update by URL, are signed as is.
+func CanonicalBody(body []byte, batchID string) []byte {
+	var list MetricsList
+	if err := easyjson.Unmarshal(body, &list); err == nil {
+		return list.Canonical(batchID)
+	}
+	var m Metrics
+	if err := easyjson.Unmarshal(body, &m); err == nil {
+		return MetricsList{m}.Canonical(batchID)
+	}
+	return body
+}
@@ -0,0 +1,105 @@
+package metrictypes_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mailru/easyjson"
+	mcv "github.com/xoxloviwan/go-monitor/internal/metrics_convert"
+	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+	pb "github.com/xoxloviwan/go-monitor/internal/metrics_types/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMetricsList_Canonical(t *testing.T) {
+	delta := int64(-5)
+	value := 0.1
+	ms := api.MetricsList{
+		{ID: "PollCount", MType: api.CounterName, Delta: &delta},
+		{ID: "Alloc \"heap\"\n", MType: api.GaugeName, Value: &value},
+		{ID: "Empty", MType: api.GaugeName},
+	}
+	want := "go-monitor metrics v1\n" +
+		"batch \"b1\"\n" +
+		"\"PollCount\" \"counter\" -5\n" +
+		"\"Alloc \\\"heap\\\"\\n\" \"gauge\" 0.1\n" +
+		"\"Empty\" \"gauge\" 0\n"
+	if got := string(ms.Canonical("b1")); got != want {
+		t.Errorf("Canonical() = %q, want %q", got, want)
+	}
+	if bytes.Equal(ms.Canonical("b1"), ms.Canonical("b2")) {
+		t.Error("Canonical() does not depend on the batch ID")
+	}
+}
+
+// TestCanonical_transports checks that the metrics decoded by the server from JSON and from protobuf
+// have the same canonical serialization as the metrics signed by the agent.
+func TestCanonical_transports(t *testing.T) {
+	delta := int64(1 << 40)
+	value := 123456.789e-10
+	negative := -1.5
+	tests := []struct {
+		name string
+		ms   api.MetricsList
+	}{
+		{"empty", api.MetricsList{}},
+		{"counter", api.MetricsList{{ID: "PollCount", MType: api.CounterName, Delta: &delta}}},
+		{"gauge", api.MetricsList{{ID: "Alloc", MType: api.GaugeName, Value: &value}}},
+		{"mixed", api.MetricsList{
+			{ID: "RandomValue", MType: api.GaugeName, Value: &negative},
+			{ID: "PollCount", MType: api.CounterName, Delta: &delta, Value: &value},
+			{ID: "NoValue", MType: api.GaugeName},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const batchID = "0123456789abcdef"
+			want := tt.ms.Canonical(batchID)
+
+			body, err := easyjson.Marshal(tt.ms)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := api.CanonicalBody(body, batchID); !bytes.Equal(got, want) {
+				t.Errorf("JSON: got %q, want %q", got, want)
+			}
+
+			metrs := mcv.ConvMetrics(tt.ms)
+			metrs.BatchId = batchID
+			data, err := proto.Marshal(metrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var received pb.Metrics
+			if err = proto.Unmarshal(data, &received); err != nil {
+				t.Fatal(err)
+			}
+			if got := mcv.ConvMetricsInverse(&received).Canonical(received.BatchId); !bytes.Equal(got, want) {
+				t.Errorf("protobuf: got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCanonicalBody(t *testing.T) {
+	delta := int64(1)
+	single := api.MetricsList{{ID: "PollCount", MType: api.CounterName, Delta: &delta}}.Canonical("")
+	tests := []struct {
+		name string
+		body string
+		want []byte
+	}{
+		{"list", `[{"id":"PollCount","type":"counter","delta":1}]`, single},
+		{"single", `{"type":"counter","id":"PollCount","delta":1}`, single},
+		{"spaces", "[ {\"id\": \"PollCount\",\n \"type\": \"counter\", \"delta\": 1} ]", single},
+		{"empty", "", []byte("")},
+		{"not JSON", "PollCount=1", []byte("PollCount=1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := api.CanonicalBody([]byte(tt.body), ""); !bytes.Equal(got, tt.want) {
+				t.Errorf("CanonicalBody() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package metrictypes
+
+//go:generate easyjson -output_filename validate_easyjson_generated.go -all validate.go
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// MaxNameLength is the maximum length of a metric name in bytes.
+const MaxNameLength = 255
+
+// Validation errors returned by Metrics.Validate.
+var (
+	ErrEmptyName   = errors.New("empty name")
+	ErrLongName    = fmt.Errorf("name longer than %d bytes", MaxNameLength)
+	ErrInvalidName = errors.New("invalid character in name")
+	ErrUnknownType = errors.New("unknown type")
+	ErrNoValue     = errors.New("missing value")
+	ErrNotFinite   = errors.New("value is NaN or Inf")
+)
+
+// validNameChar reports whether c may be used in metric names.
+func validNameChar(c rune) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	case c == '_', c == '-', c == '.', c == ':':
+		return true
+	}
+	return false
+}
+
+// ValidateName checks that the name is non-empty, at most MaxNameLength bytes
+// and consists of letters, digits and "_-.:".
+func ValidateName(name string) error {
+	switch {
+	case name == "":
+		return ErrEmptyName
+	case len(name) > MaxNameLength:
+		return ErrLongName
+	}
+	for _, c := range name {
+		if !validNameChar(c) {
+			return fmt.Errorf("%w: %q", ErrInvalidName, c)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the metric can be stored.
+//
+// The name must pass ValidateName.
+// A counter must have Delta, a gauge must have a finite Value.
+func (m Metrics) Validate() error {
+	if err := ValidateName(m.ID); err != nil {
+		return err
+	}
+	switch m.MType {
+	case CounterName:
+		if m.Delta == nil {
+			return ErrNoValue
+		}
+	case GaugeName:
+		if m.Value == nil {
+			return ErrNoValue
+		}
+		if math.IsNaN(*m.Value) || math.IsInf(*m.Value, 0) {
+			return ErrNotFinite
+		}
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownType, m.MType)
+	}
+	return nil
+}
+
+// Rejected describes a metric of a batch that was not stored.
+//
+//easyjson:json
+type Rejected struct {
+	Index  int    `json:"index"`  // позиция метрики в пакете
+	ID     string `json:"id"`     // имя метрики
+	MType  string `json:"type"`   // тип метрики
+	Reason string `json:"reason"` // причина отказа
+}
+
+// BatchResultType is the media type of BatchResult.
+//
+// The batch update responds with BatchResult only to clients accepting this type,
+// other clients get the list of the stored values of the accepted metrics as before
+// and the number of rejected metrics in the RejectedHeader header.
+const BatchResultType = "application/vnd.go-monitor.batch-result+json"
+
+// RejectedHeader is the HTTP header with the number of metrics rejected from a batch.
+const RejectedHeader = "X-Rejected-Metrics"
+
+// BatchResult is the response to a batch update.
+//
+//easyjson:json
+type BatchResult struct {
+	Accepted MetricsList `json:"accepted"`
+	// AcceptedIndex are the positions of the accepted metrics in the batch.
+	AcceptedIndex []int      `json:"accepted_index"`
+	Rejected      []Rejected `json:"rejected"`
+}
+
+// Split validates every metric of the list and separates valid metrics from rejected ones.
+func (l MetricsList) Split() (MetricsList, []Rejected) {
+	accepted := make(MetricsList, 0, len(l))
+	rejected := make([]Rejected, 0)
+	for i, m := range l {
+		if err := m.Validate(); err != nil {
+			rejected = append(rejected, Rejected{Index: i, ID: m.ID, MType: m.MType, Reason: err.Error()})
+			continue
+		}
+		accepted = append(accepted, m)
+	}
+	return accepted, rejected
+}
+
+// ParseMetric returns the metric of the update by URL with the value in text form and validates it.
+func ParseMetric(mType, id, value string) (Metrics, error) {
+	m := Metrics{ID: id, MType: mType}
+	switch mType {
+	case CounterName:
+		delta, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return m, err
+		}
+		m.Delta = &delta
+	case GaugeName:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return m, err
+		}
+		m.Value = &v
+	}
+	return m, m.Validate()
+}
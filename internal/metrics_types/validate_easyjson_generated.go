@@ -0,0 +1,250 @@
+// Code generated by easyjson for marshaling/unmarshaling. DO NOT EDIT.
+
+package metrictypes
+
+import (
+	json "encoding/json"
+	easyjson "github.com/mailru/easyjson"
+	jlexer "github.com/mailru/easyjson/jlexer"
+	jwriter "github.com/mailru/easyjson/jwriter"
+)
+
+// suppress unused package warning
+var (
+	_ *json.RawMessage
+	_ *jlexer.Lexer
+	_ *jwriter.Writer
+	_ easyjson.Marshaler
+)
+
+func easyjsonBe28c778DecodeGithubComXoxloviwanGoMonitorInternalMetricsTypes(in *jlexer.Lexer, out *Rejected) {
+	isTopLevel := in.IsStart()
+	if in.IsNull() {
+		if isTopLevel {
+			in.Consumed()
+		}
+		in.Skip()
+		return
+	}
+	in.Delim('{')
+	for !in.IsDelim('}') {
+		key := in.UnsafeFieldName(false)
+		in.WantColon()
+		if in.IsNull() {
+			in.Skip()
+			in.WantComma()
+			continue
+		}
+		switch key {
+		case "index":
+			out.Index = int(in.Int())
+		case "id":
+			out.ID = string(in.String())
+		case "type":
+			out.MType = string(in.String())
+		case "reason":
+			out.Reason = string(in.String())
+		default:
+			in.SkipRecursive()
+		}
+		in.WantComma()
+	}
+	in.Delim('}')
+	if isTopLevel {
+		in.Consumed()
+	}
+}
+func easyjsonBe28c778EncodeGithubComXoxloviwanGoMonitorInternalMetricsTypes(out *jwriter.Writer, in Rejected) {
+	out.RawByte('{')
+	first := true
+	_ = first
+	{
+		const prefix string = ",\"index\":"
+		out.RawString(prefix[1:])
+		out.Int(int(in.Index))
+	}
+	{
+		const prefix string = ",\"id\":"
+		out.RawString(prefix)
+		out.String(string(in.ID))
+	}
+	{
+		const prefix string = ",\"type\":"
+		out.RawString(prefix)
+		out.String(string(in.MType))
+	}
+	{
+		const prefix string = ",\"reason\":"
+		out.RawString(prefix)
+		out.String(string(in.Reason))
+	}
+	out.RawByte('}')
+}
+
+// MarshalJSON supports json.Marshaler interface
+func (v Rejected) MarshalJSON() ([]byte, error) {
+	w := jwriter.Writer{}
+	easyjsonBe28c778EncodeGithubComXoxloviwanGoMonitorInternalMetricsTypes(&w, v)
+	return w.Buffer.BuildBytes(), w.Error
+}
+
+// MarshalEasyJSON supports easyjson.Marshaler interface
+func (v Rejected) MarshalEasyJSON(w *jwriter.Writer) {
+	easyjsonBe28c778EncodeGithubComXoxloviwanGoMonitorInternalMetricsTypes(w, v)
+}
+
+// UnmarshalJSON supports json.Unmarshaler interface
+func (v *Rejected) UnmarshalJSON(data []byte) error {
+	r := jlexer.Lexer{Data: data}
+	easyjsonBe28c778DecodeGithubComXoxloviwanGoMonitorInternalMetricsTypes(&r, v)
+	return r.Error()
+}
+
+// UnmarshalEasyJSON supports easyjson.Unmarshaler interface
+func (v *Rejected) UnmarshalEasyJSON(l *jlexer.Lexer) {
+	easyjsonBe28c778DecodeGithubComXoxloviwanGoMonitorInternalMetricsTypes(l, v)
+}
+func easyjsonBe28c778DecodeGithubComXoxloviwanGoMonitorInternalMetricsTypes1(in *jlexer.Lexer, out *BatchResult) {
+	isTopLevel := in.IsStart()
+	if in.IsNull() {
+		if isTopLevel {
+			in.Consumed()
+		}
+		in.Skip()
+		return
+	}
+	in.Delim('{')
+	for !in.IsDelim('}') {
+		key := in.UnsafeFieldName(false)
+		in.WantColon()
+		if in.IsNull() {
+			in.Skip()
+			in.WantComma()
+			continue
+		}
+		switch key {
+		case "accepted":
+			(out.Accepted).UnmarshalEasyJSON(in)
+		case "accepted_index":
+			if in.IsNull() {
+				in.Skip()
+				out.AcceptedIndex = nil
+			} else {
+				in.Delim('[')
+				if out.AcceptedIndex == nil {
+					if !in.IsDelim(']') {
+						out.AcceptedIndex = make([]int, 0, 8)
+					} else {
+						out.AcceptedIndex = []int{}
+					}
+				} else {
+					out.AcceptedIndex = (out.AcceptedIndex)[:0]
+				}
+				for !in.IsDelim(']') {
+					var v1 int
+					v1 = int(in.Int())
+					out.AcceptedIndex = append(out.AcceptedIndex, v1)
+					in.WantComma()
+				}
+				in.Delim(']')
+			}
+		case "rejected":
+			if in.IsNull() {
+				in.Skip()
+				out.Rejected = nil
+			} else {
+				in.Delim('[')
+				if out.Rejected == nil {
+					if !in.IsDelim(']') {
+						out.Rejected = make([]Rejected, 0, 1)
+					} else {
+						out.Rejected = []Rejected{}
+					}
+				} else {
+					out.Rejected = (out.Rejected)[:0]
+				}
+				for !in.IsDelim(']') {
+					var v2 Rejected
+					(v2).UnmarshalEasyJSON(in)
+					out.Rejected = append(out.Rejected, v2)
+					in.WantComma()
+				}
+				in.Delim(']')
+			}
+		default:
+			in.SkipRecursive()
+		}
+		in.WantComma()
+	}
+	in.Delim('}')
+	if isTopLevel {
+		in.Consumed()
+	}
+}
+func easyjsonBe28c778EncodeGithubComXoxloviwanGoMonitorInternalMetricsTypes1(out *jwriter.Writer, in BatchResult) {
+	out.RawByte('{')
+	first := true
+	_ = first
+	{
+		const prefix string = ",\"accepted\":"
+		out.RawString(prefix[1:])
+		(in.Accepted).MarshalEasyJSON(out)
+	}
+	{
+		const prefix string = ",\"accepted_index\":"
+		out.RawString(prefix)
+		if in.AcceptedIndex == nil && (out.Flags&jwriter.NilSliceAsEmpty) == 0 {
+			out.RawString("null")
+		} else {
+			out.RawByte('[')
+			for v3, v4 := range in.AcceptedIndex {
+				if v3 > 0 {
+					out.RawByte(',')
+				}
+				out.Int(int(v4))
+			}
+			out.RawByte(']')
+		}
+	}
+	{
+		const prefix string = ",\"rejected\":"
+		out.RawString(prefix)
+		if in.Rejected == nil && (out.Flags&jwriter.NilSliceAsEmpty) == 0 {
+			out.RawString("null")
+		} else {
+			out.RawByte('[')
+			for v5, v6 := range in.Rejected {
+				if v5 > 0 {
+					out.RawByte(',')
+				}
+				(v6).MarshalEasyJSON(out)
+			}
+			out.RawByte(']')
+		}
+	}
+	out.RawByte('}')
+}
+
+// MarshalJSON supports json.Marshaler interface
+func (v BatchResult) MarshalJSON() ([]byte, error) {
+	w := jwriter.Writer{}
+	easyjsonBe28c778EncodeGithubComXoxloviwanGoMonitorInternalMetricsTypes1(&w, v)
+	return w.Buffer.BuildBytes(), w.Error
+}
+
+// MarshalEasyJSON supports easyjson.Marshaler interface
+func (v BatchResult) MarshalEasyJSON(w *jwriter.Writer) {
+	easyjsonBe28c778EncodeGithubComXoxloviwanGoMonitorInternalMetricsTypes1(w, v)
+}
+
+// UnmarshalJSON supports json.Unmarshaler interface
+func (v *BatchResult) UnmarshalJSON(data []byte) error {
+	r := jlexer.Lexer{Data: data}
+	easyjsonBe28c778DecodeGithubComXoxloviwanGoMonitorInternalMetricsTypes1(&r, v)
+	return r.Error()
+}
+
+// UnmarshalEasyJSON supports easyjson.Unmarshaler interface
+func (v *BatchResult) UnmarshalEasyJSON(l *jlexer.Lexer) {
+	easyjsonBe28c778DecodeGithubComXoxloviwanGoMonitorInternalMetricsTypes1(l, v)
+}
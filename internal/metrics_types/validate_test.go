@@ -0,0 +1,77 @@
+package metrictypes
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestMetrics_Validate(t *testing.T) {
+	delta := int64(1)
+	value := 1.5
+	nan := math.NaN()
+	inf := math.Inf(-1)
+	tests := []struct {
+		name string
+		m    Metrics
+		want error
+	}{
+		{"counter", Metrics{ID: "PollCount", MType: CounterName, Delta: &delta}, nil},
+		{"gauge", Metrics{ID: "go_monitor.cpu-1:user", MType: GaugeName, Value: &value}, nil},
+		{"empty name", Metrics{MType: GaugeName, Value: &value}, ErrEmptyName},
+		{"long name", Metrics{ID: strings.Repeat("a", MaxNameLength+1), MType: GaugeName, Value: &value}, ErrLongName},
+		{"invalid name", Metrics{ID: "cpu/1", MType: GaugeName, Value: &value}, ErrInvalidName},
+		{"unknown type", Metrics{ID: "Alloc", MType: "histogram", Value: &value}, ErrUnknownType},
+		{"counter without delta", Metrics{ID: "PollCount", MType: CounterName, Value: &value}, ErrNoValue},
+		{"gauge without value", Metrics{ID: "Alloc", MType: GaugeName, Delta: &delta}, ErrNoValue},
+		{"NaN", Metrics{ID: "Alloc", MType: GaugeName, Value: &nan}, ErrNotFinite},
+		{"Inf", Metrics{ID: "Alloc", MType: GaugeName, Value: &inf}, ErrNotFinite},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.m.Validate(); !errors.Is(err, tt.want) {
+				t.Errorf("Metrics.Validate() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsList_Split(t *testing.T) {
+	value := 1.5
+	l := MetricsList{
+		{ID: "Alloc", MType: GaugeName, Value: &value},
+		{ID: "PollCount", MType: CounterName},
+		{ID: "Frees", MType: GaugeName, Value: &value},
+	}
+	accepted, rejected := l.Split()
+	if len(accepted) != 2 || accepted[0].ID != "Alloc" || accepted[1].ID != "Frees" {
+		t.Errorf("accepted = %v", accepted)
+	}
+	want := Rejected{Index: 1, ID: "PollCount", MType: CounterName, Reason: ErrNoValue.Error()}
+	if len(rejected) != 1 || rejected[0] != want {
+		t.Errorf("rejected = %v, want [%v]", rejected, want)
+	}
+}
+
+func TestParseMetric(t *testing.T) {
+	tests := []struct {
+		mType, id, value string
+		want             error
+	}{
+		{CounterName, "PollCount", "5", nil},
+		{GaugeName, "Alloc", "1.5", nil},
+		{GaugeName, "Alloc", "NaN", ErrNotFinite},
+		{GaugeName, "Alloc", "+Inf", ErrNotFinite},
+		{GaugeName, "cpu/1", "1", ErrInvalidName},
+		{"histogram", "Alloc", "1", ErrUnknownType},
+	}
+	for _, tt := range tests {
+		if _, err := ParseMetric(tt.mType, tt.id, tt.value); !errors.Is(err, tt.want) {
+			t.Errorf("ParseMetric(%q, %q, %q) error = %v, want %v", tt.mType, tt.id, tt.value, err, tt.want)
+		}
+	}
+	if _, err := ParseMetric(CounterName, "PollCount", "1.5"); err == nil {
+		t.Error("ParseMetric() of a fractional counter: want error")
+	}
+}
@@ -0,0 +1,363 @@
+// Package outbox stores batches of metrics which the agent failed to send, so they survive outages of the server.
+//
+// Batches are kept in segment files of a directory, the name of a segment is the time of its creation:
+//
+//	01727784000000000000.json
+//
+// A failed batch is stored in the newest segment while the segment is younger than a tenth of the maximum age,
+// then a new segment is started. Each batch keeps the ID it was sent with, so a batch applied by the server
+// whose response was lost is ignored when it is replayed. A batch that was never sent is merged into
+// the previous one of the segment which was not sent either, if the merged batch is not larger than them.
+// Merging sums counter deltas and keeps the last gauge values, so a segment does not grow while the agent
+// queues the same metrics. Segments older than the maximum age and the oldest segments exceeding
+// the maximum size of the directory are dropped.
+//
+// Segments are replayed from the oldest, so the server gets gauge values in the order they were measured.
+// The batches are replayed as they were pushed, so a replay fits the same quotas of the server as a regular report.
+package outbox
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+)
+
+const (
+	segmentExt = ".json"
+	// nameWidth is the width of the creation time in nanoseconds in the segment name.
+	nameWidth = 20
+	// defaultSpan is the time segments are merged into when the maximum age is not limited.
+	defaultSpan = time.Minute
+)
+
+// Outbox is a queue of batches of metrics on disk.
+//
+// It is safe for concurrent use by the workers of the agent.
+type Outbox struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	now     func() time.Time
+
+	mu sync.Mutex
+}
+
+// Open opens the outbox in the directory, creating it if needed.
+// Zero maxSize or maxAge means no limit.
+func Open(dir string, maxSize int64, maxAge time.Duration) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &Outbox{dir: dir, maxSize: maxSize, maxAge: maxAge, now: time.Now}, nil
+}
+
+// segment is a file of the outbox.
+type segment struct {
+	name    string
+	created int64
+	size    int64
+}
+
+// segments returns the segments from the oldest.
+func (o *Outbox) segments() ([]segment, error) {
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, err
+	}
+	var segs []segment
+	for _, e := range entries {
+		name := e.Name()
+		stem, ok := strings.CutSuffix(name, segmentExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		created, err := strconv.ParseInt(stem, 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		segs = append(segs, segment{name: name, created: created, size: info.Size()})
+	}
+	slices.SortFunc(segs, func(a, b segment) int { return cmp.Compare(a.created, b.created) })
+	return segs, nil
+}
+
+// span is the time a segment accepts new batches.
+func (o *Outbox) span() time.Duration {
+	if o.maxAge <= 0 {
+		return defaultSpan
+	}
+	return o.maxAge / 10
+}
+
+// batch is a queued batch of metrics.
+type batch struct {
+	ID string `json:"id"`
+	// Sent is set when the batch was sent, the server may have applied it, so it is not merged.
+	Sent    bool            `json:"sent,omitempty"`
+	Metrics api.MetricsList `json:"metrics"`
+}
+
+// record is the content of a segment.
+type record struct {
+	Batches []batch `json:"batches"`
+}
+
+// add stores the batch, merging a batch that was never sent into the last one that was not sent either.
+func (r *record) add(b batch) {
+	if n := len(r.Batches); n > 0 && !b.Sent && !r.Batches[n-1].Sent {
+		last := &r.Batches[n-1]
+		// Слияние не увеличивает пакет сверх обычного отчета.
+		if merged := Merge(last.Metrics, b.Metrics); len(merged) <= max(len(last.Metrics), len(b.Metrics)) {
+			last.Metrics = merged
+			return
+		}
+	}
+	r.Batches = append(r.Batches, b)
+}
+
+// size returns the number of metrics of the segment.
+func (r *record) size() int {
+	n := 0
+	for _, b := range r.Batches {
+		n += len(b.Metrics)
+	}
+	return n
+}
+
+func (o *Outbox) read(name string) (*record, error) {
+	data, err := os.ReadFile(filepath.Join(o.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	var rec record
+	if err = json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("segment %s: %w", name, err)
+	}
+	return &rec, nil
+}
+
+// write replaces the segment atomically.
+func (o *Outbox) write(name string, rec *record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(o.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(o.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Push stores the batch with the ID it was sent with.
+//
+// An empty batchID means that the batch was never sent, a new ID is assigned to it.
+func (o *Outbox) Push(batchID string, msgs api.MetricsList) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	b := batch{ID: batchID, Sent: batchID != "", Metrics: msgs}
+	if !b.Sent {
+		b.ID = api.NewBatchID()
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	segs, err := o.segments()
+	if err != nil {
+		return err
+	}
+	now := o.now().UnixNano()
+	if n := len(segs); n > 0 && now-segs[n-1].created < int64(o.span()) {
+		newest := segs[n-1]
+		rec, err := o.read(newest.name)
+		if err != nil {
+			return err
+		}
+		rec.add(b)
+		if err = o.write(newest.name, rec); err != nil {
+			return err
+		}
+	} else {
+		// Имена сегментов должны возрастать, даже если часы пошли назад.
+		created := now
+		if n > 0 {
+			created = max(created, segs[n-1].created+1)
+		}
+		if err = o.write(fmt.Sprintf("%0*d%s", nameWidth, created, segmentExt), &record{Batches: []batch{b}}); err != nil {
+			return err
+		}
+	}
+	return o.trim()
+}
+
+// trim drops the segments older than the maximum age and the oldest segments exceeding the maximum size.
+func (o *Outbox) trim() error {
+	segs, err := o.segments()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, s := range segs {
+		total += s.size
+	}
+	now := o.now().UnixNano()
+	for _, s := range segs {
+		var reason string
+		switch {
+		case o.maxAge > 0 && now-s.created > int64(o.maxAge):
+			reason = "max age"
+		// Последний сегмент не удаляется по размеру, иначе не сохранится ни один пакет.
+		case o.maxSize > 0 && total > o.maxSize && s != segs[len(segs)-1]:
+			reason = "max size"
+		default:
+			continue
+		}
+		if err = os.Remove(filepath.Join(o.dir, s.name)); err != nil {
+			return err
+		}
+		total -= s.size
+		slog.Warn("Outbox segment dropped", "segment", s.name, "reason", reason)
+	}
+	return nil
+}
+
+// Len returns the number of segments.
+func (o *Outbox) Len() (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	segs, err := o.segments()
+	return len(segs), err
+}
+
+// Replay sends the batches of the segments from the oldest with their IDs and removes the sent segments.
+//
+// It stops on the first error of send which is returned if keep reports it as temporary:
+// the segment stays in the outbox and is sent by the next Replay with the same batch IDs.
+// Segments rejected with other errors are dropped.
+func (o *Outbox) Replay(ctx context.Context, send func(ctx context.Context, batchID string, msgs api.MetricsList) error, keep func(error) bool) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.trim(); err != nil {
+		return err
+	}
+	segs, err := o.segments()
+	if err != nil {
+		return err
+	}
+	for _, s := range segs {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		rec, err := o.read(s.name)
+		if err == nil {
+			err = o.send(ctx, s.name, rec, send)
+		}
+		if err != nil && keep(err) {
+			return err
+		}
+		if err != nil {
+			slog.Error("Outbox segment rejected", "segment", s.name, "error", err)
+		} else {
+			slog.Info("Outbox segment sent", "segment", s.name, "metrics", rec.size())
+		}
+		if err = os.Remove(filepath.Join(o.dir, s.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// send sends the batches of the segment.
+//
+// The batches are marked as sent before sending, so they are not merged with batches pushed later.
+func (o *Outbox) send(ctx context.Context, name string, rec *record, send func(ctx context.Context, batchID string, msgs api.MetricsList) error) error {
+	marked := false
+	for i := range rec.Batches {
+		if !rec.Batches[i].Sent {
+			rec.Batches[i].Sent, marked = true, true
+		}
+	}
+	if marked {
+		if err := o.write(name, rec); err != nil {
+			return err
+		}
+	}
+	for _, b := range rec.Batches {
+		if err := send(ctx, b.ID, b.Metrics); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merge returns the metrics of the batch a updated by the later batch b.
+//
+// Counter deltas of the same metric are summed, a gauge takes the value from b.
+// The order of the metrics is kept, the metrics new in b are appended.
+func Merge(a, b api.MetricsList) api.MetricsList {
+	type key struct{ mtype, id string }
+	merged := make(api.MetricsList, 0, len(a)+len(b))
+	index := make(map[key]int, len(a)+len(b))
+	for _, list := range []api.MetricsList{a, b} {
+		for _, m := range list {
+			k := key{m.MType, m.ID}
+			i, ok := index[k]
+			if !ok {
+				index[k] = len(merged)
+				merged = append(merged, copyMetric(m))
+				continue
+			}
+			if m.MType == api.CounterName && m.Delta != nil {
+				delta := *m.Delta
+				if merged[i].Delta != nil {
+					delta += *merged[i].Delta
+				}
+				merged[i].Delta = &delta
+				continue
+			}
+			merged[i] = copyMetric(m)
+		}
+	}
+	return merged
+}
+
+// copyMetric copies the metric with its values, so that merging does not change the pushed batches.
+func copyMetric(m api.Metrics) api.Metrics {
+	if m.Delta != nil {
+		delta := *m.Delta
+		m.Delta = &delta
+	}
+	if m.Value != nil {
+		value := *m.Value
+		m.Value = &value
+	}
+	return m
+}
@@ -0,0 +1,215 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	api "github.com/xoxloviwan/go-monitor/internal/metrics_types"
+	"github.com/xoxloviwan/go-monitor/internal/store"
+)
+
+func counter(id string, delta int64) api.Metrics {
+	return api.Metrics{ID: id, MType: api.CounterName, Delta: &delta}
+}
+
+func gauge(id string, value float64) api.Metrics {
+	return api.Metrics{ID: id, MType: api.GaugeName, Value: &value}
+}
+
+// open returns an outbox with a clock moved by the returned function.
+func open(t *testing.T, maxSize int64, maxAge time.Duration) (*Outbox, func(time.Duration)) {
+	t.Helper()
+	o, err := Open(t.TempDir(), maxSize, maxAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	o.now = func() time.Time { return now }
+	return o, func(d time.Duration) { now = now.Add(d) }
+}
+
+func replayAll(t *testing.T, o *Outbox) []api.MetricsList {
+	t.Helper()
+	var sent []api.MetricsList
+	err := o.Replay(context.Background(), func(_ context.Context, _ string, msgs api.MetricsList) error {
+		sent = append(sent, msgs)
+		return nil
+	}, func(error) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sent
+}
+
+func TestMerge(t *testing.T) {
+	a := api.MetricsList{counter("PollCount", 2), gauge("Alloc", 1)}
+	b := api.MetricsList{gauge("Alloc", 5), counter("PollCount", 3), gauge("Frees", 7)}
+	want := api.MetricsList{counter("PollCount", 5), gauge("Alloc", 5), gauge("Frees", 7)}
+	if diff := cmp.Diff(want, Merge(a, b)); diff != "" {
+		t.Errorf("Merge() mismatch (-want +got):\n%s", diff)
+	}
+	if *a[0].Delta != 2 {
+		t.Errorf("Merge() changed the batch: PollCount = %d", *a[0].Delta)
+	}
+}
+
+func TestOutbox_Push_merges(t *testing.T) {
+	o, advance := open(t, 0, time.Hour)
+	for i := 0; i < 100; i++ {
+		if err := o.Push("", api.MetricsList{counter("PollCount", 1), gauge("Alloc", float64(i))}); err != nil {
+			t.Fatal(err)
+		}
+		advance(time.Second)
+	}
+	if n, _ := o.Len(); n != 1 {
+		t.Errorf("Len() = %d, want 1", n)
+	}
+	want := []api.MetricsList{{counter("PollCount", 100), gauge("Alloc", 99)}}
+	if diff := cmp.Diff(want, replayAll(t, o)); diff != "" {
+		t.Errorf("Replay() mismatch (-want +got):\n%s", diff)
+	}
+	if n, _ := o.Len(); n != 0 {
+		t.Errorf("Len() after replay = %d, want 0", n)
+	}
+}
+
+func TestOutbox_Replay_order(t *testing.T) {
+	o, advance := open(t, 0, time.Hour)
+	for i := 1; i <= 3; i++ {
+		if err := o.Push("", api.MetricsList{gauge("Alloc", float64(i))}); err != nil {
+			t.Fatal(err)
+		}
+		// Каждый пакет попадает в новый сегмент.
+		advance(10 * time.Minute)
+	}
+
+	errDown := errors.New("server is unavailable")
+	calls := 0
+	err := o.Replay(context.Background(), func(_ context.Context, _ string, msgs api.MetricsList) error {
+		calls++
+		if calls == 2 {
+			return errDown
+		}
+		return nil
+	}, func(err error) bool { return errors.Is(err, errDown) })
+	if !errors.Is(err, errDown) {
+		t.Fatalf("Replay() error = %v, want %v", err, errDown)
+	}
+	if n, _ := o.Len(); n != 2 {
+		t.Fatalf("Len() after failed replay = %d, want 2", n)
+	}
+
+	want := []api.MetricsList{{gauge("Alloc", 2)}, {gauge("Alloc", 3)}}
+	if diff := cmp.Diff(want, replayAll(t, o)); diff != "" {
+		t.Errorf("Replay() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestOutbox_Replay_lostResponse checks that a batch applied by the server whose response was lost
+// is applied once although it is replayed.
+func TestOutbox_Replay_lostResponse(t *testing.T) {
+	o, advance := open(t, 0, time.Hour)
+	server := store.NewMemStorage()
+	apply := func(ctx context.Context, batchID string, msgs api.MetricsList) error {
+		return server.AddMetrics(store.WithBatchID(ctx, batchID), &msgs)
+	}
+
+	// Сервер применил пакет, но агент не получил ответ и сохранил пакет с ключом отправки.
+	sent := api.MetricsList{counter("PollCount", 1), gauge("Alloc", 1)}
+	if err := apply(context.Background(), "batch1", sent); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Push("batch1", sent); err != nil {
+		t.Fatal(err)
+	}
+	advance(time.Second)
+	// Неотправленные пакеты объединяются друг с другом, но не с отправленным.
+	for i := 0; i < 2; i++ {
+		if err := o.Push("", api.MetricsList{counter("PollCount", 2), gauge("Alloc", 2)}); err != nil {
+			t.Fatal(err)
+		}
+		advance(time.Second)
+	}
+
+	errDown := errors.New("server is unavailable")
+	var ids []string
+	calls := 0
+	err := o.Replay(context.Background(), func(ctx context.Context, batchID string, msgs api.MetricsList) error {
+		calls++
+		if calls == 2 {
+			return errDown
+		}
+		ids = append(ids, batchID)
+		return apply(ctx, batchID, msgs)
+	}, func(err error) bool { return errors.Is(err, errDown) })
+	if !errors.Is(err, errDown) {
+		t.Fatalf("Replay() error = %v, want %v", err, errDown)
+	}
+	err = o.Replay(context.Background(), func(ctx context.Context, batchID string, msgs api.MetricsList) error {
+		ids = append(ids, batchID)
+		return apply(ctx, batchID, msgs)
+	}, func(error) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 3 || ids[0] != "batch1" || ids[1] != "batch1" || ids[2] == "batch1" {
+		t.Errorf("batch IDs = %v, want batch1 twice and the merged batch", ids)
+	}
+	if got := server.Counter["PollCount"]; got != 5 {
+		t.Errorf("PollCount = %d, want 5", got)
+	}
+}
+
+func TestOutbox_Replay_dropsRejected(t *testing.T) {
+	o, _ := open(t, 0, time.Hour)
+	if err := o.Push("", api.MetricsList{gauge("Alloc", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	err := o.Replay(context.Background(), func(context.Context, string, api.MetricsList) error {
+		return errors.New("bad request")
+	}, func(error) bool { return false })
+	if err != nil {
+		t.Errorf("Replay() error = %v", err)
+	}
+	if n, _ := o.Len(); n != 0 {
+		t.Errorf("Len() = %d, want 0", n)
+	}
+}
+
+func TestOutbox_limits(t *testing.T) {
+	t.Run("max age", func(t *testing.T) {
+		o, advance := open(t, 0, time.Hour)
+		if err := o.Push("", api.MetricsList{gauge("Old", 1)}); err != nil {
+			t.Fatal(err)
+		}
+		advance(50 * time.Minute)
+		if err := o.Push("", api.MetricsList{gauge("New", 2)}); err != nil {
+			t.Fatal(err)
+		}
+		advance(20 * time.Minute)
+		want := []api.MetricsList{{gauge("New", 2)}}
+		if diff := cmp.Diff(want, replayAll(t, o)); diff != "" {
+			t.Errorf("Replay() mismatch (-want +got):\n%s", diff)
+		}
+	})
+	t.Run("max size", func(t *testing.T) {
+		o, advance := open(t, 100, time.Hour)
+		for i := 1; i <= 5; i++ {
+			if err := o.Push("", api.MetricsList{gauge("Alloc", float64(i))}); err != nil {
+				t.Fatal(err)
+			}
+			advance(10 * time.Minute)
+		}
+		sent := replayAll(t, o)
+		if len(sent) == 0 || len(sent) >= 5 {
+			t.Fatalf("Replay() sent %d segments, want oldest dropped", len(sent))
+		}
+		if diff := cmp.Diff(api.MetricsList{gauge("Alloc", 5)}, sent[len(sent)-1]); diff != "" {
+			t.Errorf("newest segment mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
@@ -0,0 +1,178 @@
+// Package ratelimit limits the rate of requests and the volume of metrics accepted from each client.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often idle clients are forgotten.
+const sweepInterval = time.Minute
+
+// LimitError is returned when a client exceeds a limit.
+type LimitError struct {
+	// Reason describes the exceeded limit.
+	Reason string
+	// RetryAfter is the time after which the client may try again.
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", e.Reason, e.RetryAfter)
+}
+
+// RetryAfterSeconds returns RetryAfter rounded up to whole seconds, at least 1, as used by the Retry-After header.
+func (e *LimitError) RetryAfterSeconds() int {
+	return max(1, int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token bucket rate limiter keyed by client.
+//
+// Each client may make burst requests at once and rate requests per second on average.
+// It is safe for concurrent use.
+type Limiter struct {
+	rate      float64
+	burst     float64
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewLimiter returns a Limiter allowing rate requests per second with bursts of burst requests.
+//
+// A burst less than 1 is replaced by rate rounded up, but not less than 1.
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = max(1, int(math.Ceil(rate)))
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the bucket of the client.
+//
+// It returns a *LimitError if the bucket is empty.
+func (l *Limiter) Allow(key string, now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return &LimitError{Reason: "rate limit exceeded", RetryAfter: wait}
+	}
+	b.tokens--
+	return nil
+}
+
+// sweep forgets clients whose buckets are full again.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+type window struct {
+	start   time.Time
+	samples int
+	series  map[string]struct{}
+}
+
+// Quota limits the number of distinct series and samples accepted from each client per minute.
+//
+// It is safe for concurrent use.
+type Quota struct {
+	maxSeries  int
+	maxSamples int
+	mu         sync.Mutex
+	windows    map[string]*window
+	lastSweep  time.Time
+}
+
+// NewQuota returns a Quota allowing maxSeries distinct series and maxSamples samples per minute.
+//
+// Zero disables the corresponding limit.
+func NewQuota(maxSeries, maxSamples int) *Quota {
+	return &Quota{
+		maxSeries:  maxSeries,
+		maxSamples: maxSamples,
+		windows:    make(map[string]*window),
+	}
+}
+
+// Allow accounts the samples of the given series for the client.
+//
+// Series are identified by name and type, each element of series is one sample.
+// If a quota would be exceeded, nothing is accounted and a *LimitError is returned.
+func (q *Quota) Allow(key string, series []string, now time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sweep(now)
+	w, ok := q.windows[key]
+	if !ok || now.Sub(w.start) >= time.Minute {
+		w = &window{start: now, series: make(map[string]struct{})}
+		q.windows[key] = w
+	}
+	retry := w.start.Add(time.Minute).Sub(now)
+	if q.maxSamples > 0 && w.samples+len(series) > q.maxSamples {
+		return &LimitError{Reason: fmt.Sprintf("quota of %d samples per minute exceeded", q.maxSamples), RetryAfter: retry}
+	}
+	var added []string
+	if q.maxSeries > 0 {
+		for _, s := range series {
+			if _, ok := w.series[s]; !ok {
+				w.series[s] = struct{}{}
+				added = append(added, s)
+			}
+		}
+		if len(w.series) > q.maxSeries {
+			for _, s := range added {
+				delete(w.series, s)
+			}
+			return &LimitError{Reason: fmt.Sprintf("quota of %d series per minute exceeded", q.maxSeries), RetryAfter: retry}
+		}
+	}
+	w.samples += len(series)
+	return nil
+}
+
+// sweep forgets clients whose windows have ended.
+func (q *Quota) sweep(now time.Time) {
+	if now.Sub(q.lastSweep) < sweepInterval {
+		return
+	}
+	q.lastSweep = now
+	for k, w := range q.windows {
+		if now.Sub(w.start) >= time.Minute {
+			delete(q.windows, k)
+		}
+	}
+}
+
+// SeriesKey returns the key identifying the series of a metric.
+func SeriesKey(mtype, id string) string {
+	return mtype + ":" + id
+}
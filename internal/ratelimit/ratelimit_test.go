@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	l := NewLimiter(2, 3)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.Allow("10.0.0.1", now); err != nil {
+			t.Fatalf("request %d of burst: %v", i, err)
+		}
+	}
+	err := l.Allow("10.0.0.1", now)
+	var le *LimitError
+	if !errors.As(err, &le) {
+		t.Fatalf("Allow() error = %v, want *LimitError", err)
+	}
+	if le.RetryAfter != 500*time.Millisecond || le.RetryAfterSeconds() != 1 {
+		t.Errorf("RetryAfter = %v (%ds), want 500ms (1s)", le.RetryAfter, le.RetryAfterSeconds())
+	}
+	if err := l.Allow("10.0.0.2", now); err != nil {
+		t.Errorf("other client must not be limited: %v", err)
+	}
+	if err := l.Allow("10.0.0.1", now.Add(500*time.Millisecond)); err != nil {
+		t.Errorf("token must be refilled: %v", err)
+	}
+}
+
+func TestQuota_Allow(t *testing.T) {
+	q := NewQuota(2, 5)
+	now := time.Now()
+	agent := "10.0.0.1"
+	if err := q.Allow(agent, []string{"gauge:Alloc", "counter:PollCount", "gauge:Alloc"}, now); err != nil {
+		t.Fatalf("Allow() error = %v", err)
+	}
+	var le *LimitError
+	if err := q.Allow(agent, []string{"gauge:Frees"}, now); !errors.As(err, &le) {
+		t.Errorf("third series: error = %v, want *LimitError", err)
+	}
+	if err := q.Allow(agent, []string{"gauge:Alloc", "counter:PollCount"}, now.Add(time.Second)); err != nil {
+		t.Errorf("known series: error = %v", err)
+	}
+	err := q.Allow(agent, []string{"gauge:Alloc"}, now.Add(10*time.Second))
+	if !errors.As(err, &le) {
+		t.Fatalf("sixth sample: error = %v, want *LimitError", err)
+	}
+	if le.RetryAfter != 50*time.Second {
+		t.Errorf("RetryAfter = %v, want %v", le.RetryAfter, 50*time.Second)
+	}
+	if err := q.Allow(agent, []string{"gauge:Frees"}, now.Add(time.Minute)); err != nil {
+		t.Errorf("new window: error = %v", err)
+	}
+}
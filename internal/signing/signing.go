@@ -0,0 +1,147 @@
+// Package signing signs requests with HMAC-SHA256 and protects the server from replayed requests.
+//
+// The signature covers the timestamp and the nonce of the request together with the body,
+// so a captured request can't be replayed after the window or twice within it.
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Заголовки подписанного запроса. В gRPC используются такие же ключи метаданных.
+const (
+	// HashHeader holds the hex HMAC-SHA256 signature.
+	HashHeader = "HashSHA256"
+	// TimestampHeader holds the time of signing in Unix seconds.
+	TimestampHeader = "X-Timestamp"
+	// NonceHeader holds a random string unique for each request.
+	NonceHeader = "X-Nonce"
+)
+
+// Window is the allowed difference between the request timestamp and the server clock.
+const Window = 5 * time.Minute
+
+// Ошибки проверки подписанного запроса.
+var (
+	ErrNoTimestamp = errors.New("no timestamp or nonce")
+	ErrStale       = errors.New("stale timestamp")
+	ErrReplay      = errors.New("nonce already used")
+)
+
+// Sign returns the HMAC-SHA256 of the timestamp, the nonce and the body.
+func Sign(key []byte, timestamp, nonce string, body []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(message(timestamp, nonce, body))
+	return h.Sum(nil)
+}
+
+// SignHex returns Sign encoded as hex as sent in the HashSHA256 header.
+func SignHex(key []byte, timestamp, nonce string, body []byte) string {
+	return hex.EncodeToString(Sign(key, timestamp, nonce, body))
+}
+
+// Timestamp formats the time as sent in the X-Timestamp header.
+func Timestamp(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// NewNonce returns a random nonce.
+func NewNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Guard remembers nonces of accepted requests within the window.
+// It is safe for concurrent use.
+type Guard struct {
+	window time.Duration
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	sweep  time.Time
+}
+
+// NewGuard returns a guard rejecting timestamps that differ from the server clock by more than window.
+func NewGuard(window time.Duration) *Guard {
+	return &Guard{window: window, seen: make(map[string]time.Time)}
+}
+
+// Check rejects stale timestamps and nonces already seen within the window, and remembers the nonce.
+// It must be called after the signature is verified, so that only signed nonces are remembered.
+func (g *Guard) Check(timestamp, nonce string, now time.Time) error {
+	if timestamp == "" || nonce == "" {
+		return ErrNoTimestamp
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNoTimestamp, err)
+	}
+	ts := time.Unix(sec, 0)
+	if ts.Before(now.Add(-g.window)) || ts.After(now.Add(g.window)) {
+		return fmt.Errorf("%w: %s", ErrStale, ts.UTC().Format(time.RFC3339))
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if now.Sub(g.sweep) >= g.window {
+		for n, expire := range g.seen {
+			if !expire.After(now) {
+				delete(g.seen, n)
+			}
+		}
+		g.sweep = now
+	}
+	if expire, ok := g.seen[nonce]; ok && expire.After(now) {
+		return ErrReplay
+	}
+	// Запрос с этим timestamp будет отклонен как устаревший после ts+window, дальше nonce хранить не нужно.
+	g.seen[nonce] = ts.Add(g.window)
+	return nil
+}
+
+// Заголовки запроса, подписанного ключом агента.
+const (
+	// AgentHeader holds the ID of the agent in the registry.
+	AgentHeader = "X-Agent-ID"
+	// SignatureHeader holds the base64 Ed25519 signature.
+	SignatureHeader = "X-Signature"
+)
+
+// ErrInvalidSignature is returned when the Ed25519 signature doesn't match.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// message returns the signed data: the timestamp, the nonce and the body separated by newlines.
+func message(timestamp, nonce string, body []byte) []byte {
+	msg := make([]byte, 0, len(timestamp)+len(nonce)+len(body)+2)
+	msg = append(msg, timestamp...)
+	msg = append(msg, '\n')
+	msg = append(msg, nonce...)
+	msg = append(msg, '\n')
+	return append(msg, body...)
+}
+
+// SignEd25519 returns the base64 Ed25519 signature of the timestamp, the nonce and the body.
+func SignEd25519(key ed25519.PrivateKey, timestamp, nonce string, body []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, message(timestamp, nonce, body)))
+}
+
+// VerifyEd25519 checks the signature made by SignEd25519.
+func VerifyEd25519(key ed25519.PublicKey, timestamp, nonce string, body []byte, sign string) error {
+	sig, err := base64.StdEncoding.DecodeString(sign)
+	if err != nil || !ed25519.Verify(key, message(timestamp, nonce, body), sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package signing
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGuard_Check(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	g := NewGuard(time.Minute)
+
+	tests := []struct {
+		name      string
+		timestamp string
+		nonce     string
+		now       time.Time
+		want      error
+	}{
+		{"fresh", Timestamp(now), "a", now, nil},
+		{"replay", Timestamp(now), "a", now.Add(time.Second), ErrReplay},
+		{"other nonce", Timestamp(now), "b", now, nil},
+		{"stale", Timestamp(now.Add(-2 * time.Minute)), "c", now, ErrStale},
+		{"future", Timestamp(now.Add(2 * time.Minute)), "d", now, ErrStale},
+		{"no nonce", Timestamp(now), "", now, ErrNoTimestamp},
+		{"bad timestamp", "yesterday", "e", now, ErrNoTimestamp},
+		{"replay after window is stale", Timestamp(now), "a", now.Add(2 * time.Minute), ErrStale},
+		{"nonce reused with new timestamp", Timestamp(now.Add(2 * time.Minute)), "a", now.Add(2 * time.Minute), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := g.Check(tt.timestamp, tt.nonce, tt.now); !errors.Is(err, tt.want) {
+				t.Errorf("Check() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+	if len(g.seen) != 1 {
+		t.Errorf("expired nonces must be swept, got %v", g.seen)
+	}
+}
+
+func TestSign(t *testing.T) {
+	key := []byte("secret")
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	sign := SignHex(key, "1700000000", "a", body)
+	if sign != SignHex(key, "1700000000", "a", body) {
+		t.Error("SignHex() must be deterministic")
+	}
+	if sign == SignHex(key, "1700000001", "a", body) || sign == SignHex(key, "1700000000", "b", body) {
+		t.Error("SignHex() must depend on the timestamp and the nonce")
+	}
+	if NewNonce() == NewNonce() {
+		t.Error("NewNonce() must be random")
+	}
+}
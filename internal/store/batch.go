@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// BatchTTL is how long applied batch IDs are remembered.
+//
+// A batch retried after this period is applied again.
+const BatchTTL = 10 * time.Minute
+
+type batchIDKey struct{}
+
+// WithBatchID returns a copy of ctx carrying the ID of the batch of metrics.
+//
+// AddMetrics ignores a batch whose ID was already applied within BatchTTL.
+func WithBatchID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, batchIDKey{}, id)
+}
+
+// BatchIDFromContext returns the batch ID stored in ctx by WithBatchID.
+func BatchIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(batchIDKey{}).(string)
+	return id, ok
+}
+
+// BatchChecker is implemented by storages which remember applied batches.
+//
+// The servers do not charge quotas for retries of applied batches, which AddMetrics ignores anyway.
+type BatchChecker interface {
+	// BatchApplied reports whether the batch was applied within BatchTTL.
+	BatchApplied(ctx context.Context, id string) (bool, error)
+}
+
+// BatchApplied reports whether the batch was applied within BatchTTL.
+func (s *MemStorage) BatchApplied(_ context.Context, id string) (bool, error) {
+	defer s.rlock()()
+	return s.batches != nil && s.batches.applied(id, time.Now()), nil
+}
+
+// batchEntry is an applied batch in the order of expiration.
+type batchEntry struct {
+	id      string
+	applied time.Time
+}
+
+// batches remembers IDs of applied batches.
+//
+// Batches are applied in time order, so they expire from the head of the queue
+// and forgetting them costs nothing while no batch expires.
+// It is not safe for concurrent use, the caller must hold the storage lock.
+type batches struct {
+	ids   map[string]time.Time
+	queue []batchEntry
+}
+
+func newBatches() *batches {
+	return &batches{ids: make(map[string]time.Time)}
+}
+
+// applied reports whether the batch was applied within BatchTTL.
+func (b *batches) applied(id string, now time.Time) bool {
+	applied, ok := b.ids[id]
+	return ok && now.Sub(applied) <= BatchTTL
+}
+
+// seen reports whether the batch was applied within BatchTTL and forgets expired batches.
+func (b *batches) seen(id string, now time.Time) bool {
+	n := 0
+	for n < len(b.queue) && now.Sub(b.queue[n].applied) > BatchTTL {
+		e := b.queue[n]
+		// Пакет мог быть применен повторно после истечения срока, тогда его запись новее.
+		if b.ids[e.id].Equal(e.applied) {
+			delete(b.ids, e.id)
+		}
+		n++
+	}
+	b.queue = b.queue[n:]
+	return b.applied(id, now)
+}
+
+// add remembers the applied batch.
+func (b *batches) add(id string, now time.Time) {
+	b.ids[id] = now
+	b.queue = append(b.queue, batchEntry{id: id, applied: now})
+}
@@ -9,6 +9,8 @@ import (
 	"log"
 	"log/slog"
 	"os"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgerrcode"
@@ -47,13 +49,20 @@ func (s *DBStorage) CreateTable() error {
 		CounterName,
 		GaugeName),
 	)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(context.Background(), `CREATE TABLE IF NOT EXISTS batches (
+			id TEXT PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL)`,
+	)
 	return err
 }
 
 // setBatch sets batch data in the database.
 //
 // The data is set in the given context with the given timeout.
-func setBatch(parent context.Context, db *sql.DB, m *MemStorage) error {
+func setBatch(parent context.Context, db *sql.DB, m *MemStorage, batchID string) error {
 
 	ctx, cancel := context.WithTimeout(parent, 120*time.Second)
 	defer cancel()
@@ -65,7 +74,7 @@ func setBatch(parent context.Context, db *sql.DB, m *MemStorage) error {
 	return conn.Raw(func(driverConn any) error {
 		conn := driverConn.(*stdlib.Conn).Conn() // conn is a *pgx.Conn
 		defer conn.Close(ctx)
-		return setBatchPgx(ctx, conn, m)
+		return setBatchPgx(ctx, conn, m, batchID)
 	})
 }
 
@@ -79,8 +88,22 @@ type PgxIface interface {
 	SendBatch(ctx context.Context, b *pgx.Batch) (br pgx.BatchResults)
 }
 
-func setBatchPgx(ctx context.Context, conn PgxIface, m *MemStorage) (err error) {
+// errDuplicateBatch is returned by setBatchPgx if the batch was already applied.
+var errDuplicateBatch = errors.New("duplicate batch")
+
+// setBatchPgx writes metrics in a single implicit transaction.
+//
+// If batchID is not empty, it is recorded in the batches table in the same transaction,
+// so a batch that was already applied is rolled back and errDuplicateBatch is returned.
+func setBatchPgx(ctx context.Context, conn PgxIface, m *MemStorage, batchID string) (err error) {
 	batch := &pgx.Batch{}
+	if batchID != "" {
+		now := time.Now()
+		batch.Queue("DELETE FROM batches WHERE applied_at < @expire", pgx.NamedArgs{"expire": now.Add(-BatchTTL)})
+		batch.Queue("INSERT INTO batches (id, applied_at) VALUES (@id, @now)", pgx.NamedArgs{"id": batchID, "now": now})
+	}
+	// Служебные запросы идут первыми, для них не проверяем число измененных строк.
+	service := batch.Len()
 	for id, val := range m.Gauge {
 		queryes := "INSERT INTO metrics (id, gauge) VALUES (@id, @val) ON CONFLICT (id) DO UPDATE SET gauge = @val"
 		log.Printf("query: %s |%v %v\n", queryes, id, val)
@@ -95,20 +118,23 @@ func setBatchPgx(ctx context.Context, conn PgxIface, m *MemStorage) (err error)
 
 	var errs []error
 
-	defer func() error {
-		err = br.Close()
-		if err != nil {
-			errs = append(errs, err)
+	defer func() {
+		closeErr := br.Close()
+		if closeErr != nil && !errors.Is(err, errDuplicateBatch) {
+			err = errors.Join(err, closeErr)
 		}
-		return errors.Join(errs...)
 	}()
 
 	for i := 0; i < batch.Len(); i++ {
 		ct, err := br.Exec()
+		if i == service-1 && isUniqueViolation(err) {
+			return errDuplicateBatch
+		}
 		if err != nil {
 			errs = append(errs, err)
+			continue
 		}
-		if ct.RowsAffected() != 1 {
+		if i >= service && ct.RowsAffected() != 1 {
 			errs = append(errs, fmt.Errorf("ct.RowsAffected() => %v, want %v", ct.RowsAffected(), 1))
 		}
 	}
@@ -116,6 +142,11 @@ func setBatchPgx(ctx context.Context, conn PgxIface, m *MemStorage) (err error)
 	return errors.Join(errs...)
 }
 
+func isUniqueViolation(err error) bool {
+	var e *pgconn.PgError
+	return errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation
+}
+
 // Add adds a metric to the database.
 //
 // The metric is added with the given type, name, and value.
@@ -149,13 +180,22 @@ func (s *DBStorage) Add(metricType string, metricName string, metricValue string
 // AddMetrics adds multiple metrics to the database.
 //
 // The metrics are added with the given context and metrics list.
+// If the context carries a batch ID (see WithBatchID) that was already applied, the metrics are ignored.
+// Without a batch ID a new one is generated, so retries after a lost commit do not apply counters twice.
 func (s *DBStorage) AddMetrics(ctx context.Context, m *mtr.MetricsList) error {
 
 	metrics := NewMemStorage()
-	metrics.AddMetrics(ctx, m)
+	if err := metrics.AddMetrics(context.Background(), m); err != nil {
+		return err
+	}
+
+	batchID, ok := BatchIDFromContext(ctx)
+	if !ok {
+		batchID = mtr.NewBatchID()
+	}
 
 	retry := 0
-	err := setBatch(ctx, s.db, metrics)
+	err := setBatch(ctx, s.db, metrics, batchID)
 	for needRetry(err) && retry < 3 {
 		select {
 		case <-ctx.Done():
@@ -164,13 +204,25 @@ func (s *DBStorage) AddMetrics(ctx context.Context, m *mtr.MetricsList) error {
 			after := (retry+1)*2 - 1
 			slog.Error(fmt.Sprintf("%s Retry %d ...", err.Error(), retry+1))
 			time.Sleep(time.Duration(after) * time.Second)
-			err = setBatch(ctx, s.db, metrics)
+			err = setBatch(ctx, s.db, metrics, batchID)
 			retry++
 		}
 	}
+	if errors.Is(err, errDuplicateBatch) {
+		slog.Info("Duplicate batch ignored", "batch_id", batchID)
+		return nil
+	}
 	return err
 }
 
+// BatchApplied reports whether the batch was applied within BatchTTL.
+func (s *DBStorage) BatchApplied(ctx context.Context, id string) (bool, error) {
+	var applied bool
+	row := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM batches WHERE id = $1 AND applied_at >= $2)", id, time.Now().Add(-BatchTTL))
+	err := row.Scan(&applied)
+	return applied, err
+}
+
 func needRetry(err error) bool {
 	var e *pgconn.PgError
 	return errors.As(err, &e) && pgerrcode.IsConnectionException(e.Code)
@@ -303,9 +355,136 @@ func (s *DBStorage) RestoreFromFile(path string) error {
 	if err != nil {
 		return err
 	}
-	err = setBatch(context.Background(), s.db, &metrics)
+	err = setBatch(context.Background(), s.db, &metrics, "")
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// Delete deletes metrics with the given names from the database.
+//
+// It returns the number of deleted metrics.
+func (s *DBStorage) Delete(ctx context.Context, names ...string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	deleted := 0
+	for _, name := range names {
+		res, err := tx.ExecContext(ctx, "DELETE FROM metrics WHERE id = $1", name)
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		deleted += int(n)
+	}
+	return deleted, tx.Commit()
+}
+
+// DeleteMatching deletes metrics whose names match the shell pattern (see path.Match).
+//
+// It returns the number of deleted metrics.
+func (s *DBStorage) DeleteMatching(ctx context.Context, pattern string) (int, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0, err
+	}
+	// LIKE отбирает кандидатов в базе, точное совпадение проверяется path.Match.
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM metrics WHERE id LIKE $1", likePattern(pattern))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		if ok, _ := path.Match(pattern, id); ok {
+			names = append(names, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(names) == 0 {
+		return 0, nil
+	}
+	return s.Delete(ctx, names...)
+}
+
+// likePattern converts the shell pattern to a LIKE pattern which matches at least the same names.
+// A character class becomes any single character, so the names are checked with path.Match too.
+func likePattern(pattern string) string {
+	var b strings.Builder
+	literal := func(c byte) {
+		if c == '%' || c == '_' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			b.WriteByte('%')
+		case '?':
+			b.WriteByte('_')
+		case '[':
+			for i++; i < len(pattern) && pattern[i] != ']'; i++ {
+				if pattern[i] == '\\' {
+					i++
+				}
+			}
+			b.WriteByte('_')
+		case '\\':
+			if i++; i < len(pattern) {
+				literal(pattern[i])
+			}
+		default:
+			literal(c)
+		}
+	}
+	return b.String()
+}
+
+// ResetCounter sets the counter with the given name to zero.
+func (s *DBStorage) ResetCounter(ctx context.Context, name string) error {
+	res, err := s.db.ExecContext(ctx, fmt.Sprintf("UPDATE metrics SET %s = 0 WHERE id = $1 AND %s IS NOT NULL", CounterName, CounterName), name)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Rename renames the metric from oldName to newName.
+//
+// It returns ErrNotFound if there is no metric with oldName and ErrExists if newName is already used.
+func (s *DBStorage) Rename(ctx context.Context, oldName, newName string) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE metrics SET id = $2 WHERE id = $1", oldName, newName)
+	if isUniqueViolation(err) {
+		return ErrExists
+	}
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -2,10 +2,13 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgerrcode"
+	pgconn "github.com/jackc/pgx/v5/pgconn"
 	"github.com/pashagolub/pgxmock/v4"
 )
 
@@ -20,7 +23,8 @@ func TestCreateTable(t *testing.T) {
 	store := NewDBStorage(db)
 	res := sqlmock.NewErrorResult(nil)
 
-	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS`).WillReturnResult(res)
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS metrics`).WillReturnResult(res)
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS batches`).WillReturnResult(res)
 
 	err = store.CreateTable()
 	if err != nil {
@@ -55,12 +59,45 @@ func TestSetBatchPgx(t *testing.T) {
 	eb.ExpectExec("INSERT INTO metrics").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnResult(pgxmock.NewResult("INSERT", 1))
 	eb.ExpectExec("INSERT INTO metrics").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnResult(pgxmock.NewResult("INSERT", 1))
 	eb.ExpectExec("INSERT INTO metrics").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnResult(pgxmock.NewResult("INSERT", 1))
-	err = setBatchPgx(ctx, mock, memstore)
+	err = setBatchPgx(ctx, mock, memstore, "")
 	if err != nil {
 		t.Error(err)
 	}
 }
 
+func TestSetBatchPgx_duplicate(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	mock, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close(ctx)
+
+	memstore := &MemStorage{
+		Counter: map[string]int64{
+			"PollCount": 1,
+		},
+	}
+
+	eb := mock.ExpectBatch()
+	eb.ExpectExec("DELETE FROM batches").WithArgs(pgxmock.AnyArg()).WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	eb.ExpectExec("INSERT INTO batches").WithArgs("batch1", pgxmock.AnyArg()).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	eb.ExpectExec("INSERT INTO metrics").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	if err = setBatchPgx(ctx, mock, memstore, "batch1"); err != nil {
+		t.Fatalf("setBatchPgx() error = %v", err)
+	}
+
+	eb = mock.ExpectBatch()
+	eb.ExpectExec("DELETE FROM batches").WithArgs(pgxmock.AnyArg()).WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	eb.ExpectExec("INSERT INTO batches").WithArgs("batch1", pgxmock.AnyArg()).
+		WillReturnError(&pgconn.PgError{Code: pgerrcode.UniqueViolation})
+	eb.ExpectExec("INSERT INTO metrics").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnError(errors.New("transaction is aborted"))
+	if err = setBatchPgx(ctx, mock, memstore, "batch1"); !errors.Is(err, errDuplicateBatch) {
+		t.Errorf("setBatchPgx() error = %v, want %v", err, errDuplicateBatch)
+	}
+}
+
 func TestString(t *testing.T) {
 	t.Parallel()
 	db, mock, err := sqlmock.New()
@@ -104,3 +141,76 @@ func TestGet(t *testing.T) {
 		t.Errorf("wrong value of item: %s", str)
 	}
 }
+
+func TestDBStorage_Admin(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store := NewDBStorage(db)
+
+	mock.ExpectQuery("SELECT id FROM metrics WHERE id LIKE").WithArgs(`cpu\_%`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("cpu_1").AddRow("cpu_2").AddRow("cpu_2/x"))
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM metrics").WithArgs("cpu_1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM metrics").WithArgs("cpu_2").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	n, err := store.DeleteMatching(ctx, "cpu_*")
+	if err != nil {
+		t.Fatalf("DBStorage.DeleteMatching() error = %v", err)
+	}
+	if n != 2 {
+		t.Errorf("DBStorage.DeleteMatching() = %v, want %v", n, 2)
+	}
+
+	mock.ExpectExec("UPDATE metrics SET counter = 0").WithArgs("undef").WillReturnResult(sqlmock.NewResult(0, 0))
+	if err = store.ResetCounter(ctx, "undef"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DBStorage.ResetCounter() error = %v, want %v", err, ErrNotFound)
+	}
+
+	mock.ExpectExec("UPDATE metrics SET id").WithArgs("PollCount", "taken").
+		WillReturnError(&pgconn.PgError{Code: pgerrcode.UniqueViolation})
+	if err = store.Rename(ctx, "PollCount", "taken"); !errors.Is(err, ErrExists) {
+		t.Errorf("DBStorage.Rename() error = %v, want %v", err, ErrExists)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLikePattern(t *testing.T) {
+	tests := map[string]string{
+		"cpu_*":          `cpu\_%`,
+		"disk?":          `disk_`,
+		"100%":           `100\%`,
+		`a\*b`:           `a*b`,
+		"core[0-9]_user": `core_\_user`,
+		`x[\]]*`:         `x_%`,
+	}
+	for pattern, want := range tests {
+		if got := likePattern(pattern); got != want {
+			t.Errorf("likePattern(%q) = %q, want %q", pattern, got, want)
+		}
+	}
+}
+
+func TestDBStorage_BatchApplied(t *testing.T) {
+	t.Parallel()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store := NewDBStorage(db)
+
+	mock.ExpectQuery("SELECT EXISTS").WithArgs("batch1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	applied, err := store.BatchApplied(context.Background(), "batch1")
+	if err != nil || !applied {
+		t.Errorf("DBStorage.BatchApplied() = %v, %v, want true", applied, err)
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"sort"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/mailru/easyjson"
 	mtr "github.com/xoxloviwan/go-monitor/internal/metrics_types"
@@ -30,6 +33,22 @@ type Gauge map[string]float64
 // The keys are the metric names and the values are the metric values.
 type Counter map[string]int64
 
+// ErrNotFound is returned when a metric does not exist in the storage.
+var ErrNotFound = errors.New("metric not found")
+
+// ErrExists is returned when a metric with the target name already exists in the storage.
+var ErrExists = errors.New("metric already exists")
+
+// Admin is an interface for administrative operations on metrics.
+//
+// It is implemented by both MemStorage and DBStorage and used by the HTTP and gRPC admin APIs.
+type Admin interface {
+	Delete(ctx context.Context, names ...string) (int, error)
+	DeleteMatching(ctx context.Context, pattern string) (int, error)
+	ResetCounter(ctx context.Context, name string) error
+	Rename(ctx context.Context, oldName, newName string) error
+}
+
 // easyjson:json
 
 // MemStorage is an in-memory storage implementation.
@@ -38,22 +57,49 @@ type Counter map[string]int64
 type MemStorage struct {
 	Gauge   `json:"gauge"`
 	Counter `json:"counter"`
+	// mu guards the maps of instances created by NewMemStorage.
+	// It is a pointer because easyjson generates methods with value receivers.
+	mu *sync.RWMutex
+	// batches holds IDs of recently applied batches of instances created by NewMemStorage.
+	batches *batches
 }
 
 // NewMemStorage returns a new MemStorage instance.
 //
-// The instance is initialized with empty Gauge and Counter maps.
+// The instance is initialized with empty Gauge and Counter maps and is safe for concurrent use.
 func NewMemStorage() *MemStorage {
 	return &MemStorage{
 		Gauge:   make(map[string]float64),
 		Counter: make(map[string]int64),
+		mu:      &sync.RWMutex{},
+		batches: newBatches(),
+	}
+}
+
+// lock locks the storage for writing and returns the unlock function.
+// Instances not created by NewMemStorage are not guarded.
+func (s *MemStorage) lock() func() {
+	if s.mu == nil {
+		return func() {}
 	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+// rlock locks the storage for reading and returns the unlock function.
+func (s *MemStorage) rlock() func() {
+	if s.mu == nil {
+		return func() {}
+	}
+	s.mu.RLock()
+	return s.mu.RUnlock
 }
 
 // Add adds a metric to the MemStorage instance.
 //
 // The metric is added with the given type, name, and value.
 func (s *MemStorage) Add(metricType string, metricName string, metricValue string) (err error) {
+	defer s.lock()()
 	switch metricType {
 	case CounterName:
 		res64, err := strconv.ParseInt(metricValue, 10, 64)
@@ -77,12 +123,25 @@ func (s *MemStorage) Add(metricType string, metricName string, metricValue strin
 // AddMetrics adds multiple metrics to the MemStorage instance.
 //
 // The metrics are added with the given context and metrics list.
+// If the context carries a batch ID (see WithBatchID) that was already applied, the metrics are ignored.
+// If any metric is invalid, none of the metrics are added.
 func (s *MemStorage) AddMetrics(ctx context.Context, m *mtr.MetricsList) error {
 	err := ctx.Err()
 	if err != nil {
 		return err
 	}
 
+	if err := validate(*m); err != nil {
+		return err
+	}
+
+	defer s.lock()()
+	batchID, hasBatch := BatchIDFromContext(ctx)
+	hasBatch = hasBatch && s.batches != nil
+	now := time.Now()
+	if hasBatch && s.batches.seen(batchID, now) {
+		return nil
+	}
 	for _, v := range *m {
 		if v.MType == GaugeName {
 			s.Gauge[v.ID] = *v.Value
@@ -91,9 +150,23 @@ func (s *MemStorage) AddMetrics(ctx context.Context, m *mtr.MetricsList) error {
 			s.Counter[v.ID] = *v.Delta + s.Counter[v.ID]
 		}
 	}
+	if hasBatch {
+		s.batches.add(batchID, now)
+	}
 	return nil
 }
 
+// validate checks all metrics of the list and returns errors of invalid ones.
+func validate(m mtr.MetricsList) error {
+	var errs []error
+	for i, v := range m {
+		if err := v.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("metric %d %q: %w", i, v.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // GetMetrics gets metrics from the MemStorage instance.
 //
 // The metrics are retrieved with the given context and metrics list.
@@ -118,6 +191,7 @@ func (s *MemStorage) GetMetrics(ctx context.Context, m mtr.MetricsList) (mtr.Met
 	}
 	sort.Strings(keys)
 
+	defer s.rlock()()
 	for _, id := range keys {
 		select {
 		case <-ctx.Done():
@@ -149,6 +223,7 @@ func (s *MemStorage) GetMetrics(ctx context.Context, m mtr.MetricsList) (mtr.Met
 //
 // The metric is retrieved with the given type and name.
 func (s *MemStorage) Get(metricType string, metricName string) (string, bool) {
+	defer s.rlock()()
 	switch metricType {
 	case CounterName:
 		res, ok := s.Counter[metricName]
@@ -173,6 +248,7 @@ func (s *MemStorage) Get(metricType string, metricName string) (string, bool) {
 
 // String returns a string representation of the MemStorage instance.
 func (s *MemStorage) String() string {
+	defer s.rlock()()
 	var res = ""
 	for metricName, metricValue := range s.Gauge {
 		res = res + metricName + "=" + strconv.FormatFloat(metricValue, 'f', -1, 64) + "\n"
@@ -186,8 +262,10 @@ func (s *MemStorage) String() string {
 // SaveToFile saves data to a file.
 //
 // The data is saved to the given file path.
-func (s MemStorage) SaveToFile(path string) error {
+func (s *MemStorage) SaveToFile(path string) error {
+	unlock := s.rlock()
 	data, err := easyjson.Marshal(s)
+	unlock()
 	if err != nil {
 		return err
 	}
@@ -203,5 +281,105 @@ func (s *MemStorage) RestoreFromFile(path string) error {
 	if err != nil {
 		return err
 	}
+	defer s.lock()()
 	return easyjson.Unmarshal(data, s)
 }
+
+// Delete deletes metrics of any type with the given names.
+//
+// It returns the number of deleted metrics.
+func (s *MemStorage) Delete(ctx context.Context, names ...string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	defer s.lock()()
+	deleted := 0
+	for _, name := range names {
+		deleted += s.deleteOne(name)
+	}
+	return deleted, nil
+}
+
+func (s *MemStorage) deleteOne(name string) int {
+	deleted := 0
+	if _, ok := s.Gauge[name]; ok {
+		delete(s.Gauge, name)
+		deleted++
+	}
+	if _, ok := s.Counter[name]; ok {
+		delete(s.Counter, name)
+		deleted++
+	}
+	return deleted
+}
+
+// DeleteMatching deletes metrics whose names match the shell pattern (see path.Match).
+//
+// It returns the number of deleted metrics.
+func (s *MemStorage) DeleteMatching(ctx context.Context, pattern string) (int, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	defer s.lock()()
+	deleted := 0
+	for _, names := range [][]string{mapKeys(s.Gauge), mapKeys(s.Counter)} {
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, name); ok {
+				deleted += s.deleteOne(name)
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// ResetCounter sets the counter with the given name to zero.
+func (s *MemStorage) ResetCounter(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer s.lock()()
+	if _, ok := s.Counter[name]; !ok {
+		return ErrNotFound
+	}
+	s.Counter[name] = 0
+	return nil
+}
+
+// Rename renames metrics of any type from oldName to newName.
+//
+// It returns ErrNotFound if there is no metric with oldName and ErrExists if newName is already used.
+func (s *MemStorage) Rename(ctx context.Context, oldName, newName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer s.lock()()
+	g, gok := s.Gauge[oldName]
+	c, cok := s.Counter[oldName]
+	if !gok && !cok {
+		return ErrNotFound
+	}
+	_, gExists := s.Gauge[newName]
+	_, cExists := s.Counter[newName]
+	if gExists || cExists {
+		return ErrExists
+	}
+	s.deleteOne(oldName)
+	if gok {
+		s.Gauge[newName] = g
+	}
+	if cok {
+		s.Counter[newName] = c
+	}
+	return nil
+}
+
+func mapKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -2,8 +2,10 @@ package store
 
 import (
 	"context"
+	"errors"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	mtr "github.com/xoxloviwan/go-monitor/internal/metrics_types"
@@ -136,3 +138,123 @@ func TestMemStorage_AddMetricsGetMetrics(t *testing.T) {
 	}
 
 }
+
+func TestMemStorage_Admin(t *testing.T) {
+	ctx := context.Background()
+	s := setup(t)
+	s.Gauge["cpu_1"] = 1
+	s.Gauge["cpu_2"] = 2
+	s.Gauge["Alloc"] = 3
+	s.Counter["PollCount"] = 10
+	s.Counter["Alloc"] = 5
+
+	n, err := s.Delete(ctx, "Alloc", "undef")
+	if err != nil {
+		t.Fatalf("MemStorage.Delete() error = %v", err)
+	}
+	if n != 2 {
+		t.Errorf("MemStorage.Delete() = %v, want %v", n, 2)
+	}
+
+	n, err = s.DeleteMatching(ctx, "cpu_*")
+	if err != nil {
+		t.Fatalf("MemStorage.DeleteMatching() error = %v", err)
+	}
+	if n != 2 || len(s.Gauge) != 0 {
+		t.Errorf("MemStorage.DeleteMatching() = %v, gauges left %v", n, s.Gauge)
+	}
+	if _, err = s.DeleteMatching(ctx, "[-"); err == nil {
+		t.Error("MemStorage.DeleteMatching() with bad pattern must return error")
+	}
+
+	if err = s.ResetCounter(ctx, "PollCount"); err != nil {
+		t.Errorf("MemStorage.ResetCounter() error = %v", err)
+	}
+	if s.Counter["PollCount"] != 0 {
+		t.Errorf("MemStorage.ResetCounter() = %v, want 0", s.Counter["PollCount"])
+	}
+	if err = s.ResetCounter(ctx, "undef"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("MemStorage.ResetCounter(undef) error = %v, want %v", err, ErrNotFound)
+	}
+
+	s.Counter["PollCount"] = 7
+	if err = s.Rename(ctx, "PollCount", "Polls"); err != nil {
+		t.Errorf("MemStorage.Rename() error = %v", err)
+	}
+	if _, ok := s.Counter["PollCount"]; ok || s.Counter["Polls"] != 7 {
+		t.Errorf("MemStorage.Rename() counters = %v", s.Counter)
+	}
+	if err = s.Rename(ctx, "undef", "other"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("MemStorage.Rename(undef) error = %v, want %v", err, ErrNotFound)
+	}
+	s.Gauge["taken"] = 1
+	if err = s.Rename(ctx, "Polls", "taken"); !errors.Is(err, ErrExists) {
+		t.Errorf("MemStorage.Rename(taken) error = %v, want %v", err, ErrExists)
+	}
+}
+
+func TestMemStorage_AddMetricsBatch(t *testing.T) {
+	s := NewMemStorage()
+	delta := int64(2)
+	m := mtr.MetricsList{{ID: "PollCount", MType: CounterName, Delta: &delta}}
+	ctx := WithBatchID(context.Background(), "batch1")
+	for i := 0; i < 3; i++ {
+		if err := s.AddMetrics(ctx, &m); err != nil {
+			t.Fatalf("MemStorage.AddMetrics() error = %v", err)
+		}
+	}
+	if s.Counter["PollCount"] != 2 {
+		t.Errorf("counter after retries of the same batch = %v, want %v", s.Counter["PollCount"], 2)
+	}
+	if err := s.AddMetrics(WithBatchID(context.Background(), "batch2"), &m); err != nil {
+		t.Fatalf("MemStorage.AddMetrics() error = %v", err)
+	}
+	if s.Counter["PollCount"] != 4 {
+		t.Errorf("counter after a new batch = %v, want %v", s.Counter["PollCount"], 4)
+	}
+
+	s.batches.queue[0].applied = time.Now().Add(-2 * BatchTTL)
+	s.batches.ids["batch1"] = s.batches.queue[0].applied
+	if err := s.AddMetrics(ctx, &m); err != nil {
+		t.Fatalf("MemStorage.AddMetrics() error = %v", err)
+	}
+	if s.Counter["PollCount"] != 6 {
+		t.Errorf("counter after an expired batch = %v, want %v", s.Counter["PollCount"], 6)
+	}
+}
+
+func TestMemStorage_AddMetricsInvalid(t *testing.T) {
+	s := NewMemStorage()
+	value := 1.5
+	m := mtr.MetricsList{
+		{ID: "Alloc", MType: GaugeName, Value: &value},
+		{ID: "PollCount", MType: CounterName},
+		{ID: "Frees", MType: "histogram", Value: &value},
+	}
+	err := s.AddMetrics(context.Background(), &m)
+	if !errors.Is(err, mtr.ErrNoValue) || !errors.Is(err, mtr.ErrUnknownType) {
+		t.Errorf("MemStorage.AddMetrics() error = %v, want %v and %v", err, mtr.ErrNoValue, mtr.ErrUnknownType)
+	}
+	if len(s.Gauge) != 0 || len(s.Counter) != 0 {
+		t.Errorf("invalid batch must not be applied, got %v %v", s.Gauge, s.Counter)
+	}
+}
+
+func TestBatches_expire(t *testing.T) {
+	b := newBatches()
+	start := time.Now()
+	b.add("batch1", start)
+	b.add("batch2", start.Add(time.Minute))
+	if !b.seen("batch1", start.Add(time.Minute)) {
+		t.Error("batch1 is not seen within BatchTTL")
+	}
+	// Повторно примененный после истечения срока пакет не забывается по старой записи.
+	later := start.Add(BatchTTL + time.Second)
+	if b.seen("batch1", later) {
+		t.Error("expired batch1 is seen")
+	}
+	b.add("batch1", later)
+	if !b.seen("batch1", later.Add(time.Minute)) || len(b.ids) != 1 || len(b.queue) != 1 {
+		t.Errorf("after expiration ids = %v, queue = %v", b.ids, b.queue)
+	}
+}
@@ -0,0 +1,300 @@
+// Package telemetry contains the server's self-instrumentation: labelled counters
+// and histograms that are exported in the Prometheus text format and can be
+// flattened into regular gauge metrics.
+package telemetry
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix is prepended to the name of every self-metric.
+const Prefix = "go_monitor_"
+
+// DefBuckets are the default histogram buckets in seconds.
+var DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds all self-metrics of the server.
+//
+// It is safe for concurrent use.
+type Registry struct {
+	mu         sync.RWMutex
+	counters   []*CounterVec
+	histograms []*HistogramVec
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Counter registers a new counter family with the given label names.
+//
+// The Prefix is added to the name automatically.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		name:   Prefix + name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]*counter),
+	}
+	r.mu.Lock()
+	r.counters = append(r.counters, c)
+	r.mu.Unlock()
+	return c
+}
+
+// Histogram registers a new histogram family with the given buckets and label names.
+//
+// The Prefix is added to the name automatically. If buckets is nil DefBuckets are used.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	h := &HistogramVec{
+		name:    Prefix + name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+	r.mu.Lock()
+	r.histograms = append(r.histograms, h)
+	r.mu.Unlock()
+	return h
+}
+
+type counter struct {
+	labels []string
+	value  float64
+}
+
+// CounterVec is a family of counters partitioned by label values.
+type CounterVec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]*counter
+}
+
+// Add adds v to the counter with the given label values.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cnt, ok := c.values[key]
+	if !ok {
+		cnt = &counter{labels: labelValues}
+		c.values[key] = cnt
+	}
+	cnt.value += v
+}
+
+// Inc increments the counter with the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+type histogram struct {
+	labels []string
+	counts []uint64 // counts[i] - number of observations <= buckets[i]
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec is a family of histograms partitioned by label values.
+type HistogramVec struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	values  map[string]*histogram
+}
+
+// Observe adds a single observation to the histogram with the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{labels: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+// Since observes the time elapsed since start in seconds.
+func (h *HistogramVec) Since(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		var v string
+		if i < len(values) {
+			v = values[i]
+		}
+		fmt.Fprintf(&b, "%s=%s", n, strconv.Quote(v))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%s", extra[i], strconv.Quote(extra[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.counters {
+		c.mu.Lock()
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for _, k := range sortedKeys(c.values) {
+			v := c.values[k]
+			fmt.Fprintf(bw, "%s%s %s\n", c.name, formatLabels(c.labels, v.labels), formatFloat(v.value))
+		}
+		c.mu.Unlock()
+	}
+	for _, h := range r.histograms {
+		h.mu.Lock()
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+		for _, k := range sortedKeys(h.values) {
+			v := h.values[k]
+			for i, b := range h.buckets {
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, v.labels, "le", formatFloat(b)), v.counts[i])
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, v.labels, "le", "+Inf"), v.count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", h.name, formatLabels(h.labels, v.labels), formatFloat(v.sum))
+			fmt.Fprintf(bw, "%s_count%s %d\n", h.name, formatLabels(h.labels, v.labels), v.count)
+		}
+		h.mu.Unlock()
+	}
+	return bw.Flush()
+}
+
+// flatName builds a metric name usable in the metrics store: the label values
+// are appended to the name and all characters except letters, digits and
+// underscores are replaced with underscores.
+func flatName(name string, labelValues []string, suffix string) string {
+	parts := append([]string{name}, labelValues...)
+	if suffix != "" {
+		parts = append(parts, suffix)
+	}
+	s := strings.Join(parts, "_")
+	b := []byte(s)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// Snapshot returns the current values flattened to plain gauges.
+//
+// Histograms are represented by their observation count and sum.
+// Cumulative values are returned as gauges so that storing a snapshot
+// repeatedly never double-counts.
+func (r *Registry) Snapshot() map[string]float64 {
+	res := make(map[string]float64)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.counters {
+		c.mu.Lock()
+		for _, v := range c.values {
+			res[flatName(c.name, v.labels, "")] = v.value
+		}
+		c.mu.Unlock()
+	}
+	for _, h := range r.histograms {
+		h.mu.Lock()
+		for _, v := range h.values {
+			res[flatName(h.name, v.labels, "count")] = float64(v.count)
+			res[flatName(h.name, v.labels, "sum")] = v.sum
+		}
+		h.mu.Unlock()
+	}
+	return res
+}
+
+// Server groups the self-metrics collected by the server.
+type Server struct {
+	*Registry
+	// HTTPRequests counts HTTP requests by method, route and status.
+	HTTPRequests *CounterVec
+	// HTTPDuration tracks HTTP request latency by method, route and status.
+	HTTPDuration *HistogramVec
+	// GRPCRequests counts gRPC calls by method and status code.
+	GRPCRequests *CounterVec
+	// GRPCDuration tracks gRPC call latency by method and status code.
+	GRPCDuration *HistogramVec
+	// StoreDuration tracks latency of storage operations.
+	StoreDuration *HistogramVec
+	// StoreErrors counts failed storage operations.
+	StoreErrors *CounterVec
+	// BackupDuration tracks the time spent saving the backup file.
+	BackupDuration *HistogramVec
+	// BackupErrors counts failed backups.
+	BackupErrors *CounterVec
+}
+
+// NewServer returns a Server with all server self-metrics registered.
+func NewServer() *Server {
+	r := NewRegistry()
+	return &Server{
+		Registry:       r,
+		HTTPRequests:   r.Counter("http_requests_total", "Total number of HTTP requests.", "method", "route", "status"),
+		HTTPDuration:   r.Histogram("http_request_duration_seconds", "HTTP request latency.", nil, "method", "route", "status"),
+		GRPCRequests:   r.Counter("grpc_requests_total", "Total number of gRPC calls.", "method", "code"),
+		GRPCDuration:   r.Histogram("grpc_request_duration_seconds", "gRPC call latency.", nil, "method", "code"),
+		StoreDuration:  r.Histogram("store_operation_duration_seconds", "Storage operation latency.", nil, "op"),
+		StoreErrors:    r.Counter("store_errors_total", "Total number of failed storage operations.", "op"),
+		BackupDuration: r.Histogram("backup_duration_seconds", "Time spent saving metrics to the backup file.", nil),
+		BackupErrors:   r.Counter("backup_errors_total", "Total number of failed backups."),
+	}
+}
@@ -0,0 +1,62 @@
+package telemetry
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("requests_total", "Total requests.", "route", "status")
+	h := r.Histogram("duration_seconds", "Latency.", []float64{0.1, 1}, "route")
+
+	c.Inc("/update/", "200")
+	c.Inc("/update/", "200")
+	c.Add(3, "/value/", "404")
+	h.Observe(0.05, "/update/")
+	h.Observe(0.5, "/update/")
+	h.Observe(5, "/update/")
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	wantLines := []string{
+		"# TYPE go_monitor_requests_total counter",
+		`go_monitor_requests_total{route="/update/",status="200"} 2`,
+		`go_monitor_requests_total{route="/value/",status="404"} 3`,
+		"# TYPE go_monitor_duration_seconds histogram",
+		`go_monitor_duration_seconds_bucket{route="/update/",le="0.1"} 1`,
+		`go_monitor_duration_seconds_bucket{route="/update/",le="1"} 2`,
+		`go_monitor_duration_seconds_bucket{route="/update/",le="+Inf"} 3`,
+		`go_monitor_duration_seconds_sum{route="/update/"} 5.55`,
+		`go_monitor_duration_seconds_count{route="/update/"} 3`,
+	}
+	for _, line := range wantLines {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("line %q not found in output:\n%s", line, got)
+		}
+	}
+}
+
+func TestRegistry_Snapshot(t *testing.T) {
+	s := NewServer()
+	s.HTTPRequests.Inc("POST", "/updates/", "200")
+	s.StoreDuration.Observe(0.25, "AddMetrics")
+	s.BackupErrors.Inc()
+
+	got := s.Snapshot()
+	want := map[string]float64{
+		"go_monitor_http_requests_total_POST__updates__200":            1,
+		"go_monitor_store_operation_duration_seconds_AddMetrics_count": 1,
+		"go_monitor_store_operation_duration_seconds_AddMetrics_sum":   0.25,
+		"go_monitor_backup_errors_total":                               1,
+	}
+	for name, v := range want {
+		if got[name] != v {
+			t.Errorf("Snapshot()[%s] = %v, want %v", name, got[name], v)
+		}
+	}
+}
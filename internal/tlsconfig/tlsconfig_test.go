@@ -0,0 +1,129 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type certFiles struct {
+	cert, key string
+}
+
+// issue creates a certificate signed by parent, or a self-signed CA if parent is nil, and writes it to dir.
+func issue(t *testing.T, dir, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (certFiles, *x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := certFiles{cert: filepath.Join(dir, cn+".crt"), key: filepath.Join(dir, cn+".key")}
+	if err = os.WriteFile(f.cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(f.key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, cert, key
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caCert, caKey := issue(t, dir, "ca", nil, nil)
+	srvFiles, _, _ := issue(t, dir, "server", caCert, caKey)
+	agentFiles, _, _ := issue(t, dir, "agent-1", caCert, caKey)
+
+	srvCfg, err := Server(srvFiles.cert, srvFiles.key, ca.cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, PeerName(r.TLS))
+	}))
+	srv.TLS = srvCfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(caFile, certFile, keyFile string) (string, error) {
+		cfg, err := Client(caFile, certFile, keyFile)
+		if err != nil {
+			return "", err
+		}
+		cl := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		resp, err := cl.Get(srv.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	name, err := get(ca.cert, agentFiles.cert, agentFiles.key)
+	if err != nil {
+		t.Fatalf("request with client certificate error = %v", err)
+	}
+	if name != "agent-1" {
+		t.Errorf("PeerName() = %q, want %q", name, "agent-1")
+	}
+	if _, err = get(ca.cert, "", ""); err == nil {
+		t.Error("request without client certificate must fail")
+	}
+	if _, err = get("", agentFiles.cert, agentFiles.key); err == nil {
+		t.Error("request without CA must fail to verify the server")
+	}
+}
+
+func TestServer_badCA(t *testing.T) {
+	dir := t.TempDir()
+	srvFiles, _, _ := issue(t, dir, "server", nil, nil)
+	bad := filepath.Join(dir, "bad.pem")
+	if err := os.WriteFile(bad, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Server(srvFiles.cert, srvFiles.key, bad); !errors.Is(err, ErrNoCertificates) {
+		t.Errorf("Server() error = %v, want %v", err, ErrNoCertificates)
+	}
+	if PeerName(nil) != "" {
+		t.Error("PeerName(nil) must be empty")
+	}
+}